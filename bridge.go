@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// bridge owns everything a configuration reload can change: the outputs, one
+// poller per configured sensor, the PurpleAir API poller and the push
+// receiver.
+type bridge struct {
+	ctx context.Context
+
+	mu      sync.Mutex
+	cfg     tomlConfig
+	outputs *dispatcher
+	capture *captureWriter
+	pollers map[string]*poller // keyed by sensor URL
+	cloud   *poller            // polls the PurpleAir API, if configured
+	push    *pushReceiver      // accepts readings pushed by sensors, if configured
+}
+
+type poller struct {
+	cfg    tomlConfigPurpleAir
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newBridge builds the outputs for cfg and starts polling every sensor. The
+// pollers run until ctx is cancelled or the bridge is closed.
+func newBridge(ctx context.Context, cfg tomlConfig) (*bridge, error) {
+	sinks, err := buildSinks(cfg)
+	if err != nil {
+		return nil, err
+	}
+	b := &bridge{
+		ctx:     ctx,
+		cfg:     cfg,
+		outputs: newDispatcher(sinks, cfg.Output),
+		capture: newCaptureWriter(cfg.Capture),
+		pollers: map[string]*poller{},
+	}
+	if receiverConfigured(cfg.Receiver) {
+		if err := b.startReceiver(cfg.Receiver); err != nil {
+			b.outputs.Close(context.Background())
+			return nil, err
+		}
+	}
+	for _, sensor := range cfg.PurpleAir {
+		b.startPoller(sensor)
+	}
+	if cloudConfigured(cfg.Cloud) {
+		b.startCloud(cfg.Cloud)
+	}
+	return b, nil
+}
+
+func (b *bridge) startPoller(cfg tomlConfigPurpleAir) {
+	ctx, cancel := context.WithCancel(b.ctx)
+	p := &poller{cfg: cfg, cancel: cancel, done: make(chan struct{})}
+	b.pollers[cfg.Url] = p
+
+	logger.Infof("HTTP Target: %s", cfg.Url)
+	timeout := 15
+	if cfg.Timeout > 0 {
+		timeout = cfg.Timeout
+	}
+	myClient := &http.Client{Timeout: time.Duration(timeout) * time.Second}
+	outputs, capture := b.outputs, b.capture
+	go func() {
+		defer close(p.done)
+		poll(ctx, cfg, myClient, outputs, capture)
+	}()
+}
+
+func (b *bridge) stopPoller(url string) {
+	p := b.pollers[url]
+	p.cancel()
+	<-p.done
+	delete(b.pollers, url)
+}
+
+func (b *bridge) startCloud(cfg tomlConfigCloud) {
+	ctx, cancel := context.WithCancel(b.ctx)
+	p := &poller{cancel: cancel, done: make(chan struct{})}
+	b.cloud = p
+
+	logger.Infof("PurpleAir API Target: %s", cfg.Url)
+	source := newCloudSource(cfg, &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second})
+	outputs := b.outputs
+	go func() {
+		defer close(p.done)
+		pollCloud(ctx, source, outputs)
+	}()
+}
+
+func (b *bridge) stopCloud() {
+	if b.cloud == nil {
+		return
+	}
+	b.cloud.cancel()
+	<-b.cloud.done
+	b.cloud = nil
+}
+
+func (b *bridge) startReceiver(cfg tomlConfigReceiver) error {
+	r := newPushReceiver(cfg, b.outputs, b.capture)
+	if err := r.Start(); err != nil {
+		return err
+	}
+	b.push = r
+	return nil
+}
+
+// stopReceiver shuts the receiver down, cutting off pushes still being
+// handled when ctx is done.
+func (b *bridge) stopReceiver(ctx context.Context) {
+	if b.push == nil {
+		return
+	}
+	if err := b.push.Close(ctx); err != nil {
+		logger.Errorf("error stopping receiver: %s", err)
+	}
+	b.push = nil
+}
+
+// Reload applies a new, already validated configuration. Outputs are only
+// rebuilt (and so only reconnect) if their section of the configuration
+// changed, even when the queues in front of them are replaced, and only
+// added, removed or changed sensors have their pollers started or stopped.
+// An output or receiver that can't be started with its new section, say
+// because its port is taken, is started again with its old one, and keeps it
+// until a later reload changes it.
+func (b *bridge) Reload(cfg tomlConfig) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	old := b.cfg
+
+	if !reflect.DeepEqual(old.Output, cfg.Output) {
+		// queue settings apply to every worker, so hand the sinks over to a
+		// new dispatcher; they're only rebuilt below if their own section
+		// changed
+		logger.Info("Output settings changed; restarting all output queues")
+		// the deadline covers stopping the inputs too, since they may be
+		// blocked on a full queue
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(b.outputs.cfg.ShutdownTimeout)*time.Second)
+		for url := range b.pollers {
+			b.stopPoller(url)
+		}
+		b.stopCloud()
+		b.stopReceiver(shutdownCtx)
+		sinks := b.outputs.Detach(shutdownCtx)
+		cancel()
+		b.outputs = newDispatcher(sinks, cfg.Output)
+	}
+	for _, kind := range sinkKinds {
+		if reflect.DeepEqual(kind.section(old), kind.section(cfg)) {
+			continue
+		}
+		logger.Infof("%s configuration changed; restarting %s output", kind.name, kind.name)
+		// the old sink goes first, since the new one may need its port or
+		// client ID
+		b.outputs.Remove(kind.name)
+		if !kind.enabled(cfg) {
+			continue
+		}
+		s, err := kind.build(cfg)
+		if err != nil {
+			logger.Errorf("error starting %s output: %s", kind.name, err)
+			kind.restore(&cfg, old)
+			if !kind.enabled(old) {
+				continue
+			}
+			logger.Infof("Restarting %s output with its previous configuration", kind.name)
+			if s, err = kind.build(old); err != nil {
+				logger.Errorf("error restarting %s output: %s", kind.name, err)
+				continue
+			}
+		}
+		b.outputs.Add(s)
+	}
+
+	if old.Capture != cfg.Capture {
+		logger.Info("Capture settings changed")
+		b.capture.Configure(cfg.Capture)
+	}
+
+	wanted := map[string]tomlConfigPurpleAir{}
+	for _, sensor := range cfg.PurpleAir {
+		wanted[sensor.Url] = sensor
+	}
+	for url, p := range b.pollers {
+		if sensor, ok := wanted[url]; !ok || sensor != p.cfg {
+			logger.Infof("Stopping poller for %s", url)
+			b.stopPoller(url)
+		}
+	}
+	for _, sensor := range cfg.PurpleAir {
+		if _, ok := b.pollers[sensor.Url]; !ok {
+			b.startPoller(sensor)
+		}
+	}
+	if !reflect.DeepEqual(old.Cloud, cfg.Cloud) {
+		logger.Info("PurpleAir API settings changed")
+		b.stopCloud()
+	}
+	if b.cloud == nil && cloudConfigured(cfg.Cloud) {
+		b.startCloud(cfg.Cloud)
+	}
+	if !reflect.DeepEqual(old.Receiver, cfg.Receiver) {
+		logger.Info("Receiver settings changed")
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		b.stopReceiver(ctx)
+		cancel()
+	}
+	if b.push == nil && receiverConfigured(cfg.Receiver) {
+		if err := b.startReceiver(cfg.Receiver); err != nil {
+			logger.Error(err)
+			cfg.Receiver = old.Receiver
+			if receiverConfigured(old.Receiver) {
+				logger.Info("Restarting receiver with its previous settings")
+				if err := b.startReceiver(old.Receiver); err != nil {
+					logger.Error(err)
+				}
+			}
+		}
+	}
+
+	b.cfg = cfg
+	logger.Info("Configuration reloaded")
+}
+
+// ShutdownTimeout is how long Close should be given to flush the outputs.
+func (b *bridge) ShutdownTimeout() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return time.Duration(b.outputs.cfg.ShutdownTimeout) * time.Second
+}
+
+// Close stops every poller and then flushes and closes the outputs, giving
+// up when ctx is done. Pollers blocked on a full queue give up as they're
+// stopped, so ctx bounds the whole shutdown.
+func (b *bridge) Close(ctx context.Context) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for url := range b.pollers {
+		b.stopPoller(url)
+	}
+	b.stopCloud()
+	b.stopReceiver(ctx)
+	b.outputs.Close(ctx)
+	b.capture.Close()
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBridgeReload(t *testing.T) {
+	var fetches atomic.Int32
+	device := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		_, _ = w.Write([]byte(`{"SensorId": "84:f3:eb:00:00:01"}`))
+	}))
+	defer device.Close()
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer hook.Close()
+
+	cfg := tomlConfig{
+		PurpleAir: purpleAirSensors{
+			{Url: device.URL + "/kitchen", PollRate: 3600},
+			{Url: device.URL + "/garage", PollRate: 3600},
+		},
+		Influx:  tomlConfigInflux{Hostname: "localhost", Port: 8086, Database: "purpleair"},
+		Webhook: tomlConfigWebhook{Urls: []string{hook.URL}},
+	}
+	b, err := newBridge(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("newBridge() error = %v", err)
+	}
+	defer b.Close(context.Background())
+
+	sinksByName := func() map[string]sink {
+		b.outputs.mu.RLock()
+		defer b.outputs.mu.RUnlock()
+		m := map[string]sink{}
+		for _, w := range b.outputs.workers {
+			m[w.sink.Name()] = w.sink
+		}
+		return m
+	}
+	before := sinksByName()
+	kitchen := b.pollers[device.URL+"/kitchen"]
+	waitFor(t, func() bool { return fetches.Load() == 2 })
+
+	newCfg := cfg
+	newCfg.PurpleAir = purpleAirSensors{
+		{Url: device.URL + "/kitchen", PollRate: 3600},
+		{Url: device.URL + "/attic", PollRate: 3600},
+	}
+	newCfg.Webhook = tomlConfigWebhook{Urls: []string{hook.URL}, Attempts: 1}
+	b.Reload(newCfg)
+	after := sinksByName()
+
+	if after["InfluxDB"] != before["InfluxDB"] {
+		t.Errorf("InfluxDB sink was rebuilt although its configuration didn't change")
+	}
+	if after["webhook"] == nil || after["webhook"] == before["webhook"] {
+		t.Errorf("webhook sink was not rebuilt after its configuration changed")
+	}
+	if b.pollers[device.URL+"/kitchen"] != kitchen {
+		t.Errorf("unchanged sensor's poller was restarted")
+	}
+	if _, ok := b.pollers[device.URL+"/garage"]; ok {
+		t.Errorf("removed sensor is still being polled")
+	}
+	if _, ok := b.pollers[device.URL+"/attic"]; !ok {
+		t.Errorf("added sensor is not being polled")
+	}
+	waitFor(t, func() bool { return fetches.Load() == 3 })
+}
+
+func TestBridgeReloadOutputSettings(t *testing.T) {
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer hook.Close()
+	cfg := tomlConfig{
+		Influx:  tomlConfigInflux{Hostname: "localhost", Port: 8086, Database: "purpleair"},
+		Webhook: tomlConfigWebhook{Urls: []string{hook.URL}},
+	}
+	b, err := newBridge(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("newBridge() error = %v", err)
+	}
+	defer b.Close(context.Background())
+	influx := b.outputs.workers[0].sink
+
+	newCfg := cfg
+	newCfg.Output = tomlConfigOutput{QueueSize: 5}
+	newCfg.Webhook = tomlConfigWebhook{Urls: []string{hook.URL}, Attempts: 1}
+	b.Reload(newCfg)
+
+	if b.outputs.cfg.QueueSize != 5 {
+		t.Errorf("queue size = %d after reload, want 5", b.outputs.cfg.QueueSize)
+	}
+	var names []string
+	for _, w := range b.outputs.workers {
+		names = append(names, w.sink.Name())
+		if cap(w.queue) != 5 {
+			t.Errorf("%s queue holds %d readings, want 5", w.sink.Name(), cap(w.queue))
+		}
+	}
+	if len(names) != 2 {
+		t.Fatalf("outputs after reload = %v, want InfluxDB and webhook", names)
+	}
+	if b.outputs.workers[0].sink != influx {
+		t.Errorf("InfluxDB sink was rebuilt although only the output settings changed")
+	}
+}
+
+func TestBridgeReloadKeepsOutputThatFailsToStart(t *testing.T) {
+	cfg := tomlConfig{Modbus: tomlConfigModbus{Listen: "127.0.0.1:0", StaleAfter: 60}}
+	b, err := newBridge(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("newBridge() error = %v", err)
+	}
+	defer b.Close(context.Background())
+
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	newCfg := cfg
+	newCfg.Modbus = tomlConfigModbus{Listen: taken.Addr().String(), StaleAfter: 60}
+	b.Reload(newCfg)
+
+	if len(b.outputs.workers) != 1 || b.outputs.workers[0].sink.Name() != "Modbus" {
+		t.Fatalf("Modbus output wasn't restarted with its previous configuration")
+	}
+	if b.cfg.Modbus.Listen != cfg.Modbus.Listen {
+		t.Errorf("Modbus listen address after a failed reload = %q, want %q", b.cfg.Modbus.Listen, cfg.Modbus.Listen)
+	}
+	// the next reload tries the new configuration again
+	_ = taken.Close()
+	b.Reload(newCfg)
+	if b.cfg.Modbus.Listen != newCfg.Modbus.Listen {
+		t.Errorf("Modbus listen address = %q, want %q", b.cfg.Modbus.Listen, newCfg.Modbus.Listen)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type tomlConfigCapture struct {
+	Directory   string // Where raw sensor responses are recorded; capture is off if empty
+	MaxFileSize int    // Megabytes written to a capture file before it's rotated
+	MaxFiles    int    // Number of rotated capture files kept
+}
+
+const (
+	captureFileName   = "purpleair-capture.jsonl"
+	captureFilePrefix = "purpleair-capture-"
+	captureTimeLayout = "20060102T150405.000000000"
+)
+
+// captureRecord is one line of a capture file: a raw sensor response and how
+// it was fetched.
+type captureRecord struct {
+	FetchedAt  time.Time       `json:"fetched_at"`
+	Url        string          `json:"url"`
+	DurationMs int64           `json:"duration_ms"`
+	Status     int             `json:"status,omitempty"`
+	Headers    http.Header     `json:"headers,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Body       json.RawMessage `json:"body,omitempty"`      // the body, when it's valid JSON
+	BodyText   string          `json:"body_text,omitempty"` // the body, when it isn't
+}
+
+func newCaptureRecord(url string, fetchedAt time.Time, resp *http.Response, body []byte, err error) captureRecord {
+	rec := captureRecord{
+		FetchedAt:  fetchedAt.UTC(),
+		Url:        url,
+		DurationMs: time.Since(fetchedAt).Milliseconds(),
+	}
+	if resp != nil {
+		rec.Status = resp.StatusCode
+		rec.Headers = resp.Header
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	if json.Valid(body) {
+		rec.Body = body
+	} else {
+		rec.BodyText = string(body)
+	}
+	return rec
+}
+
+// captureWriter appends capture records to a file in the configured
+// directory, rotating it when it grows past MaxFileSize. Methods are safe to
+// call on a nil *captureWriter, which records nothing.
+type captureWriter struct {
+	mu   sync.Mutex
+	cfg  tomlConfigCapture
+	f    *os.File
+	size int64
+}
+
+func newCaptureWriter(cfg tomlConfigCapture) *captureWriter {
+	return &captureWriter{cfg: cfg}
+}
+
+// Configure applies new settings; the next record is written under them.
+func (c *captureWriter) Configure(cfg tomlConfigCapture) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeFile()
+	c.cfg = cfg
+}
+
+// Record writes rec, logging rather than returning any error since a capture
+// problem shouldn't interrupt polling.
+func (c *captureWriter) Record(rec captureRecord) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cfg.Directory == "" {
+		return
+	}
+	if err := c.write(rec); err != nil {
+		logger.Warnf("error recording response from %s: %s", rec.Url, err)
+	}
+}
+
+func (c *captureWriter) write(rec captureRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if c.f == nil {
+		if err := c.openFile(); err != nil {
+			return err
+		}
+	}
+	if c.size > 0 && c.size+int64(len(line)) > int64(c.cfg.MaxFileSize)*1024*1024 {
+		if err := c.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := c.f.Write(line)
+	c.size += int64(n)
+	return err
+}
+
+func (c *captureWriter) openFile() error {
+	if err := os.MkdirAll(c.cfg.Directory, 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(c.cfg.Directory, captureFileName), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	c.f, c.size = f, info.Size()
+	return nil
+}
+
+// rotate renames the current file after the time it was rotated, removes
+// the oldest rotated files beyond MaxFiles, and starts a new file.
+func (c *captureWriter) rotate() error {
+	c.closeFile()
+	current := filepath.Join(c.cfg.Directory, captureFileName)
+	rotated := filepath.Join(c.cfg.Directory, captureFilePrefix+time.Now().UTC().Format(captureTimeLayout)+".jsonl")
+	if err := os.Rename(current, rotated); err != nil {
+		return err
+	}
+
+	old, err := filepath.Glob(filepath.Join(c.cfg.Directory, captureFilePrefix+"*.jsonl"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(old)
+	for len(old) > c.cfg.MaxFiles {
+		if err := os.Remove(old[0]); err != nil {
+			return fmt.Errorf("removing old capture file: %w", err)
+		}
+		old = old[1:]
+	}
+	return c.openFile()
+}
+
+func (c *captureWriter) closeFile() {
+	if c.f != nil {
+		_ = c.f.Close()
+		c.f, c.size = nil, 0
+	}
+}
+
+func (c *captureWriter) Close() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeFile()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCaptureResponses(t *testing.T) {
+	var served atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if served.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("busy"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"SensorId": "84:f3:eb:00:00:01", "pm2_5_cf_1": 10.0}`))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	capture := newCaptureWriter(tomlConfigCapture{Directory: dir, MaxFileSize: 10, MaxFiles: 5})
+	// the first response isn't JSON, so it's retried
+	if _, err := fetchStatus(t.Context(), srv.URL, srv.Client(), capture); err != nil {
+		t.Fatalf("fetchStatus() error = %v", err)
+	}
+	capture.Close()
+
+	path := filepath.Join(dir, captureFileName)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	var records []captureRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec captureRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("capture line isn't a record: %v", err)
+		}
+		records = append(records, rec)
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("captured %d responses, want 2", len(records))
+	}
+	if records[0].Status != http.StatusServiceUnavailable || records[0].BodyText != "busy" || records[0].Body != nil {
+		t.Errorf("records[0] = %+v", records[0])
+	}
+	if records[1].Status != http.StatusOK || records[1].Url != srv.URL || records[1].Headers.Get("Content-Type") != "application/json" ||
+		!strings.Contains(string(records[1].Body), "84:f3:eb:00:00:01") || time.Since(records[1].FetchedAt) > time.Minute {
+		t.Errorf("records[1] = %+v", records[1])
+	}
+
+	// the device sent no DateTime, so replay falls back to when it was fetched
+	readings, skipped, err := readReplayFile(path)
+	if err != nil {
+		t.Fatalf("readReplayFile() error = %v", err)
+	}
+	if len(readings) != 1 || skipped != 0 {
+		t.Fatalf("replayed %d readings, skipped %d; want 1, 0", len(readings), skipped)
+	}
+	if !readings[0].At.Equal(records[1].FetchedAt) || readings[0].Status.A.EPAAQI == 0 {
+		t.Errorf("reading = %+v at %s", readings[0].Status.A, readings[0].At)
+	}
+}
+
+func TestCaptureWriterRotates(t *testing.T) {
+	dir := t.TempDir()
+	capture := newCaptureWriter(tomlConfigCapture{Directory: dir, MaxFileSize: 1, MaxFiles: 2})
+	defer capture.Close()
+
+	// each record is a bit over a third of a megabyte, so every third record
+	// starts a new file
+	body := strings.Repeat("x", 350*1024)
+	for i := 0; i < 10; i++ {
+		capture.Record(captureRecord{FetchedAt: time.Now(), Url: "http://sensor/json", BodyText: body})
+	}
+
+	rotated, err := filepath.Glob(filepath.Join(dir, captureFilePrefix+"*.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 2 {
+		t.Errorf("kept %d rotated files, want 2: %v", len(rotated), rotated)
+	}
+	info, err := os.Stat(filepath.Join(dir, captureFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 1024*1024 {
+		t.Errorf("current file is %d bytes, want at most 1MB", info.Size())
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type tomlConfigCloud struct {
+	ReadKey       string // PurpleAir API read key
+	SensorIndexes []int  // Sensors to read, by the index in their map.purpleair.com URL
+	GroupId       int    // Read every member of this API group instead of SensorIndexes
+	PollRate      int    // Seconds between requests
+	Timeout       int    // Timeout in seconds for HTTP requests
+	PointsPerDay  int    // API points to spend per day at most; 0 for no limit
+	Url           string // API base URL
+}
+
+const cloudDefaultUrl = "https://api.purpleair.com"
+
+// cloudConfigured reports whether the [cloud] section is in use.
+func cloudConfigured(cfg tomlConfigCloud) bool {
+	return !reflect.DeepEqual(cfg, tomlConfigCloud{})
+}
+
+// cloudFields maps each API field requested to the /json key it's read as,
+// so that cloud readings go through the same decoding as local ones.
+var cloudFields = []struct {
+	api   string
+	local string
+	scale float64 // multiplier to the local unit, if not 1
+}{
+	{api: "name", local: "Geo"},
+	{api: "last_seen", local: "DateTime"},
+	{api: "firmware_version", local: "version"},
+	{api: "hardware", local: "hardwarediscovered"},
+	{api: "location_type", local: "place"},
+	{api: "latitude", local: "lat"},
+	{api: "longitude", local: "long"},
+	{api: "rssi", local: "rssi"},
+	{api: "uptime", local: "uptime", scale: 60}, // minutes
+	{api: "humidity", local: "current_humidity"},
+	{api: "temperature", local: "current_temp_f"},
+	{api: "pressure", local: "pressure"},
+	{api: "pm1.0_atm_a", local: "pm1_0_atm"},
+	{api: "pm2.5_atm_a", local: "pm2_5_atm"},
+	{api: "pm10.0_atm_a", local: "pm10_0_atm"},
+	{api: "pm1.0_cf_1_a", local: "pm1_0_cf_1"},
+	{api: "pm2.5_cf_1_a", local: "pm2_5_cf_1"},
+	{api: "pm10.0_cf_1_a", local: "pm10_0_cf_1"},
+	{api: "0.3_um_count_a", local: "p_0_3_um"},
+	{api: "0.5_um_count_a", local: "p_0_5_um"},
+	{api: "1.0_um_count_a", local: "p_1_0_um"},
+	{api: "2.5_um_count_a", local: "p_2_5_um"},
+	{api: "5.0_um_count_a", local: "p_5_0_um"},
+	{api: "10.0_um_count_a", local: "p_10_0_um"},
+	{api: "pm1.0_atm_b", local: "pm1_0_atm_b"},
+	{api: "pm2.5_atm_b", local: "pm2_5_atm_b"},
+	{api: "pm10.0_atm_b", local: "pm10_0_atm_b"},
+	{api: "pm1.0_cf_1_b", local: "pm1_0_cf_1_b"},
+	{api: "pm2.5_cf_1_b", local: "pm2_5_cf_1_b"},
+	{api: "pm10.0_cf_1_b", local: "pm10_0_cf_1_b"},
+	{api: "0.3_um_count_b", local: "p_0_3_um_b"},
+	{api: "0.5_um_count_b", local: "p_0_5_um_b"},
+	{api: "1.0_um_count_b", local: "p_1_0_um_b"},
+	{api: "2.5_um_count_b", local: "p_2_5_um_b"},
+	{api: "5.0_um_count_b", local: "p_5_0_um_b"},
+	{api: "10.0_um_count_b", local: "p_10_0_um_b"},
+}
+
+// cloudMaxBackoff caps how long polling pauses after the API refuses a
+// request for running over its limits.
+const cloudMaxBackoff = time.Hour
+
+// cloudLimitError is returned when the API refuses a request because the
+// key is out of points or has made too many requests.
+type cloudLimitError struct {
+	status     int
+	message    string
+	retryAfter time.Duration // from the Retry-After header, if any
+}
+
+func (e *cloudLimitError) Error() string {
+	return fmt.Sprintf("PurpleAir API limit reached (%d): %s", e.status, e.message)
+}
+
+// cloudSource reads sensors from the PurpleAir API, pacing its requests to
+// stay within the configured point budget.
+type cloudSource struct {
+	cfg    tomlConfigCloud
+	client *http.Client
+
+	sensors int           // sensors in the last response, for estimating a request's cost
+	backoff time.Duration // current pause after a limit error
+}
+
+func newCloudSource(cfg tomlConfigCloud, client *http.Client) *cloudSource {
+	if cfg.Url == "" {
+		cfg.Url = cloudDefaultUrl
+	}
+	return &cloudSource{cfg: cfg, client: client, sensors: max(len(cfg.SensorIndexes), 1)}
+}
+
+// requestUrl returns the URL reading the configured sensors: a single
+// sensor, several at once, or a group's members.
+func (c *cloudSource) requestUrl() string {
+	fields := make([]string, len(cloudFields))
+	for i, f := range cloudFields {
+		fields[i] = f.api
+	}
+	q := url.Values{"fields": {strings.Join(fields, ",")}}
+	base := strings.TrimSuffix(c.cfg.Url, "/")
+	switch {
+	case c.cfg.GroupId != 0:
+		return fmt.Sprintf("%s/v1/groups/%d/members?%s", base, c.cfg.GroupId, q.Encode())
+	case len(c.cfg.SensorIndexes) == 1:
+		return fmt.Sprintf("%s/v1/sensors/%d?%s", base, c.cfg.SensorIndexes[0], q.Encode())
+	default:
+		indexes := make([]string, len(c.cfg.SensorIndexes))
+		for i, index := range c.cfg.SensorIndexes {
+			indexes[i] = strconv.Itoa(index)
+		}
+		q.Set("show_only", strings.Join(indexes, ","))
+		return fmt.Sprintf("%s/v1/sensors?%s", base, q.Encode())
+	}
+}
+
+// Fetch reads every configured sensor, returning normalized readings.
+func (c *cloudSource) Fetch(ctx context.Context) ([]*purpleAirStatus, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.requestUrl(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-API-Key", c.cfg.ReadKey)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error       string `json:"error"`
+			Description string `json:"description"`
+		}
+		message := resp.Status
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Description != "" {
+			message = apiErr.Description
+		}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusPaymentRequired {
+			limitErr := &cloudLimitError{status: resp.StatusCode, message: message}
+			if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+				limitErr.retryAfter = time.Duration(seconds) * time.Second
+			}
+			return nil, limitErr
+		}
+		return nil, fmt.Errorf("PurpleAir API returned %s: %s", resp.Status, message)
+	}
+
+	records, err := decodeCloudResponse(body)
+	if err != nil {
+		return nil, fmt.Errorf("decoding PurpleAir API response: %w", err)
+	}
+	statuses := make([]*purpleAirStatus, 0, len(records))
+	for _, record := range records {
+		status, err := cloudStatus(record)
+		if err != nil {
+			return nil, fmt.Errorf("decoding PurpleAir API response: %w", err)
+		}
+		statuses = append(statuses, status)
+	}
+	if len(statuses) > 0 {
+		c.sensors = len(statuses)
+	}
+	return statuses, nil
+}
+
+// decodeCloudResponse returns each sensor in a response as a map of API
+// field to value. Single sensor responses hold a "sensor" object, while
+// multi-sensor responses list "fields" once and a row of values per sensor.
+func decodeCloudResponse(body []byte) ([]map[string]interface{}, error) {
+	var resp struct {
+		Sensor map[string]interface{} `json:"sensor"`
+		Fields []string               `json:"fields"`
+		Data   [][]interface{}        `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Sensor != nil {
+		return []map[string]interface{}{resp.Sensor}, nil
+	}
+	records := make([]map[string]interface{}, 0, len(resp.Data))
+	for _, row := range resp.Data {
+		if len(row) != len(resp.Fields) {
+			return nil, fmt.Errorf("row has %d values for %d fields", len(row), len(resp.Fields))
+		}
+		record := map[string]interface{}{}
+		for i, field := range resp.Fields {
+			record[field] = row[i]
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// cloudStatus translates an API sensor record into a reading, as though it
+// had been read from the sensor's /json. The API doesn't reveal sensors' MAC
+// addresses, so SensorId is the sensor index.
+func cloudStatus(record map[string]interface{}) (*purpleAirStatus, error) {
+	fields := statusFieldsByJSONKey()
+	statusType := reflect.TypeOf(purpleAirStatus{})
+
+	local := map[string]interface{}{}
+	if index, ok := record["sensor_index"].(float64); ok {
+		local["SensorId"] = strconv.Itoa(int(index))
+	}
+	for _, f := range cloudFields {
+		value, ok := record[f.api]
+		if !ok || value == nil {
+			continue
+		}
+		switch f.api {
+		case "last_seen":
+			if seconds, ok := value.(float64); ok {
+				value = time.Unix(int64(seconds), 0).UTC().Format(deviceTimeLayout)
+			}
+		case "location_type":
+			if n, ok := value.(float64); ok {
+				value = map[float64]string{0: "outside", 1: "inside"}[n]
+			}
+		}
+		if n, ok := value.(float64); ok {
+			if f.scale != 0 {
+				n *= f.scale
+			}
+			// whole-number fields in /json may be fractional in the API
+			if index, ok := fields[f.local]; ok && statusType.Field(index).Type.Kind() == reflect.Int {
+				n = math.Round(n)
+			}
+			value = n
+		}
+		local[f.local] = value
+	}
+
+	data, err := json.Marshal(local)
+	if err != nil {
+		return nil, err
+	}
+	status := new(purpleAirStatus)
+	if err := json.Unmarshal(data, status); err != nil {
+		return nil, err
+	}
+	normalizePaStatus(status)
+	calculateEPAAQI(status)
+	return status, nil
+}
+
+// interval returns how long to wait before the next request: the poll rate,
+// stretched if needed so the estimated cost of a day's requests stays within
+// PointsPerDay. The cost of a request is estimated at one point per field
+// per sensor.
+func (c *cloudSource) interval() time.Duration {
+	interval := time.Duration(c.cfg.PollRate) * time.Second
+	if c.cfg.PointsPerDay > 0 {
+		cost := len(cloudFields) * c.sensors
+		budgeted := time.Duration(float64(24*time.Hour) * float64(cost) / float64(c.cfg.PointsPerDay))
+		interval = max(interval, budgeted.Round(time.Second))
+	}
+	return interval
+}
+
+// delayAfter returns how long to wait after a request that returned err,
+// backing off further with each consecutive limit error.
+func (c *cloudSource) delayAfter(err error) time.Duration {
+	var limitErr *cloudLimitError
+	if !errors.As(err, &limitErr) {
+		c.backoff = 0
+		return c.interval()
+	}
+	if limitErr.retryAfter > 0 {
+		c.backoff = limitErr.retryAfter
+	} else {
+		c.backoff = min(max(2*c.backoff, c.interval()), cloudMaxBackoff)
+	}
+	return max(c.backoff, c.interval())
+}
+
+// pollCloud reads the configured sensors from the PurpleAir API and hands
+// their readings to the outputs until ctx is cancelled.
+func pollCloud(ctx context.Context, source *cloudSource, outputs *dispatcher) {
+	var logged time.Duration // the budgeted interval last warned about
+	for {
+		statuses, err := source.Fetch(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Errorf("error fetching from PurpleAir API: %s", err)
+		}
+		for _, status := range statuses {
+			logger.Infof("PurpleAir API sensor %s (%s): US EPA AQI %d (%s)", status.SensorId, status.Geo, status.EPAAQI, status.EPAAQICategory)
+			outputs.Publish(ctx, status)
+		}
+
+		delay := source.delayAfter(err)
+		if err == nil && delay != logged {
+			if delay > time.Duration(source.cfg.PollRate)*time.Second {
+				logger.Warnf("Polling the PurpleAir API every %s to stay within %d points per day", delay, source.cfg.PointsPerDay)
+			}
+			logged = delay
+		}
+		logger.Debugf("Sleeping for %s", delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
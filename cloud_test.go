@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const cloudSensorFixture = `{"api_version": "V1.0.11-0.0.49", "time_stamp": 1704110460, "data_time_stamp": 1704110400,
+"sensor": {"sensor_index": 12345, "name": "Back yard", "last_seen": 1704110400, "firmware_version": "7.02",
+"hardware": "2.0+BME280+PMSX003-B+PMSX003-A", "location_type": 0, "rssi": -60, "uptime": 120,
+"humidity": 40, "temperature": 71.4, "pressure": 1006.5,
+"pm2.5_atm_a": 12.0, "pm2.5_cf_1_a": 12.0, "pm10.0_atm_a": 20.0, "pm10.0_cf_1_a": 20.0,
+"pm2.5_atm_b": 14.0, "pm2.5_cf_1_b": 14.0, "pm10.0_atm_b": 22.0, "pm10.0_cf_1_b": 22.0}}`
+
+const cloudSensorsFixture = `{"api_version": "V1.0.11-0.0.49", "time_stamp": 1704110460, "data_time_stamp": 1704110400,
+"fields": ["sensor_index", "name", "last_seen", "location_type", "pm2.5_cf_1_a", "pm2.5_cf_1_b", "pm2.5_atm_a"],
+"data": [
+	[12345, "Back yard", 1704110400, 0, 12.0, 14.0, 12.0],
+	[67890, "Office", 1704110380, 1, 3.0, 3.0, 3.0]
+]}`
+
+func TestCloudSourceFetch(t *testing.T) {
+	tests := []struct {
+		name          string
+		cfg           tomlConfigCloud
+		response      string
+		expectedPath  string
+		expectedQuery string // show_only, when expected
+		expectedIds   []string
+		check         func(t *testing.T, statuses []*purpleAirStatus)
+	}{
+		{"Single sensor", tomlConfigCloud{SensorIndexes: []int{12345}}, cloudSensorFixture,
+			"/v1/sensors/12345", "", []string{"12345"}, func(t *testing.T, statuses []*purpleAirStatus) {
+				s := statuses[0]
+				if s.Geo != "Back yard" || s.Place != "outside" || s.Version != "7.02" {
+					t.Errorf("device fields = %q, %q, %q", s.Geo, s.Place, s.Version)
+				}
+				if s.DateTime != "2024/01/01T12:00:00Z" {
+					t.Errorf("DateTime = %q", s.DateTime)
+				}
+				if s.Uptime != 7200 || s.Temperature != 71 || s.Pressure != 1006.5 {
+					t.Errorf("uptime, temperature, pressure = %d, %d, %v", s.Uptime, s.Temperature, s.Pressure)
+				}
+				if s.A.PM25Atm != 12 || s.B.PM25Atm != 14 || s.A.PM100Atm != 20 {
+					t.Errorf("channels weren't normalized: A %+v, B %+v", s.A, s.B)
+				}
+				if s.EPAAQI == 0 || s.A.EPAAQI == 0 || s.B.EPAAQI == 0 {
+					t.Errorf("AQI wasn't calculated: %d, %d, %d", s.EPAAQI, s.A.EPAAQI, s.B.EPAAQI)
+				}
+				if len(s.Extras) != 0 {
+					t.Errorf("Extras = %v, want none", s.Extras)
+				}
+			}},
+		{"Several sensors", tomlConfigCloud{SensorIndexes: []int{12345, 67890}}, cloudSensorsFixture,
+			"/v1/sensors", "12345,67890", []string{"12345", "67890"}, func(t *testing.T, statuses []*purpleAirStatus) {
+				if statuses[1].Geo != "Office" || statuses[1].Place != "inside" || statuses[1].B.PM25Cf1 != 3 {
+					t.Errorf("second sensor = %+v", statuses[1])
+				}
+			}},
+		{"Group", tomlConfigCloud{GroupId: 42}, cloudSensorsFixture,
+			"/v1/groups/42/members", "", []string{"12345", "67890"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *http.Request
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r
+				_, _ = w.Write([]byte(tt.response))
+			}))
+			defer srv.Close()
+
+			tt.cfg.ReadKey, tt.cfg.Url = "READ-KEY", srv.URL
+			statuses, err := newCloudSource(tt.cfg, srv.Client()).Fetch(context.Background())
+			if err != nil {
+				t.Fatalf("Fetch() error = %v", err)
+			}
+
+			if got.URL.Path != tt.expectedPath {
+				t.Errorf("path = %s, want %s", got.URL.Path, tt.expectedPath)
+			}
+			if got.URL.Query().Get("show_only") != tt.expectedQuery {
+				t.Errorf("show_only = %q, want %q", got.URL.Query().Get("show_only"), tt.expectedQuery)
+			}
+			if !strings.Contains(got.URL.Query().Get("fields"), "pm2.5_cf_1_a") {
+				t.Errorf("fields = %q", got.URL.Query().Get("fields"))
+			}
+			if got.Header.Get("X-API-Key") != "READ-KEY" {
+				t.Errorf("X-API-Key = %q", got.Header.Get("X-API-Key"))
+			}
+
+			var ids []string
+			for _, s := range statuses {
+				ids = append(ids, s.SensorId)
+			}
+			if strings.Join(ids, ",") != strings.Join(tt.expectedIds, ",") {
+				t.Fatalf("sensors = %v, want %v", ids, tt.expectedIds)
+			}
+			if tt.check != nil {
+				tt.check(t, statuses)
+			}
+		})
+	}
+}
+
+func TestCloudSourceLimits(t *testing.T) {
+	responses := []func(w http.ResponseWriter){
+		func(w http.ResponseWriter) {
+			w.Header().Set("Retry-After", "1000")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"error": "RateLimitExceededError", "description": "Slow down."}`))
+		},
+		func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusPaymentRequired)
+			_, _ = w.Write([]byte(`{"error": "PaymentRequiredError", "description": "Out of points."}`))
+		},
+		func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusPaymentRequired)
+		},
+		func(w http.ResponseWriter) {
+			_, _ = w.Write([]byte(cloudSensorsFixture))
+		},
+	}
+	var n int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		responses[n](w)
+		n++
+	}))
+	defer srv.Close()
+
+	// 36 fields for 2 sensors is 72 points a request, so 7,200 points a day
+	// allows a request every 864 seconds
+	c := newCloudSource(tomlConfigCloud{ReadKey: "KEY", GroupId: 42, PollRate: 300, PointsPerDay: 7200, Url: srv.URL}, srv.Client())
+	c.sensors = 2
+	if c.interval() != 864*time.Second {
+		t.Fatalf("interval() = %s, want 864s", c.interval())
+	}
+
+	// Retry-After is honored, then the pause doubles up to cloudMaxBackoff,
+	// and a success goes back to the budgeted interval
+	expectedDelays := []time.Duration{1000 * time.Second, 2000 * time.Second, time.Hour, 864 * time.Second}
+	for i, expected := range expectedDelays {
+		_, err := c.Fetch(context.Background())
+		var limitErr *cloudLimitError
+		if i < 3 && !errors.As(err, &limitErr) {
+			t.Fatalf("request %d: Fetch() error = %v, want a limit error", i, err)
+		}
+		if i == 1 && !strings.Contains(err.Error(), "Out of points.") {
+			t.Errorf("request %d: error = %q, want the API's description", i, err)
+		}
+		if i == 3 && err != nil {
+			t.Fatalf("request %d: Fetch() error = %v", i, err)
+		}
+		if delay := c.delayAfter(err); delay != expected {
+			t.Errorf("request %d: delay = %s, want %s", i, delay, expected)
+		}
+	}
+}
@@ -0,0 +1,945 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/naoina/toml"
+	"github.com/naoina/toml/ast"
+)
+
+// configProblem is a single error or warning found in a configuration file.
+type configProblem struct {
+	Line    int    // line in the file the problem was found on, or 0 if unknown
+	Key     string // dotted key the problem concerns, e.g. "purpleair.poll_rate"
+	Message string
+}
+
+func (p configProblem) String() string {
+	switch {
+	case p.Line > 0 && p.Key != "":
+		return fmt.Sprintf("line %d: %s: %s", p.Line, p.Key, p.Message)
+	case p.Line > 0:
+		return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+	case p.Key != "":
+		return fmt.Sprintf("%s: %s", p.Key, p.Message)
+	default:
+		return p.Message
+	}
+}
+
+// configError reports every error found in a configuration file at once.
+type configError []configProblem
+
+func (e configError) Error() string {
+	msgs := make([]string, len(e))
+	for i, p := range e {
+		msgs[i] = p.String()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// configReport collects the problems found while loading a configuration,
+// along with the line each key was set on so problems can point at it.
+type configReport struct {
+	lines    map[string]int
+	sources  map[string]string // environment variable or flag each overridden key came from
+	Errors   []configProblem
+	Warnings []configProblem
+}
+
+func newConfigReport() *configReport {
+	return &configReport{lines: map[string]int{}, sources: map[string]string{}}
+}
+
+// has reports whether key was set in the configuration file, environment or
+// on the command line.
+func (r *configReport) has(key string) bool {
+	_, ok := r.lines[key]
+	return ok
+}
+
+// lineOf returns the line key was set on. Keys that weren't set (such as a
+// missing required setting) are reported at the line of their section.
+func (r *configReport) lineOf(key string) int {
+	for key != "" {
+		if line, ok := r.lines[key]; ok {
+			return line
+		}
+		i := strings.LastIndexAny(key, ".[")
+		if i < 0 {
+			break
+		}
+		key = key[:i]
+	}
+	return 0
+}
+
+func (r *configReport) errorf(key string, format string, args ...interface{}) {
+	r.Errors = append(r.Errors, r.problem(key, format, args...))
+}
+
+func (r *configReport) warnf(key string, format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, r.problem(key, format, args...))
+}
+
+func (r *configReport) problem(key string, format string, args ...interface{}) configProblem {
+	if name, ok := r.sources[key]; ok {
+		return configProblem{Key: key, Message: fmt.Sprintf(format, args...) + fmt.Sprintf(" (set by %s)", name)}
+	}
+	return configProblem{Line: r.lineOf(key), Key: key, Message: fmt.Sprintf(format, args...)}
+}
+
+func (r *configReport) sort() {
+	for _, problems := range [][]configProblem{r.Errors, r.Warnings} {
+		sort.SliceStable(problems, func(i, j int) bool { return problems[i].Line < problems[j].Line })
+	}
+}
+
+// Err returns the errors in the report as a configError, or nil if there are none.
+func (r *configReport) Err() error {
+	if len(r.Errors) == 0 {
+		return nil
+	}
+	return configError(r.Errors)
+}
+
+// tomlDecodeConfig matches keys to struct fields like toml.DefaultConfig, but
+// leaves unknown keys to parseConfig so that they're reported with the rest
+// of the problems instead of aborting decoding.
+var tomlDecodeConfig = toml.Config{
+	NormFieldName: func(typ reflect.Type, keyOrField string) string {
+		return normConfigKey(keyOrField)
+	},
+	FieldToKey: toml.DefaultConfig.FieldToKey,
+	MissingField: func(typ reflect.Type, key string) error {
+		return nil
+	},
+}
+
+func normConfigKey(s string) string {
+	return strings.ReplaceAll(strings.ToLower(s), "_", "")
+}
+
+// loadConfig reads and validates the configuration file at path, with any
+// PA2MQTT_* environment overrides and command-line flags applied. path may be
+// empty to configure the bridge from the environment and flags alone.
+// Warnings are logged; errors are all returned together.
+func loadConfig(path string, flags configFlags) (tomlConfig, error) {
+	data, err := readConfigFile(path)
+	if err != nil {
+		return tomlConfig{}, err
+	}
+	cfg, report := parseConfig(data, os.Environ(), flags)
+	for _, w := range report.Warnings {
+		logger.Warnf("%s: %s", configName(path), w)
+	}
+	if err := report.Err(); err != nil {
+		return cfg, fmt.Errorf("invalid configuration in %s: %w", configName(path), err)
+	}
+	return cfg, nil
+}
+
+func readConfigFile(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return os.ReadFile(path)
+}
+
+// configName describes where the configuration came from in messages.
+func configName(path string) string {
+	if path == "" {
+		return "configuration"
+	}
+	return path
+}
+
+// runCheckConfig prints every problem found in the configuration and returns
+// the process exit code: 0 if it's usable, 1 if not.
+func runCheckConfig(path string, flags configFlags) int {
+	data, err := readConfigFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	_, report := parseConfig(data, os.Environ(), flags)
+	name := configName(path)
+	for _, p := range report.Errors {
+		fmt.Printf("%s: error: %s\n", name, p)
+	}
+	for _, p := range report.Warnings {
+		fmt.Printf("%s: warning: %s\n", name, p)
+	}
+	if len(report.Errors) > 0 {
+		fmt.Printf("%s: %d error(s), %d warning(s)\n", name, len(report.Errors), len(report.Warnings))
+		return 1
+	}
+	fmt.Printf("%s: OK (%d warning(s))\n", name, len(report.Warnings))
+	return 0
+}
+
+// parseConfig decodes a TOML configuration, applies the overrides in environ
+// and then flags, applies defaults and validates the result, collecting every
+// problem it finds rather than stopping at the first one.
+func parseConfig(data []byte, environ []string, flags configFlags) (tomlConfig, *configReport) {
+	var cfg tomlConfig
+	report := newConfigReport()
+
+	table, err := toml.Parse(data)
+	if err != nil {
+		var lerr *toml.LineError
+		if errors.As(err, &lerr) {
+			report.Errors = append(report.Errors, configProblem{Line: lerr.Line, Message: lerr.Err.Error()})
+		} else {
+			report.Errors = append(report.Errors, configProblem{Message: err.Error()})
+		}
+		return cfg, report
+	}
+
+	walkConfigTable(report, table, reflect.TypeOf(cfg), "")
+
+	// decode one top-level section at a time, so a bad value in one section
+	// doesn't hide problems in the others
+	keys := make([]string, 0, len(table.Fields))
+	for key := range table.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	failed := map[string]bool{}
+	for _, key := range keys {
+		section := &ast.Table{Fields: map[string]interface{}{key: table.Fields[key]}}
+		if err := tomlDecodeConfig.UnmarshalTable(section, &cfg); err != nil {
+			failed[key] = true
+			var lerr *toml.LineError
+			if errors.As(err, &lerr) {
+				report.Errors = append(report.Errors, configProblem{Line: lerr.Line, Key: report.keyOnLine(lerr.Line, key), Message: lerr.Err.Error()})
+			} else {
+				report.Errors = append(report.Errors, configProblem{Key: key, Message: err.Error()})
+			}
+		}
+	}
+
+	// sections that couldn't be decoded are already reported; checking their
+	// half-decoded values would only add noise
+	decodeErrors := len(report.Errors)
+	applyEnvOverrides(&cfg, environ, report)
+	applyFlagOverrides(&cfg, flags, report)
+	applyConfigDefaults(&cfg, report)
+	validateConfig(cfg, report)
+	report.Errors = append(report.Errors[:decodeErrors], withoutSections(report.Errors[decodeErrors:], failed)...)
+	report.Warnings = withoutSections(report.Warnings, failed)
+
+	report.sort()
+	return cfg, report
+}
+
+func withoutSections(problems []configProblem, sections map[string]bool) []configProblem {
+	var kept []configProblem
+	for _, p := range problems {
+		section, _, _ := strings.Cut(p.Key, ".")
+		section, _, _ = strings.Cut(section, "[")
+		if !sections[section] {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// keyOnLine returns the most specific key set on the given line, or fallback
+// if there isn't one.
+func (r *configReport) keyOnLine(line int, fallback string) string {
+	key := fallback
+	for k, l := range r.lines {
+		if l == line && (len(k) > len(key) || (len(k) == len(key) && k < key)) {
+			key = k
+		}
+	}
+	return key
+}
+
+// walkConfigTable records the line every key was set on and reports keys
+// that don't correspond to any configuration setting.
+func walkConfigTable(report *configReport, table *ast.Table, typ reflect.Type, prefix string) {
+	for key, node := range table.Fields {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+
+		var line int
+		switch n := node.(type) {
+		case *ast.KeyValue:
+			line = n.Line
+		case *ast.Table:
+			line = n.Line
+		case []*ast.Table:
+			line = n[0].Line
+		}
+		report.lines[path] = line
+
+		field, ok := findConfigField(typ, key)
+		if !ok {
+			report.Errors = append(report.Errors, configProblem{Line: line, Key: path, Message: "unknown setting"})
+			continue
+		}
+
+		ft := field.Type
+		if ft.Kind() == reflect.Slice && ft.Elem().Kind() == reflect.Struct {
+			switch n := node.(type) {
+			case *ast.Table:
+				walkConfigTable(report, n, ft.Elem(), path)
+			case []*ast.Table:
+				for i, t := range n {
+					indexed := fmt.Sprintf("%s[%d]", path, i)
+					report.lines[indexed] = t.Line
+					walkConfigTable(report, t, ft.Elem(), indexed)
+					if len(n) == 1 {
+						walkConfigTable(report, t, ft.Elem(), path)
+					}
+				}
+			}
+		} else if ft.Kind() == reflect.Struct {
+			if n, ok := node.(*ast.Table); ok {
+				walkConfigTable(report, n, ft, path)
+			}
+		} else if ft.Kind() == reflect.Map {
+			// any key is allowed, but problems with a value can still point at it
+			if n, ok := node.(*ast.Table); ok {
+				for k, v := range n.Fields {
+					if kv, ok := v.(*ast.KeyValue); ok {
+						report.lines[path+"."+k] = kv.Line
+					}
+				}
+			}
+		}
+	}
+}
+
+func findConfigField(typ reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < typ.NumField(); i++ {
+		if normConfigKey(typ.Field(i).Name) == normConfigKey(key) {
+			return typ.Field(i), true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// sensorKey names a setting of the i'th [[purpleair]] sensor the way it was
+// written in the file.
+func sensorKey(cfg tomlConfig, i int, key string) string {
+	if len(cfg.PurpleAir) == 1 {
+		return "purpleair." + key
+	}
+	return fmt.Sprintf("purpleair[%d].%s", i, key)
+}
+
+// mqttKey names a setting of the i'th [[mqtt]] target the way it was written
+// in the file, or the target itself if key is empty.
+func mqttKey(cfg tomlConfig, i int, key string) string {
+	name := fmt.Sprintf("mqtt[%d]", i)
+	if len(cfg.Mqtt) == 1 {
+		name = "mqtt"
+	}
+	if key == "" {
+		return name
+	}
+	return name + "." + key
+}
+
+// applyConfigDefaults fills in the documented defaults for settings that
+// weren't given in the file or environment.
+func applyConfigDefaults(cfg *tomlConfig, report *configReport) {
+	for i := range cfg.PurpleAir {
+		if !report.has(sensorKey(*cfg, i, "poll_rate")) {
+			cfg.PurpleAir[i].PollRate = 120
+		}
+		if !report.has(sensorKey(*cfg, i, "timeout")) {
+			cfg.PurpleAir[i].Timeout = 15
+		}
+	}
+
+	if cloudConfigured(cfg.Cloud) {
+		if !report.has("cloud.poll_rate") {
+			cfg.Cloud.PollRate = 300
+		}
+		if !report.has("cloud.timeout") {
+			cfg.Cloud.Timeout = 15
+		}
+		if cfg.Cloud.Url == "" {
+			cfg.Cloud.Url = cloudDefaultUrl
+		}
+	}
+
+	if receiverConfigured(cfg.Receiver) && cfg.Receiver.Path == "" {
+		cfg.Receiver.Path = "/purpleair"
+	}
+
+	for i := range cfg.Mqtt {
+		if !report.has(mqttKey(*cfg, i, "broker_port")) {
+			cfg.Mqtt[i].BrokerPort = 1883
+		}
+		if cfg.Mqtt[i].TopicPrefix == "" {
+			cfg.Mqtt[i].TopicPrefix = "purpleair"
+		}
+		if cfg.Mqtt[i].Mode == "" {
+			cfg.Mqtt[i].Mode = mqttModeAlways
+		}
+		if cfg.Mqtt[i].Output == "" {
+			cfg.Mqtt[i].Output = mqttOutputTopics
+		}
+		if cfg.Mqtt[i].HomieVersion == 0 {
+			cfg.Mqtt[i].HomieVersion = 4
+		}
+		if cfg.Mqtt[i].SparkplugGroupId == "" {
+			cfg.Mqtt[i].SparkplugGroupId = "PurpleAir"
+		}
+		if cfg.Mqtt[i].SparkplugEdgeNode == "" {
+			cfg.Mqtt[i].SparkplugEdgeNode = sparkplugEdgeNode(cfg.Mqtt[i].ClientId)
+		}
+		if !report.has(mqttKey(*cfg, i, "sparkplug_offline_after")) {
+			cfg.Mqtt[i].SparkplugOfflineAfter = 900
+		}
+		if cfg.Mqtt[i].TopicTemplate == "" {
+			cfg.Mqtt[i].TopicTemplate = defaultTopicTemplate
+		}
+		if cfg.Mqtt[i].TopicLayout == "" {
+			cfg.Mqtt[i].TopicLayout = topicLayoutLegacy
+		}
+		if cfg.Mqtt[i].FieldStyle == "" {
+			cfg.Mqtt[i].FieldStyle = defaultFieldStyle(cfg.Mqtt[i].TopicLayout)
+		}
+		if !report.has(mqttKey(*cfg, i, "keep_alive")) {
+			cfg.Mqtt[i].KeepAlive = 30
+		}
+		if !report.has(mqttKey(*cfg, i, "connect_retry_interval")) {
+			cfg.Mqtt[i].ConnectRetryInterval = 10
+		}
+		if !report.has(mqttKey(*cfg, i, "max_reconnect_interval")) {
+			cfg.Mqtt[i].MaxReconnectInterval = 60
+		}
+	}
+
+	if cfg.Influx != (tomlConfigInflux{}) {
+		if !report.has("influx.port") {
+			cfg.Influx.Port = 8086
+		}
+		if cfg.Influx.Database == "" {
+			cfg.Influx.Database = "purpleair"
+		}
+		if cfg.Influx.MeasurementName == "" {
+			cfg.Influx.MeasurementName = "purpleair_monitor"
+		}
+		if cfg.Influx.StatusMeasurementName == "" {
+			cfg.Influx.StatusMeasurementName = "purpleair_status"
+		}
+	}
+
+	if modbusConfigured(cfg.Modbus) && !report.has("modbus.stale_after") {
+		cfg.Modbus.StaleAfter = 900
+	}
+	if snmpConfigured(cfg.Snmp) && !report.has("snmp.stale_after") {
+		cfg.Snmp.StaleAfter = 900
+	}
+	if grpcConfigured(cfg.Grpc) && !report.has("grpc.stream_buffer") {
+		cfg.Grpc.StreamBuffer = 16
+	}
+
+	if cfg.Capture.Directory != "" {
+		if !report.has("capture.max_file_size") {
+			cfg.Capture.MaxFileSize = 10
+		}
+		if !report.has("capture.max_files") {
+			cfg.Capture.MaxFiles = 5
+		}
+	}
+}
+
+// validateConfig checks for configuration problems that would otherwise only
+// show up once the bridge is running.
+func validateConfig(cfg tomlConfig, report *configReport) {
+	if len(cfg.PurpleAir) == 0 && !cloudConfigured(cfg.Cloud) && !receiverConfigured(cfg.Receiver) {
+		report.errorf("purpleair", "no sensor configured; add a [purpleair] section with the sensor's url, a [cloud] or [receiver] section, or pass -url")
+	}
+	seen := map[string]bool{}
+	for i, sensor := range cfg.PurpleAir {
+		urlKey := sensorKey(cfg, i, "url")
+		if sensor.Url == "" {
+			report.errorf(urlKey, "missing; set it to the sensor's JSON endpoint, e.g. http://192.168.1.24/json")
+		} else if u, err := url.Parse(sensor.Url); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			report.errorf(urlKey, "%q is not an http:// or https:// URL", sensor.Url)
+		} else if seen[sensor.Url] {
+			report.errorf(urlKey, "%s is configured more than once", sensor.Url)
+		}
+		seen[sensor.Url] = true
+		if sensor.PollRate < 1 {
+			report.errorf(sensorKey(cfg, i, "poll_rate"), "must be at least 1 second (default: 120)")
+		}
+		if sensor.Timeout < 1 {
+			report.errorf(sensorKey(cfg, i, "timeout"), "must be at least 1 second (default: 15)")
+		}
+	}
+
+	if cloudConfigured(cfg.Cloud) {
+		validateCloud(cfg.Cloud, report)
+	}
+	if receiverConfigured(cfg.Receiver) {
+		validateReceiver(cfg.Receiver, report)
+	}
+
+	clients := map[string]int{}
+	for i, target := range cfg.Mqtt {
+		validateMQTT(cfg, i, report)
+		// a broker disconnects a client when another connects with its ID
+		if target.ClientId != "" {
+			client := fmt.Sprintf("%s:%d/%s", target.BrokerHost, target.BrokerPort, target.ClientId)
+			if first, ok := clients[client]; ok {
+				report.errorf(mqttKey(cfg, i, "client_id"), "%q is also used by %s on the same broker", target.ClientId, mqttKey(cfg, first, ""))
+			} else {
+				clients[client] = i
+			}
+		}
+	}
+
+	if cfg.Hass != (tomlConfigHass{}) {
+		if len(cfg.Mqtt) == 0 {
+			report.errorf("hass", "Hass configuration found but no MQTT configuration found - please configure MQTT broker")
+		} else if cfg.Hass.Discovery && cfg.Mqtt[0].Output != mqttOutputTopics {
+			report.warnf("hass.discovery", "has no effect while the primary MQTT target's output is %q", cfg.Mqtt[0].Output)
+		}
+	}
+
+	if cfg.Influx != (tomlConfigInflux{}) {
+		if cfg.Influx.Hostname == "" {
+			report.errorf("influx.hostname", "missing; set it to your InfluxDB server's hostname")
+		}
+		if cfg.Influx.Port < 1 || cfg.Influx.Port > 65535 {
+			report.errorf("influx.port", "%d is not a valid port", cfg.Influx.Port)
+		}
+		if cfg.Influx.Timeout < 0 {
+			report.errorf("influx.timeout", "must not be negative")
+		}
+		warnPartialCredentials(report, "influx.username", cfg.Influx.Username, "influx.password", cfg.Influx.Password)
+		checkExtraFields(report, "influx.extra_fields", cfg.Influx.ExtraFields)
+	}
+
+	if report.has("webhook") && len(cfg.Webhook.Urls) == 0 {
+		report.warnf("webhook", "no urls configured, so the webhook output is disabled")
+	}
+	for _, u := range cfg.Webhook.Urls {
+		if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			report.errorf("webhook.urls", "%q is not an http:// or https:// URL", u)
+		}
+	}
+	if cfg.Webhook.Template != "" {
+		if _, err := newPayloadTemplate("webhook", cfg.Webhook.Template); err != nil {
+			report.errorf("webhook.template", "%s", err)
+		}
+	}
+	if cfg.Webhook.Timeout < 0 {
+		report.errorf("webhook.timeout", "must not be negative")
+	}
+	if cfg.Webhook.Attempts < 0 {
+		report.errorf("webhook.attempts", "must not be negative")
+	}
+
+	switch cfg.Output.Overflow {
+	case "", overflowDropOldest, overflowDropNewest, overflowBlock:
+	default:
+		report.errorf("output.overflow", "%q is not one of %s, %s or %s", cfg.Output.Overflow, overflowDropOldest, overflowDropNewest, overflowBlock)
+	}
+	for _, setting := range []struct {
+		key   string
+		value int
+	}{
+		{"output.queue_size", cfg.Output.QueueSize},
+		{"output.publish_timeout", cfg.Output.PublishTimeout},
+		{"output.shutdown_timeout", cfg.Output.ShutdownTimeout},
+	} {
+		if setting.value < 0 {
+			report.errorf(setting.key, "must not be negative")
+		}
+	}
+
+	if modbusConfigured(cfg.Modbus) {
+		validateModbus(cfg, report)
+	}
+	if snmpConfigured(cfg.Snmp) {
+		validateSNMP(cfg.Snmp, report)
+	}
+	if grpcConfigured(cfg.Grpc) {
+		validateGRPC(cfg.Grpc, report)
+	}
+
+	if cfg.Capture.Directory != "" {
+		if cfg.Capture.MaxFileSize < 1 {
+			report.errorf("capture.max_file_size", "must be at least 1 megabyte (default: 10)")
+		}
+		if cfg.Capture.MaxFiles < 0 {
+			report.errorf("capture.max_files", "must not be negative")
+		}
+	} else if report.has("capture") {
+		report.warnf("capture", "no directory configured, so responses aren't recorded")
+	}
+}
+
+func validateCloud(cfg tomlConfigCloud, report *configReport) {
+	if cfg.ReadKey == "" {
+		report.errorf("cloud.read_key", "missing; request an API read key from PurpleAir at develop.purpleair.com")
+	}
+	switch {
+	case cfg.GroupId == 0 && len(cfg.SensorIndexes) == 0:
+		report.errorf("cloud", "no sensors configured; set sensor_indexes or group_id")
+	case cfg.GroupId != 0 && len(cfg.SensorIndexes) > 0:
+		report.errorf("cloud.group_id", "can't be combined with sensor_indexes")
+	}
+	for _, index := range cfg.SensorIndexes {
+		if index < 1 {
+			report.errorf("cloud.sensor_indexes", "%d is not a sensor index", index)
+		}
+	}
+	if cfg.PollRate < 1 {
+		report.errorf("cloud.poll_rate", "must be at least 1 second (default: 300)")
+	} else if cfg.PollRate < 60 {
+		report.warnf("cloud.poll_rate", "the API only updates readings every couple of minutes, so polling every %d seconds spends points for little benefit", cfg.PollRate)
+	}
+	if cfg.Timeout < 1 {
+		report.errorf("cloud.timeout", "must be at least 1 second (default: 15)")
+	}
+	if cfg.PointsPerDay < 0 {
+		report.errorf("cloud.points_per_day", "must not be negative")
+	}
+	if u, err := url.Parse(cfg.Url); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		report.errorf("cloud.url", "%q is not an http:// or https:// URL", cfg.Url)
+	}
+}
+
+func validateMQTT(cfg tomlConfig, i int, report *configReport) {
+	target := cfg.Mqtt[i]
+	key := func(k string) string { return mqttKey(cfg, i, k) }
+	if target.BrokerHost == "" {
+		report.errorf(key("broker_host"), "missing; set it to your MQTT broker's hostname")
+	}
+	if target.BrokerPort < 1 || target.BrokerPort > 65535 {
+		report.errorf(key("broker_port"), "%d is not a valid port", target.BrokerPort)
+	}
+	switch target.Mode {
+	case mqttModeAlways:
+	case mqttModeFailover:
+		if i == 0 {
+			report.errorf(key("mode"), "the first target is the primary, so it can't be %s", mqttModeFailover)
+		}
+	default:
+		report.errorf(key("mode"), "%q is not %s or %s", target.Mode, mqttModeAlways, mqttModeFailover)
+	}
+	switch target.Output {
+	case mqttOutputTopics:
+	case mqttOutputHomie:
+		if target.HomieVersion != 4 && target.HomieVersion != 5 {
+			report.errorf(key("homie_version"), "%d is not 4 or 5", target.HomieVersion)
+		}
+	case mqttOutputSparkplug:
+		validateSparkplug(target, key, report)
+	default:
+		report.errorf(key("output"), "%q is not one of %s, %s or %s", target.Output, mqttOutputTopics, mqttOutputHomie, mqttOutputSparkplug)
+	}
+	if target.Output == mqttOutputHomie || target.Output == mqttOutputSparkplug {
+		if target.PayloadTemplate != "" {
+			report.warnf(key("payload_template"), "is ignored with output = %q, whose values are formatted by their datatype", target.Output)
+		}
+		if len(target.Topics) > 0 {
+			report.warnf(key("topics"), "is ignored with output = %q", target.Output)
+		}
+	}
+	if err := checkTopicTemplate(target.TopicTemplate); err != nil {
+		report.errorf(key("topic_template"), "%s", err)
+	} else if manySensors(cfg) && target.Topic != "" {
+		report.warnf(key("topic"), "is used for every sensor, so their readings are published to the same topics")
+	} else if manySensors(cfg) && target.Output == mqttOutputTopics && !strings.Contains(target.TopicTemplate, "{geo}") && !strings.Contains(target.TopicTemplate, "{sensor_id}") {
+		report.warnf(key("topic_template"), "has neither {geo} nor {sensor_id}, so every sensor's readings are published to the same topics")
+	}
+	if target.PayloadTemplate != "" {
+		if _, err := newPayloadTemplate("payload", target.PayloadTemplate); err != nil {
+			report.errorf(key("payload_template"), "%s", err)
+		}
+	}
+	for _, name := range sortedKeys(target.Topics) {
+		if sanitizeTopicLevel(name) == "" {
+			report.errorf(key("topics"), "a topic needs a name")
+		} else if _, err := newPayloadTemplate(name, target.Topics[name]); err != nil {
+			report.errorf(key("topics."+name), "%s", err)
+		}
+	}
+	switch target.TopicLayout {
+	case topicLayoutLegacy, topicLayoutV2, topicLayoutBoth:
+	default:
+		report.errorf(key("topic_layout"), "%q is not one of %s, %s or %s", target.TopicLayout, topicLayoutLegacy, topicLayoutV2, topicLayoutBoth)
+	}
+	switch target.FieldStyle {
+	case fieldStyleGo, fieldStyleSnake, fieldStyleCamel:
+	default:
+		report.errorf(key("field_style"), "%q is not one of %s, %s or %s", target.FieldStyle, fieldStyleGo, fieldStyleSnake, fieldStyleCamel)
+	}
+	warnPartialCredentials(report, key("broker_username"), target.BrokerUsername, key("broker_password"), target.BrokerPassword)
+	checkExtraFields(report, key("extra_fields"), target.ExtraFields)
+	for _, pattern := range target.Fields {
+		if _, err := path.Match(pattern, ""); err != nil {
+			report.errorf(key("fields"), "%q is not a valid pattern", pattern)
+		}
+	}
+	if target.Qos < 0 || target.Qos > 2 {
+		report.errorf(key("qos"), "%d is not 0, 1 or 2", target.Qos)
+	}
+	if target.KeepAlive < 1 {
+		report.errorf(key("keep_alive"), "must be at least 1 second (default: 30)")
+	}
+	if target.ConnectRetryInterval < 1 {
+		report.errorf(key("connect_retry_interval"), "must be at least 1 second (default: 10)")
+	}
+	if target.MaxReconnectInterval < 1 {
+		report.errorf(key("max_reconnect_interval"), "must be at least 1 second (default: 60)")
+	}
+	if target.PersistentSession && target.ClientId == "" {
+		report.errorf(key("persistent_session"), "needs %s, which the broker keeps the session under", key("client_id"))
+	}
+	if target.StoreDirectory != "" && !target.PersistentSession {
+		report.warnf(key("store_directory"), "has no effect without %s; stored messages are discarded on connect", key("persistent_session"))
+	}
+}
+
+func validateSparkplug(target tomlConfigMQTT, key func(string) string, report *configReport) {
+	ids := []struct{ key, id string }{
+		{"sparkplug_group_id", target.SparkplugGroupId},
+		{"sparkplug_edge_node", target.SparkplugEdgeNode},
+	}
+	for _, id := range ids {
+		if id.id == "" || id.id != sanitizeTopicLevel(id.id) {
+			report.errorf(key(id.key), "%q is not a valid Sparkplug ID; it can't be empty or contain /, +, #, whitespace or control characters", id.id)
+		}
+	}
+	if target.SparkplugOfflineAfter < 1 {
+		report.errorf(key("sparkplug_offline_after"), "must be at least 1 second (default: 900)")
+	}
+	if target.PersistentSession {
+		report.errorf(key("persistent_session"), "can't be used with output = %q; Sparkplug requires a clean session", mqttOutputSparkplug)
+	}
+	if target.Qos != 0 {
+		report.warnf(key("qos"), "is ignored with output = %q; Sparkplug publishes births and data at QoS 0", mqttOutputSparkplug)
+	}
+}
+
+// manySensors reports whether readings may come from more than one sensor.
+// An API group or a receiver open to any sensor may always have several.
+func manySensors(cfg tomlConfig) bool {
+	n := len(cfg.PurpleAir)
+	if cloudConfigured(cfg.Cloud) {
+		if cfg.Cloud.GroupId != 0 {
+			return true
+		}
+		n += len(cfg.Cloud.SensorIndexes)
+	}
+	if receiverConfigured(cfg.Receiver) {
+		if len(cfg.Receiver.Sensors) == 0 {
+			return true
+		}
+		n += len(cfg.Receiver.Sensors)
+	}
+	return n > 1
+}
+
+func validateReceiver(cfg tomlConfigReceiver, report *configReport) {
+	if cfg.Listen == "" {
+		report.errorf("receiver.listen", "missing; set it to the address to accept pushes on, e.g. \":8080\"")
+	} else if _, _, err := net.SplitHostPort(cfg.Listen); err != nil {
+		report.errorf("receiver.listen", "%q is not a host:port address", cfg.Listen)
+	}
+	if !strings.HasPrefix(cfg.Path, "/") {
+		report.errorf("receiver.path", "%q must start with /", cfg.Path)
+	}
+	for _, mac := range cfg.Sensors {
+		if _, err := net.ParseMAC(mac); err != nil {
+			report.errorf("receiver.sensors", "%q is not a MAC address", mac)
+		}
+	}
+	if cfg.Secret == "" && len(cfg.Sensors) == 0 {
+		report.warnf("receiver", "neither secret nor sensors is set, so pushes are accepted from anyone who can reach the receiver")
+	}
+}
+
+func validateModbus(cfg tomlConfig, report *configReport) {
+	if cfg.Modbus.Listen == "" {
+		report.errorf("modbus.listen", "missing; set it to the address to serve Modbus TCP on, e.g. \":502\"")
+	} else if _, _, err := net.SplitHostPort(cfg.Modbus.Listen); err != nil {
+		report.errorf("modbus.listen", "%q is not a host:port address", cfg.Modbus.Listen)
+	}
+	units := map[int]string{}
+	for _, sensor := range sortedKeys(cfg.Modbus.Units) {
+		unit := cfg.Modbus.Units[sensor]
+		if unit < 1 || unit > 247 {
+			report.errorf("modbus.units", "unit ID %d for %q is not between 1 and 247", unit, sensor)
+		} else if other, ok := units[unit]; ok {
+			report.errorf("modbus.units", "unit ID %d for %q is also used by %q", unit, sensor, other)
+		}
+		units[unit] = sensor
+	}
+	if len(cfg.Modbus.Units) == 0 && manySensors(cfg) {
+		report.warnf("modbus.units", "not set while several sensors are configured, so every unit ID reads whichever sensor reported last")
+	}
+	if cfg.Modbus.StaleAfter < 1 {
+		report.errorf("modbus.stale_after", "must be at least 1 second (default: 900)")
+	}
+}
+
+func validateSNMP(cfg tomlConfigSNMP, report *configReport) {
+	if cfg.Listen == "" {
+		report.errorf("snmp.listen", "missing; set it to the UDP address to answer SNMP on, e.g. \":161\"")
+	} else if _, _, err := net.SplitHostPort(cfg.Listen); err != nil {
+		report.errorf("snmp.listen", "%q is not a host:port address", cfg.Listen)
+	}
+	if cfg.Community == "" && len(cfg.Users) == 0 {
+		report.errorf("snmp", "neither community nor users is set, so no request would be answered")
+	}
+	if cfg.EngineId != "" {
+		if id, err := hex.DecodeString(cfg.EngineId); err != nil || len(id) < 5 || len(id) > 32 {
+			report.errorf("snmp.engine_id", "%q is not 5 to 32 bytes in hex", cfg.EngineId)
+		}
+	}
+	if cfg.StaleAfter < 1 {
+		report.errorf("snmp.stale_after", "must be at least 1 second (default: 900)")
+	}
+
+	names := map[string]bool{}
+	for i, user := range cfg.Users {
+		key := func(k string) string {
+			if len(cfg.Users) == 1 {
+				return "snmp.users." + k
+			}
+			return fmt.Sprintf("snmp.users[%d].%s", i, k)
+		}
+		if user.Name == "" {
+			report.errorf(key("name"), "missing")
+		} else if names[user.Name] {
+			report.errorf(key("name"), "%q is configured more than once", user.Name)
+		}
+		names[user.Name] = true
+
+		_, knownAuth := snmpAuthProtocols[user.AuthProtocol]
+		switch {
+		case user.AuthProtocol == "":
+			if user.AuthPassword != "" {
+				report.warnf(key("auth_password"), "has no effect without auth_protocol")
+			}
+		case !knownAuth:
+			report.errorf(key("auth_protocol"), "%q is not SHA or SHA-256", user.AuthProtocol)
+		case len(user.AuthPassword) < 8:
+			report.errorf(key("auth_password"), "must be at least 8 characters")
+		}
+
+		switch {
+		case user.PrivProtocol == "":
+			if user.PrivPassword != "" {
+				report.warnf(key("priv_password"), "has no effect without priv_protocol")
+			}
+		case user.PrivProtocol != snmpPrivAES:
+			report.errorf(key("priv_protocol"), "%q is not AES", user.PrivProtocol)
+		case user.AuthProtocol == "":
+			report.errorf(key("priv_protocol"), "needs auth_protocol; SNMPv3 has no privacy without authentication")
+		case len(user.PrivPassword) < 8:
+			report.errorf(key("priv_password"), "must be at least 8 characters")
+		}
+	}
+}
+
+func validateGRPC(cfg tomlConfigGRPC, report *configReport) {
+	if cfg.Listen == "" {
+		report.errorf("grpc.listen", "missing; set it to the address to serve gRPC on, e.g. \":50051\"")
+	} else if _, _, err := net.SplitHostPort(cfg.Listen); err != nil {
+		report.errorf("grpc.listen", "%q is not a host:port address", cfg.Listen)
+	}
+	switch {
+	case cfg.TlsCert != "" && cfg.TlsKey == "":
+		report.errorf("grpc.tls_key", "missing; tls_cert needs its private key")
+	case cfg.TlsCert == "" && cfg.TlsKey != "":
+		report.errorf("grpc.tls_cert", "missing; tls_key needs its certificate")
+	case cfg.TlsCert == "" && cfg.Token != "":
+		report.warnf("grpc.token", "is sent in plaintext since tls_cert isn't set")
+	}
+	if cfg.StreamBuffer < 1 {
+		report.errorf("grpc.stream_buffer", "must be at least 1 reading (default: 16)")
+	}
+}
+
+func checkExtraFields(report *configReport, key, policy string) {
+	switch policy {
+	case "", extraFieldsNone, extraFieldsNumeric, extraFieldsAll:
+	default:
+		report.errorf(key, "%q is not one of %s, %s or %s", policy, extraFieldsNone, extraFieldsNumeric, extraFieldsAll)
+	}
+}
+
+// warnPartialCredentials warns when only half of a username/password pair is
+// set; credentials are only sent when both are.
+func warnPartialCredentials(report *configReport, userKey, user, passKey, pass string) {
+	switch {
+	case user != "" && pass == "":
+		report.warnf(userKey, "has no effect without %s", passKey)
+	case user == "" && pass != "":
+		report.warnf(passKey, "has no effect without %s", userKey)
+	}
+}
+
+// watchConfigFile calls onChange whenever the file at path is written,
+// replaced or re-linked. The directory is watched rather than the file itself
+// because editors and Kubernetes ConfigMaps replace the file instead of
+// writing to it. Bursts of events are coalesced into a single call.
+func watchConfigFile(ctx context.Context, path string, onChange func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	path = filepath.Clean(path)
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		_ = watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer func() { _ = watcher.Close() }()
+		var debounce <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) == path || filepath.Base(event.Name) == "..data" {
+					debounce = time.After(500 * time.Millisecond)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Warnf("error watching %s: %s", path, err)
+			case <-debounce:
+				debounce = nil
+				logger.Infof("%s changed", path)
+				onChange()
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, dir string, contents string) string {
+	t.Helper()
+	path := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigSensors(t *testing.T) {
+	tests := []struct {
+		name         string
+		contents     string
+		expectedUrls []string
+	}{
+		{"Single table", `
+[purpleair]
+    url = "http://192.168.1.24/json"
+    poll_rate = 120
+`, []string{"http://192.168.1.24/json"}},
+		{"Array of tables", `
+[[purpleair]]
+    url = "http://192.168.1.24/json"
+    poll_rate = 120
+[[purpleair]]
+    url = "http://192.168.1.25/json"
+    poll_rate = 60
+`, []string{"http://192.168.1.24/json", "http://192.168.1.25/json"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadConfig(writeConfig(t, t.TempDir(), tt.contents), configFlags{})
+			if err != nil {
+				t.Fatalf("loadConfig() error = %v", err)
+			}
+			var urls []string
+			for _, sensor := range cfg.PurpleAir {
+				urls = append(urls, sensor.Url)
+			}
+			if strings.Join(urls, ",") != strings.Join(tt.expectedUrls, ",") {
+				t.Errorf("sensor URLs = %v, want %v", urls, tt.expectedUrls)
+			}
+		})
+	}
+}
+
+func TestParseConfigProblems(t *testing.T) {
+	tests := []struct {
+		name             string
+		contents         string
+		expectedErrors   []string
+		expectedWarnings []string
+	}{
+		{"Valid", `
+[purpleair]
+    url = "http://192.168.1.24/json"
+`, nil, nil},
+		{"No sensors", `
+[mqtt]
+    broker_host = "localhost"
+`, []string{"line 0: purpleair: no sensor configured"}, nil},
+		{"Every problem at once", `
+[purpleair]
+    poll_rate = 0
+    pollrate_typo = 5
+
+[mqtt]
+    broker_host = "localhost"
+    broker_port = 99999
+    broker_username = "me"
+
+[output]
+    overflow = "sometimes"
+`, []string{
+			"line 2: purpleair.url: missing",
+			"line 3: purpleair.poll_rate: must be at least 1 second",
+			"line 4: purpleair.pollrate_typo: unknown setting",
+			"line 8: mqtt.broker_port: 99999 is not a valid port",
+			`line 12: output.overflow: "sometimes" is not one of`,
+		}, []string{
+			"line 9: mqtt.broker_username: has no effect without mqtt.broker_password",
+		}},
+		{"Duplicate sensor", `
+[[purpleair]]
+    url = "http://192.168.1.24/json"
+[[purpleair]]
+    url = "http://192.168.1.24/json"
+`, []string{"line 5: purpleair[1].url: http://192.168.1.24/json is configured more than once"}, nil},
+		{"Type error doesn't hide other sections", `
+[purpleair]
+    url = "http://192.168.1.24/json"
+    poll_rate = "fast"
+
+[influx]
+    hostname = "localhost"
+    username = "me"
+`, []string{"line 4: purpleair.poll_rate: cannot unmarshal TOML string into int"}, []string{
+			"line 8: influx.username: has no effect without influx.password",
+		}},
+		{"Hass without MQTT", `
+[purpleair]
+    url = "http://192.168.1.24/json"
+[hass]
+    discovery = true
+`, []string{"line 4: hass: Hass configuration found but no MQTT configuration found"}, nil},
+		{"Bad webhook template", `
+[purpleair]
+    url = "http://192.168.1.24/json"
+[webhook]
+    urls = ["http://localhost/hook"]
+    template = "{{.EPAAQI"
+`, []string{"line 6: webhook.template: "}, nil},
+		{"Cloud only", `
+[cloud]
+    read_key = "KEY"
+    sensor_indexes = [12345, 67890]
+`, nil, nil},
+		{"Cloud problems", `
+[cloud]
+    group_id = 42
+    sensor_indexes = [12345]
+    poll_rate = 30
+`, []string{
+			"line 2: cloud.read_key: missing",
+			"line 3: cloud.group_id: can't be combined with sensor_indexes",
+		}, []string{
+			"line 5: cloud.poll_rate: the API only updates readings every couple of minutes",
+		}},
+		{"Receiver only", `
+[receiver]
+    listen = ":8080"
+    secret = "s3cret"
+`, nil, nil},
+		{"Receiver problems", `
+[receiver]
+    listen = "8080"
+    sensors = ["84:f3:eb:00:00:01", "backyard"]
+`, []string{
+			"line 3: receiver.listen: \"8080\" is not a host:port address",
+			"line 4: receiver.sensors: \"backyard\" is not a MAC address",
+		}, nil},
+		{"Open receiver", `
+[receiver]
+    listen = ":8080"
+`, nil, []string{"line 2: receiver: neither secret nor sensors is set"}},
+		{"Modbus problems", `
+[purpleair]
+    url = "http://192.168.1.24/json"
+[modbus]
+    listen = "502"
+    stale_after = 0
+[modbus.units]
+    "84:f3:eb:00:00:01" = 0
+    "Back yard" = 3
+    "Front yard" = 3
+`, []string{
+			"line 5: modbus.listen: \"502\" is not a host:port address",
+			"line 6: modbus.stale_after: must be at least 1 second",
+			`line 7: modbus.units: unit ID 0 for "84:f3:eb:00:00:01" is not between 1 and 247`,
+			`line 7: modbus.units: unit ID 3 for "Front yard" is also used by "Back yard"`,
+		}, nil},
+		{"SNMP problems", `
+[purpleair]
+    url = "http://192.168.1.24/json"
+[snmp]
+    listen = ":161"
+    engine_id = "80001f88"
+[[snmp.users]]
+    name = "noc"
+    auth_protocol = "MD5"
+    auth_password = "secret"
+[[snmp.users]]
+    name = "noc"
+    priv_protocol = "AES"
+    priv_password = "privpass1"
+`, []string{
+			`line 6: snmp.engine_id: "80001f88" is not 5 to 32 bytes in hex`,
+			`line 9: snmp.users[0].auth_protocol: "MD5" is not SHA or SHA-256`,
+			`line 12: snmp.users[1].name: "noc" is configured more than once`,
+			"line 13: snmp.users[1].priv_protocol: needs auth_protocol",
+		}, nil},
+		{"SNMP without access", `
+[purpleair]
+    url = "http://192.168.1.24/json"
+[snmp]
+    listen = ":161"
+`, []string{"line 4: snmp: neither community nor users is set"}, nil},
+		{"gRPC problems", `
+[purpleair]
+    url = "http://192.168.1.24/json"
+[grpc]
+    listen = "50051"
+    tls_cert = "/etc/purpleair2mqtt/grpc.crt"
+    stream_buffer = 0
+`, []string{
+			"line 4: grpc.tls_key: missing",
+			`line 5: grpc.listen: "50051" is not a host:port address`,
+			"line 7: grpc.stream_buffer: must be at least 1 reading",
+		}, nil},
+		{"MQTT session problems", `
+[purpleair]
+    url = "http://192.168.1.24/json"
+[mqtt]
+    broker_host = "localhost"
+    qos = 3
+    keep_alive = 0
+    persistent_session = true
+    store_directory = "/tmp/mqtt"
+`, []string{
+			"line 6: mqtt.qos: 3 is not 0, 1 or 2",
+			"line 7: mqtt.keep_alive: must be at least 1 second",
+			"line 8: mqtt.persistent_session: needs mqtt.client_id",
+		}, nil},
+		{"MQTT store without session", `
+[purpleair]
+    url = "http://192.168.1.24/json"
+[mqtt]
+    broker_host = "localhost"
+    qos = 1
+    store_directory = "/tmp/mqtt"
+`, nil, []string{"line 7: mqtt.store_directory: has no effect without mqtt.persistent_session"}},
+		{"MQTT targets", `
+[purpleair]
+    url = "http://192.168.1.24/json"
+[[mqtt]]
+    broker_host = "mosquitto.local"
+    client_id = "purpleair2mqtt"
+[[mqtt]]
+    broker_host = "broker.example.com"
+    client_id = "purpleair2mqtt"
+    mode = "failover"
+    fields = ["EPAAQI", "sensor_*/epa_aqi"]
+`, nil, nil},
+		{"MQTT target problems", `
+[purpleair]
+    url = "http://192.168.1.24/json"
+[[mqtt]]
+    broker_host = "localhost"
+    client_id = "purpleair2mqtt"
+    mode = "failover"
+[[mqtt]]
+    broker_host = "localhost"
+    client_id = "purpleair2mqtt"
+    mode = "sometimes"
+    fields = ["sensor_[A"]
+`, []string{
+			"line 7: mqtt[0].mode: the first target is the primary",
+			"line 10: mqtt[1].client_id: \"purpleair2mqtt\" is also used by mqtt[0]",
+			"line 11: mqtt[1].mode: \"sometimes\" is not always or failover",
+			"line 12: mqtt[1].fields: \"sensor_[A\" is not a valid pattern",
+		}, nil},
+		{"Homie problems", `
+[purpleair]
+    url = "http://192.168.1.24/json"
+[[mqtt]]
+    broker_host = "localhost"
+    output = "homie"
+    homie_version = 3
+    payload_template = "{{.Value}}"
+[[mqtt]]
+    broker_host = "localhost"
+    client_id = "mirror"
+    output = "sparkles"
+[hass]
+    discovery = true
+`, []string{
+			"line 7: mqtt[0].homie_version: 3 is not 4 or 5",
+			"line 12: mqtt[1].output: \"sparkles\" is not one of topics, homie or sparkplug",
+		}, []string{
+			"line 8: mqtt[0].payload_template: is ignored with output = \"homie\"",
+			"line 14: hass.discovery: has no effect",
+		}},
+		{"Sparkplug problems", `
+[purpleair]
+    url = "http://192.168.1.24/json"
+[mqtt]
+    broker_host = "localhost"
+    client_id = "bridge"
+    output = "sparkplug"
+    sparkplug_group_id = "Plant/1"
+    sparkplug_offline_after = 0
+    persistent_session = true
+    qos = 1
+`, []string{
+			"line 8: mqtt.sparkplug_group_id: \"Plant/1\" is not a valid Sparkplug ID",
+			"line 9: mqtt.sparkplug_offline_after: must be at least 1 second",
+			"line 10: mqtt.persistent_session: can't be used with output = \"sparkplug\"",
+		}, []string{
+			"line 11: mqtt.qos: is ignored with output = \"sparkplug\"",
+		}},
+		{"Topic problems", `
+[[purpleair]]
+    url = "http://192.168.1.24/json"
+[[purpleair]]
+    url = "http://192.168.1.25/json"
+[[mqtt]]
+    broker_host = "localhost"
+    topic_template = "{prefix}/{location}/{field}"
+    field_style = "kebab"
+    topic_layout = "v3"
+[[mqtt]]
+    broker_host = "localhost"
+    client_id = "fleet"
+    topic_template = "readings/{field}"
+`, []string{
+			"line 8: mqtt[0].topic_template: unknown placeholder {location}",
+			`line 9: mqtt[0].field_style: "kebab" is not one of go, snake_case or camelCase`,
+			`line 10: mqtt[0].topic_layout: "v3" is not one of legacy, v2 or both`,
+		}, []string{
+			"line 14: mqtt[1].topic_template: has neither {geo} nor {sensor_id}",
+		}},
+		{"Bad payload templates", `
+[purpleair]
+    url = "http://192.168.1.24/json"
+[mqtt]
+    broker_host = "localhost"
+    payload_template = "{{round 1 .Value"
+[mqtt.topics]
+    state = "{{nosuchfunc .EPAAQI}}"
+`, []string{
+			"line 6: mqtt.payload_template: ",
+			"line 8: mqtt.topics.state: ",
+		}, nil},
+		{"Syntax error", `
+[purpleair
+`, []string{"line 3: invalid TOML syntax"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, report := parseConfig([]byte(tt.contents), nil, configFlags{})
+			checkProblems(t, "errors", report.Errors, tt.expectedErrors)
+			checkProblems(t, "warnings", report.Warnings, tt.expectedWarnings)
+		})
+	}
+}
+
+func checkProblems(t *testing.T, kind string, got []configProblem, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("got %d %s, want %d: %v", len(got), kind, len(want), got)
+		return
+	}
+	for i, p := range got {
+		msg := p.String()
+		if p.Line == 0 {
+			msg = "line 0: " + msg
+		}
+		if !strings.HasPrefix(msg, want[i]) {
+			t.Errorf("%s[%d] = %q, want prefix %q", kind, i, msg, want[i])
+		}
+	}
+}
+
+func TestLoadConfigMQTTTargets(t *testing.T) {
+	cfg, err := loadConfig(writeConfig(t, t.TempDir(), `
+[purpleair]
+    url = "http://192.168.1.24/json"
+[[mqtt]]
+    broker_host = "mosquitto.local"
+[[mqtt]]
+    name = "fleet"
+    broker_host = "broker.example.com"
+    broker_port = 8883
+    topic_prefix = "fleet"
+    mode = "failover"
+`), configFlags{Topic: "backyard"})
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if len(cfg.Mqtt) != 2 {
+		t.Fatalf("got %d MQTT targets, want 2", len(cfg.Mqtt))
+	}
+	primary, fleet := cfg.Mqtt[0], cfg.Mqtt[1]
+	if primary.BrokerPort != 1883 || primary.TopicPrefix != "purpleair" || primary.Mode != mqttModeAlways {
+		t.Errorf("primary = %+v, want defaults", primary)
+	}
+	if fleet.BrokerPort != 8883 || fleet.TopicPrefix != "fleet" || fleet.Mode != mqttModeFailover || fleet.KeepAlive != 30 {
+		t.Errorf("fleet = %+v", fleet)
+	}
+	if primary.Topic != "backyard" || fleet.Topic != "backyard" {
+		t.Errorf("topics = %q, %q, want -topic applied to both", primary.Topic, fleet.Topic)
+	}
+}
+
+func TestParseConfigDefaults(t *testing.T) {
+	cfg, report := parseConfig([]byte(`
+[purpleair]
+    url = "http://192.168.1.24/json"
+[mqtt]
+    broker_host = "localhost"
+[influx]
+    hostname = "localhost"
+`), nil, configFlags{})
+	if err := report.Err(); err != nil {
+		t.Fatalf("parseConfig() error = %v", err)
+	}
+	if cfg.PurpleAir[0].PollRate != 120 || cfg.PurpleAir[0].Timeout != 15 {
+		t.Errorf("purpleair poll_rate, timeout = %d, %d, want 120, 15", cfg.PurpleAir[0].PollRate, cfg.PurpleAir[0].Timeout)
+	}
+	if cfg.Mqtt[0].BrokerPort != 1883 || cfg.Mqtt[0].TopicPrefix != "purpleair" {
+		t.Errorf("mqtt broker_port, topic_prefix = %d, %s, want 1883, purpleair", cfg.Mqtt[0].BrokerPort, cfg.Mqtt[0].TopicPrefix)
+	}
+	if cfg.Mqtt[0].KeepAlive != 30 || cfg.Mqtt[0].ConnectRetryInterval != 10 || cfg.Mqtt[0].MaxReconnectInterval != 60 {
+		t.Errorf("mqtt keep_alive, connect_retry_interval, max_reconnect_interval = %d, %d, %d, want 30, 10, 60",
+			cfg.Mqtt[0].KeepAlive, cfg.Mqtt[0].ConnectRetryInterval, cfg.Mqtt[0].MaxReconnectInterval)
+	}
+	if cfg.Influx.Port != 8086 || cfg.Influx.Database != "purpleair" {
+		t.Errorf("influx port, database = %d, %s, want 8086, purpleair", cfg.Influx.Port, cfg.Influx.Database)
+	}
+}
+
+func TestWatchConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, dir, "# original\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan struct{}, 10)
+	if err := watchConfigFile(ctx, path, func() { changed <- struct{}{} }); err != nil {
+		t.Fatalf("watchConfigFile() error = %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "unrelated.txt"), []byte("x"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	writeConfig(t, dir, "# updated\n")
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("onChange was not called after the config file was written")
+	}
+	select {
+	case <-changed:
+		t.Error("onChange was called more than once for a single change")
+	case <-time.After(700 * time.Millisecond):
+	}
+}
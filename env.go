@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/naoina/toml"
+)
+
+// envPrefix starts the name of every environment variable that overrides a
+// configuration setting, e.g. PA2MQTT_MQTT_BROKER_PASSWORD for
+// mqtt.broker_password.
+const envPrefix = "PA2MQTT"
+
+// envFileSuffix marks a variable holding the path of a file to read the
+// setting from instead of the setting itself, e.g. a Docker secret in
+// /run/secrets.
+const envFileSuffix = "_FILE"
+
+// applyEnvOverrides sets every configuration key that has a matching
+// PA2MQTT_* variable in environ, which takes precedence over the
+// configuration file. Settings found this way count as set for defaults and
+// validation, and problems with them name the variable they came from.
+func applyEnvOverrides(cfg *tomlConfig, environ []string, report *configReport) {
+	env := map[string]string{}
+	for _, kv := range environ {
+		name, value, ok := strings.Cut(kv, "=")
+		if ok && strings.HasPrefix(name, envPrefix+"_") {
+			env[name] = value
+		}
+	}
+	if len(env) == 0 {
+		return
+	}
+
+	used := map[string]bool{}
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		// sections are written as one word, e.g. [purpleair] rather than [purple_air]
+		section := strings.ToLower(t.Field(i).Name)
+		name := envPrefix + "_" + strings.ToUpper(section)
+		field := v.Field(i)
+		if field.Kind() == reflect.Slice {
+			applyEnvSlice(field, name, section, env, used, report)
+		} else {
+			applyEnvStruct(field, name, section, env, used, report)
+		}
+	}
+
+	var unused []string
+	for name := range env {
+		if !used[name] {
+			unused = append(unused, name)
+		}
+	}
+	sort.Strings(unused)
+	for _, name := range unused {
+		report.warnf("", "environment variable %s doesn't match any setting", name)
+	}
+}
+
+// applyEnvSlice applies overrides to a section that may be given more than
+// once, like [[purpleair]] or [[mqtt]]. PA2MQTT_PURPLEAIR_1_URL sets the url
+// of the second sensor; the unnumbered PA2MQTT_PURPLEAIR_URL is the first
+// sensor's. Sensors and targets are added as needed.
+func applyEnvSlice(field reflect.Value, name, section string, env map[string]string, used map[string]bool, report *configReport) {
+	count := field.Len()
+	for envName := range env {
+		rest, ok := strings.CutPrefix(envName, name+"_")
+		if !ok {
+			continue
+		}
+		index := 0
+		if digits, _, ok := strings.Cut(rest, "_"); ok {
+			if n, err := strconv.Atoi(digits); err == nil && n >= 0 {
+				index = n
+			}
+		}
+		count = max(count, index+1)
+	}
+	for field.Len() < count {
+		field.Set(reflect.Append(field, reflect.Zero(field.Type().Elem())))
+	}
+
+	for i := 0; i < field.Len(); i++ {
+		elem := field.Index(i)
+		path := fmt.Sprintf("%s[%d]", section, i)
+		if field.Len() == 1 {
+			path = section
+		}
+		applyEnvStruct(elem, fmt.Sprintf("%s_%d", name, i), path, env, used, report)
+		if i == 0 {
+			applyEnvStruct(elem, name, path, env, used, report)
+		}
+	}
+}
+
+func applyEnvStruct(v reflect.Value, name, path string, env map[string]string, used map[string]bool, report *configReport) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := toml.DefaultConfig.FieldToKey(t, t.Field(i).Name)
+		envName := name + "_" + strings.ToUpper(key)
+		keyPath := path + "." + key
+		if f := v.Field(i); f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.Struct {
+			applyEnvSlice(f, envName, keyPath, env, used, report)
+			continue
+		}
+
+		value, ok, err := lookupEnv(env, used, envName)
+		if err != nil {
+			report.Errors = append(report.Errors, configProblem{Key: keyPath, Message: err.Error()})
+			continue
+		}
+		if !ok {
+			continue
+		}
+		report.sources[keyPath] = envName
+		if err := setFromEnv(v.Field(i), value); err != nil {
+			report.errorf(keyPath, "%s", err)
+			continue
+		}
+		report.lines[keyPath] = 0
+	}
+}
+
+// lookupEnv returns the value of the variable name, or the contents of the
+// file named by name_FILE. Setting both is an error, since it's unclear which
+// one was meant.
+func lookupEnv(env map[string]string, used map[string]bool, name string) (string, bool, error) {
+	value, ok := env[name]
+	path, fromFile := env[name+envFileSuffix]
+	used[name] = used[name] || ok
+	used[name+envFileSuffix] = used[name+envFileSuffix] || fromFile
+	switch {
+	case ok && fromFile:
+		return "", false, fmt.Errorf("both %s and %s%s are set", name, name, envFileSuffix)
+	case fromFile:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", false, fmt.Errorf("reading %s%s: %w", name, envFileSuffix, err)
+		}
+		// files written by editors and `echo` end with a newline that isn't
+		// part of the secret
+		return strings.TrimRight(string(data), "\r\n"), true, nil
+	}
+	return value, ok, nil
+}
+
+// setFromEnv parses value into field. Lists are comma-separated, and maps are
+// comma-separated name=value pairs.
+func setFromEnv(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", value)
+		}
+		field.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", value)
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		list := reflect.MakeSlice(field.Type(), 0, 0)
+		for _, item := range splitEnvList(value) {
+			elem := reflect.New(field.Type().Elem()).Elem()
+			if err := setFromEnv(elem, item); err != nil {
+				return err
+			}
+			list = reflect.Append(list, elem)
+		}
+		field.Set(list)
+	case reflect.Map:
+		m := reflect.MakeMap(field.Type())
+		for _, item := range splitEnvList(value) {
+			k, v, ok := strings.Cut(item, "=")
+			if !ok {
+				return fmt.Errorf("%q is not a name=value pair", item)
+			}
+			elem := reflect.New(field.Type().Elem()).Elem()
+			if err := setFromEnv(elem, strings.TrimSpace(v)); err != nil {
+				return err
+			}
+			m.SetMapIndex(reflect.ValueOf(strings.TrimSpace(k)), elem)
+		}
+		field.Set(m)
+	default:
+		return fmt.Errorf("can't be set from the environment")
+	}
+	return nil
+}
+
+func splitEnvList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyEnvOverrides(t *testing.T) {
+	secrets := t.TempDir()
+	passwordFile := filepath.Join(secrets, "mqtt_password")
+	if err := os.WriteFile(passwordFile, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	const file = `
+[purpleair]
+    url = "http://192.168.1.24/json"
+[mqtt]
+    broker_host = "localhost"
+    broker_port = 1884
+    broker_username = "me"
+    broker_password = "from-toml"
+`
+
+	tests := []struct {
+		name             string
+		environ          []string
+		check            func(t *testing.T, cfg tomlConfig)
+		expectedErrors   []string
+		expectedWarnings []string
+	}{
+		{"No overrides", nil, func(t *testing.T, cfg tomlConfig) {
+			if cfg.Mqtt[0].BrokerPassword != "from-toml" || cfg.Mqtt[0].BrokerPort != 1884 {
+				t.Errorf("mqtt = %+v", cfg.Mqtt)
+			}
+		}, nil, nil},
+		{"Variable overrides file", []string{
+			"PA2MQTT_MQTT_BROKER_PASSWORD=from-env",
+			"PA2MQTT_MQTT_CLIENT_ID=pa2mqtt",
+			"PA2MQTT_PURPLEAIR_POLL_RATE=30",
+			"PA2MQTT_HASS_DISCOVERY=true",
+		}, func(t *testing.T, cfg tomlConfig) {
+			if cfg.Mqtt[0].BrokerPassword != "from-env" || cfg.Mqtt[0].ClientId != "pa2mqtt" {
+				t.Errorf("mqtt = %+v", cfg.Mqtt)
+			}
+			if cfg.PurpleAir[0].PollRate != 30 {
+				t.Errorf("poll_rate = %d, want 30", cfg.PurpleAir[0].PollRate)
+			}
+			if !cfg.Hass.Discovery {
+				t.Error("hass.discovery = false, want true")
+			}
+		}, nil, nil},
+		{"Secret read from file", []string{
+			"PA2MQTT_MQTT_BROKER_PASSWORD_FILE=" + passwordFile,
+		}, func(t *testing.T, cfg tomlConfig) {
+			if cfg.Mqtt[0].BrokerPassword != "from-file" {
+				t.Errorf("broker_password = %q, want from-file", cfg.Mqtt[0].BrokerPassword)
+			}
+		}, nil, nil},
+		{"Section only configured by environment", []string{
+			"PA2MQTT_INFLUX_HOSTNAME=influx",
+			"PA2MQTT_WEBHOOK_URLS=http://a.example/hook, http://b.example/hook",
+			"PA2MQTT_WEBHOOK_HEADERS=Authorization=Bearer token,X-Source=purpleair",
+		}, func(t *testing.T, cfg tomlConfig) {
+			if cfg.Influx.Hostname != "influx" || cfg.Influx.Port != 8086 {
+				t.Errorf("influx = %+v", cfg.Influx)
+			}
+			if len(cfg.Webhook.Urls) != 2 || cfg.Webhook.Urls[1] != "http://b.example/hook" {
+				t.Errorf("webhook.urls = %v", cfg.Webhook.Urls)
+			}
+			if cfg.Webhook.Headers["Authorization"] != "Bearer token" || cfg.Webhook.Headers["X-Source"] != "purpleair" {
+				t.Errorf("webhook.headers = %v", cfg.Webhook.Headers)
+			}
+		}, nil, nil},
+		{"Numbered sensor added", []string{
+			"PA2MQTT_PURPLEAIR_1_URL=http://192.168.1.25/json",
+		}, func(t *testing.T, cfg tomlConfig) {
+			if len(cfg.PurpleAir) != 2 || cfg.PurpleAir[1].Url != "http://192.168.1.25/json" || cfg.PurpleAir[1].PollRate != 120 {
+				t.Errorf("purpleair = %+v", cfg.PurpleAir)
+			}
+		}, nil, nil},
+		{"List of numbers", []string{
+			"PA2MQTT_CLOUD_READ_KEY=KEY",
+			"PA2MQTT_CLOUD_SENSOR_INDEXES=12345, 67890",
+		}, func(t *testing.T, cfg tomlConfig) {
+			if len(cfg.Cloud.SensorIndexes) != 2 || cfg.Cloud.SensorIndexes[1] != 67890 || cfg.Cloud.PollRate != 300 {
+				t.Errorf("cloud = %+v", cfg.Cloud)
+			}
+		}, nil, nil},
+		{"Map of numbers", []string{
+			"PA2MQTT_MODBUS_LISTEN=:5020",
+			"PA2MQTT_MODBUS_UNITS=84:f3:eb:00:00:01=2, Back yard=3",
+		}, func(t *testing.T, cfg tomlConfig) {
+			if cfg.Modbus.Units["84:f3:eb:00:00:01"] != 2 || cfg.Modbus.Units["Back yard"] != 3 || cfg.Modbus.StaleAfter != 900 {
+				t.Errorf("modbus = %+v", cfg.Modbus)
+			}
+		}, nil, nil},
+		{"List of sections in a section", []string{
+			"PA2MQTT_SNMP_LISTEN=:1161",
+			"PA2MQTT_SNMP_USERS_NAME=noc",
+			"PA2MQTT_SNMP_USERS_AUTH_PROTOCOL=SHA",
+			"PA2MQTT_SNMP_USERS_AUTH_PASSWORD_FILE=" + passwordFile,
+			"PA2MQTT_SNMP_USERS_1_NAME=librenms",
+		}, func(t *testing.T, cfg tomlConfig) {
+			if len(cfg.Snmp.Users) != 2 || cfg.Snmp.Users[0].AuthPassword != "from-file" || cfg.Snmp.Users[1].Name != "librenms" {
+				t.Errorf("snmp.users = %+v", cfg.Snmp.Users)
+			}
+		}, nil, nil},
+		{"Problems name the variable", []string{
+			"PA2MQTT_MQTT_BROKER_PORT=0",
+			"PA2MQTT_PURPLEAIR_TIMEOUT=soon",
+			"PA2MQTT_INFLUX_PASSWORD=secret",
+			"PA2MQTT_INFLUX_PASSWORD_FILE=" + passwordFile,
+			"PA2MQTT_MQTT_BROKER_PASWORD=typo",
+		}, nil, []string{
+			`line 0: purpleair.timeout: "soon" is not a whole number (set by PA2MQTT_PURPLEAIR_TIMEOUT)`,
+			"line 0: influx.password: both PA2MQTT_INFLUX_PASSWORD and PA2MQTT_INFLUX_PASSWORD_FILE are set",
+			"line 0: mqtt.broker_port: 0 is not a valid port (set by PA2MQTT_MQTT_BROKER_PORT)",
+		}, []string{
+			"line 0: environment variable PA2MQTT_MQTT_BROKER_PASWORD doesn't match any setting",
+		}},
+		{"Missing secret file", []string{
+			"PA2MQTT_MQTT_BROKER_PASSWORD_FILE=" + filepath.Join(secrets, "missing"),
+		}, nil, []string{"line 0: mqtt.broker_password: reading PA2MQTT_MQTT_BROKER_PASSWORD_FILE: open "}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, report := parseConfig([]byte(file), tt.environ, configFlags{})
+			checkProblems(t, "errors", report.Errors, tt.expectedErrors)
+			checkProblems(t, "warnings", report.Warnings, tt.expectedWarnings)
+			if tt.check != nil {
+				tt.check(t, cfg)
+			}
+		})
+	}
+}
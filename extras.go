@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Policies for publishing the extra fields of a reading: device fields that
+// purpleAirStatus doesn't have a field for.
+const (
+	extraFieldsNone    = "none"    // don't publish them (the default)
+	extraFieldsNumeric = "numeric" // publish numbers only
+	extraFieldsAll     = "all"     // publish everything; objects and arrays as JSON
+)
+
+// knownStatusKeys holds the lower-cased JSON key of every purpleAirStatus
+// field. encoding/json matches keys case-insensitively, so anything else is
+// a key the struct would drop.
+var knownStatusKeys = func() map[string]bool {
+	keys := map[string]bool{}
+	t := reflect.TypeOf(purpleAirStatus{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		keys[strings.ToLower(name)] = true
+	}
+	return keys
+}()
+
+// UnmarshalJSON decodes a /json response, keeping any keys that don't map to
+// a field in Extras so that fields added by newer firmware aren't lost.
+func (s *purpleAirStatus) UnmarshalJSON(data []byte) error {
+	// statusFields has the same fields but not this method, so decoding into
+	// it doesn't recurse
+	type statusFields purpleAirStatus
+	if err := json.Unmarshal(data, (*statusFields)(s)); err != nil {
+		return err
+	}
+
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	for key, raw := range all {
+		if knownStatusKeys[strings.ToLower(key)] {
+			continue
+		}
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return err
+		}
+		if s.Extras == nil {
+			s.Extras = map[string]interface{}{}
+		}
+		s.Extras[key] = value
+	}
+	return nil
+}
+
+// selectExtras returns the extra fields that policy allows publishing, with
+// objects and arrays encoded as JSON strings.
+func selectExtras(extras map[string]interface{}, policy string) map[string]interface{} {
+	selected := map[string]interface{}{}
+	if policy != extraFieldsNumeric && policy != extraFieldsAll {
+		return selected
+	}
+	for key, value := range extras {
+		switch v := value.(type) {
+		case float64:
+			selected[key] = v
+		case string, bool:
+			if policy == extraFieldsAll {
+				selected[key] = v
+			}
+		case nil:
+		default:
+			if policy == extraFieldsAll {
+				b, err := json.Marshal(v)
+				if err == nil {
+					selected[key] = string(b)
+				}
+			}
+		}
+	}
+	return selected
+}
+
+// sortedKeys returns the keys of m in order, so extras are published in a
+// stable order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
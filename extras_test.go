@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestStatusExtras(t *testing.T) {
+	var status purpleAirStatus
+	err := json.Unmarshal([]byte(`{
+		"SensorId": "84:f3:eb:00:00:01",
+		"MEM": 1000,
+		"current_temp_f": 71,
+		"current_temp_f_aux": 72,
+		"voc_index": 123.5,
+		"pm2.5_aqi_cf_1": 50,
+		"place": "outside",
+		"firmware_flavor": "beta",
+		"flags": {"led": true}
+	}`), &status)
+	if err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if status.SensorId != "84:f3:eb:00:00:01" || status.Memory != 1000 || status.Temperature != 71 {
+		t.Errorf("known fields weren't decoded: %+v", status)
+	}
+	expected := map[string]interface{}{
+		"current_temp_f_aux": 72.0,
+		"voc_index":          123.5,
+		"pm2.5_aqi_cf_1":     50.0,
+		"firmware_flavor":    "beta",
+		"flags":              map[string]interface{}{"led": true},
+	}
+	if !reflect.DeepEqual(status.Extras, expected) {
+		t.Errorf("Extras = %v, want %v", status.Extras, expected)
+	}
+}
+
+func TestSelectExtras(t *testing.T) {
+	extras := map[string]interface{}{
+		"voc_index":       123.5,
+		"firmware_flavor": "beta",
+		"flags":           map[string]interface{}{"led": true},
+		"unset":           nil,
+	}
+	tests := []struct {
+		policy   string
+		expected map[string]interface{}
+	}{
+		{"", map[string]interface{}{}},
+		{extraFieldsNone, map[string]interface{}{}},
+		{extraFieldsNumeric, map[string]interface{}{"voc_index": 123.5}},
+		{extraFieldsAll, map[string]interface{}{"voc_index": 123.5, "firmware_flavor": "beta", "flags": `{"led":true}`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			if got := selectExtras(extras, tt.policy); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("selectExtras() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMQTTSinkPublishExtras(t *testing.T) {
+	client := &fakeMQTTClient{}
+	s := newTestMQTTSink(t, tomlConfigMQTT{TopicPrefix: "airquality", ExtraFields: extraFieldsNumeric}, client)
+
+	status := &purpleAirStatus{Geo: "backyard", Extras: map[string]interface{}{"voc_index": 123.5, "odd/key#": 1.0, "firmware_flavor": "beta"}}
+	if err := s.Publish(context.Background(), status); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	if got := client.published["airquality/backyard/voc_index"]; got != "123.5" {
+		t.Errorf("voc_index = %q, want 123.5", got)
+	}
+	if got := client.published["airquality/backyard/odd_key_"]; got != "1" {
+		t.Errorf("odd_key_ = %q, want 1", got)
+	}
+	for _, topic := range []string{"airquality/backyard/firmware_flavor", "airquality/backyard/Extras"} {
+		if _, ok := client.published[topic]; ok {
+			t.Errorf("%s was published", topic)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// configFlags are the common settings that can be given on the command line,
+// either instead of a configuration file or to override it. They take
+// precedence over the environment and the file.
+type configFlags struct {
+	Url        string // replaces the configured sensors with this one
+	PollRate   int    // applies to every sensor
+	MqttBroker string // host or host:port, of the primary MQTT target
+	Topic      string // applies to every MQTT target
+	InfluxUrl  string // http://[user:password@]host[:port][/database]
+}
+
+func (f *configFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.Url, "url", "", "Poll the sensor at this URL, e.g. http://192.168.1.24/json, instead of those in the configuration file")
+	fs.IntVar(&f.PollRate, "poll-rate", 0, "Seconds between polls of each sensor")
+	f.registerOutputs(fs)
+}
+
+// registerOutputs registers only the flags for output settings, for commands
+// that don't poll sensors.
+func (f *configFlags) registerOutputs(fs *flag.FlagSet) {
+	fs.StringVar(&f.MqttBroker, "mqtt-broker", "", "Publish to the MQTT broker at host[:port]")
+	fs.StringVar(&f.Topic, "topic", "", "MQTT topic for readings, under the topic prefix (default: the sensor's Geo name)")
+	fs.StringVar(&f.InfluxUrl, "influx-url", "", "Write to InfluxDB at http://[user:password@]host[:port][/database]")
+}
+
+// applyFlagOverrides sets the configuration keys given on the command line.
+// Like environment overrides, they count as set for defaults and validation,
+// and problems with them name the flag they came from.
+func applyFlagOverrides(cfg *tomlConfig, flags configFlags, report *configReport) {
+	set := func(key, flagName string) {
+		report.lines[key] = 0
+		report.sources[key] = flagName
+	}
+
+	if flags.Url != "" {
+		sensor := tomlConfigPurpleAir{}
+		if len(cfg.PurpleAir) > 0 {
+			sensor = cfg.PurpleAir[0]
+		}
+		sensor.Url = flags.Url
+		if len(cfg.PurpleAir) > 1 {
+			// the first sensor's settings are now the only sensor's
+			for key, line := range report.lines {
+				if rest, ok := strings.CutPrefix(key, "purpleair[0]."); ok {
+					report.lines["purpleair."+rest] = line
+				}
+			}
+		}
+		cfg.PurpleAir = purpleAirSensors{sensor}
+		set("purpleair.url", "-url")
+	}
+	if flags.PollRate != 0 {
+		for i := range cfg.PurpleAir {
+			cfg.PurpleAir[i].PollRate = flags.PollRate
+			set(sensorKey(*cfg, i, "poll_rate"), "-poll-rate")
+		}
+	}
+
+	if flags.MqttBroker != "" {
+		host, port, err := splitBroker(flags.MqttBroker)
+		if err != nil {
+			report.Errors = append(report.Errors, configProblem{Key: "mqtt.broker_host", Message: fmt.Sprintf("%s (set by -mqtt-broker)", err)})
+		} else {
+			// the broker replaces the primary target's
+			if len(cfg.Mqtt) == 0 {
+				cfg.Mqtt = mqttTargets{{}}
+			}
+			cfg.Mqtt[0].BrokerHost = host
+			set(mqttKey(*cfg, 0, "broker_host"), "-mqtt-broker")
+			if port != 0 {
+				cfg.Mqtt[0].BrokerPort = port
+				set(mqttKey(*cfg, 0, "broker_port"), "-mqtt-broker")
+			}
+		}
+	}
+	if flags.Topic != "" {
+		for i := range cfg.Mqtt {
+			cfg.Mqtt[i].Topic = flags.Topic
+			set(mqttKey(*cfg, i, "topic"), "-topic")
+		}
+	}
+
+	if flags.InfluxUrl != "" {
+		if err := applyInfluxUrl(&cfg.Influx, flags.InfluxUrl, set); err != nil {
+			report.Errors = append(report.Errors, configProblem{Key: "influx", Message: fmt.Sprintf("%s (set by -influx-url)", err)})
+		}
+	}
+}
+
+// splitBroker splits host[:port]; port is 0 if it isn't given.
+func splitBroker(broker string) (string, int, error) {
+	broker = strings.TrimPrefix(broker, "tcp://")
+	host, portStr, err := net.SplitHostPort(broker)
+	if err != nil {
+		// no port given
+		return broker, 0, nil
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, fmt.Errorf("%q is not a valid port", portStr)
+	}
+	return host, port, nil
+}
+
+func applyInfluxUrl(influx *tomlConfigInflux, rawUrl string, set func(key, flagName string)) error {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.Scheme != "http" || u.Hostname() == "" {
+		return fmt.Errorf("%q is not an http://host[:port][/database] URL", rawUrl)
+	}
+	influx.Hostname = u.Hostname()
+	set("influx.hostname", "-influx-url")
+	if u.Port() != "" {
+		port, err := strconv.Atoi(u.Port())
+		if err != nil {
+			return fmt.Errorf("%q is not a valid port", u.Port())
+		}
+		influx.Port = port
+		set("influx.port", "-influx-url")
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		influx.Database = db
+		set("influx.database", "-influx-url")
+	}
+	if u.User != nil {
+		influx.Username = u.User.Username()
+		set("influx.username", "-influx-url")
+		if password, ok := u.User.Password(); ok {
+			influx.Password = password
+			set("influx.password", "-influx-url")
+		}
+	}
+	return nil
+}
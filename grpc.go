@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+
+	pb "github.com/cdzombak/purpleair2mqtt/proto/purpleair2mqtt/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type tomlConfigGRPC struct {
+	Listen       string // Address to serve gRPC on, e.g. ":50051"
+	Token        string // Bearer token clients must send; anyone may connect if empty
+	TlsCert      string // PEM certificate file to serve TLS with; plaintext if empty
+	TlsKey       string // PEM private key file of tls_cert
+	StreamBuffer int    // Readings queued per stream before a slow client misses the oldest (default: 16)
+}
+
+// grpcConfigured reports whether the [grpc] section is in use.
+func grpcConfigured(cfg tomlConfigGRPC) bool {
+	return !reflect.DeepEqual(cfg, tomlConfigGRPC{})
+}
+
+// grpcServer serves readings over gRPC: the latest of each sensor, and a
+// stream of them as they're taken.
+type grpcServer struct {
+	pb.UnimplementedReadingsServer
+
+	cfg tomlConfigGRPC
+	now func() time.Time
+
+	mu      sync.Mutex
+	latest  map[string]*grpcReading // by MAC address, or name if the sensor has none
+	streams map[*grpcStream]bool
+	closed  bool
+
+	server   *grpc.Server
+	listener net.Listener
+	done     chan struct{}
+}
+
+// grpcStream is a client's StreamReadings call.
+type grpcStream struct {
+	filter   *pb.ReadingFilter
+	readings chan *grpcReading
+	closed   chan struct{} // closed when the server shuts down
+}
+
+func newGRPCServer(cfg tomlConfigGRPC) (*grpcServer, error) {
+	if cfg.StreamBuffer <= 0 {
+		cfg.StreamBuffer = 16
+	}
+	s := &grpcServer{
+		cfg:     cfg,
+		now:     time.Now,
+		latest:  map[string]*grpcReading{},
+		streams: map[*grpcStream]bool{},
+		done:    make(chan struct{}),
+	}
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := s.authorize(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := s.authorize(stream.Context()); err != nil {
+				return err
+			}
+			return handler(srv, stream)
+		}),
+	}
+	if cfg.TlsCert != "" {
+		creds, err := credentials.NewServerTLSFromFile(cfg.TlsCert, cfg.TlsKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading gRPC TLS certificate: %w", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	s.server = grpc.NewServer(opts...)
+	pb.RegisterReadingsServer(s.server, s)
+
+	ln, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		s.server.Stop()
+		return nil, fmt.Errorf("starting gRPC server: %w", err)
+	}
+	s.listener = ln
+	logger.Infof("Serving gRPC on %s", ln.Addr())
+	go func() {
+		defer close(s.done)
+		if err := s.server.Serve(ln); err != nil {
+			logger.Errorf("gRPC server stopped: %s", err)
+		}
+	}()
+	return s, nil
+}
+
+func (s *grpcServer) Name() string {
+	return "gRPC"
+}
+
+// Publish keeps the reading as its sensor's latest and sends it to every
+// stream it matches. A stream whose client has fallen behind drops its
+// oldest queued reading to make room.
+func (s *grpcServer) Publish(ctx context.Context, pastatus *purpleAirStatus) error {
+	reading := newGRPCReading(pastatus, s.now())
+	key := reading.name
+	if reading.sensorId != "" {
+		key = reading.sensorId
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latest[key] = reading
+	for stream := range s.streams {
+		if !reading.matches(stream.filter) {
+			continue
+		}
+		select {
+		case stream.readings <- reading:
+			continue
+		default:
+		}
+		select {
+		case <-stream.readings:
+		default:
+		}
+		select {
+		case stream.readings <- reading:
+		default:
+		}
+	}
+	return nil
+}
+
+// Close ends every stream and stops the server, cutting off calls still in
+// flight when ctx is done.
+func (s *grpcServer) Close(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		for stream := range s.streams {
+			close(stream.closed)
+		}
+	}
+	s.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.server.Stop()
+		return ctx.Err()
+	}
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// authorize checks the bearer token a call carries, if the server has one.
+func (s *grpcServer) authorize(ctx context.Context) error {
+	if s.cfg.Token == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		if subtle.ConstantTimeCompare([]byte(value), []byte("Bearer "+s.cfg.Token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or wrong bearer token")
+}
+
+func (s *grpcServer) GetLatest(ctx context.Context, req *pb.GetLatestRequest) (*pb.Reading, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.SensorId == "" {
+		switch len(s.latest) {
+		case 0:
+			return nil, status.Error(codes.NotFound, "no sensor has been read yet")
+		case 1:
+			for _, reading := range s.latest {
+				return reading.message, nil
+			}
+		}
+		return nil, status.Error(codes.InvalidArgument, "sensor_id is required when the bridge reads several sensors")
+	}
+	for _, reading := range s.latest {
+		if reading.is(req.SensorId) {
+			return reading.message, nil
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "sensor %q hasn't been read", req.SensorId)
+}
+
+func (s *grpcServer) StreamReadings(filter *pb.ReadingFilter, stream grpc.ServerStreamingServer[pb.Reading]) error {
+	sub := &grpcStream{
+		filter:   filter,
+		readings: make(chan *grpcReading, s.cfg.StreamBuffer),
+		closed:   make(chan struct{}),
+	}
+	var initial []*grpcReading
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return status.Error(codes.Unavailable, "shutting down")
+	}
+	s.streams[sub] = true
+	if filter.IncludeLatest {
+		for _, key := range sortedKeys(s.latest) {
+			if reading := s.latest[key]; reading.matches(filter) {
+				initial = append(initial, reading)
+			}
+		}
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.streams, sub)
+		s.mu.Unlock()
+	}()
+
+	for _, reading := range initial {
+		if err := stream.Send(reading.message); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case reading := <-sub.readings:
+			if err := stream.Send(reading.message); err != nil {
+				return err
+			}
+		case <-sub.closed:
+			return nil
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+	}
+}
+
+// is reports whether id, a MAC address or name, names the reading's sensor.
+func (r *grpcReading) is(id string) bool {
+	if _, err := net.ParseMAC(id); err == nil {
+		return normalizeMAC(id) == r.sensorId
+	}
+	return id == r.name
+}
+
+// matches reports whether a stream with the filter gets the reading.
+func (r *grpcReading) matches(f *pb.ReadingFilter) bool {
+	if r.epaAQI < int(f.MinEpaAqi) {
+		return false
+	}
+	if len(f.SensorIds) == 0 {
+		return true
+	}
+	for _, id := range f.SensorIds {
+		if r.is(id) {
+			return true
+		}
+	}
+	return false
+}
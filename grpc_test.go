@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/cdzombak/purpleair2mqtt/proto/purpleair2mqtt/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestReadingMessage(t *testing.T) {
+	gas := float32(0)
+	pastatus := &purpleAirStatus{SensorId: "84:F3:EB:7B:C8:EE", Geo: "Back yard", Version: "7.02",
+		Temperature: -4, Pressure: 1006.5, Gas680: &gas, PM25Cf1: 40.5, PM25Atm: 38,
+		EPAAQI: 113, EPAPM25AQI: 113, EPAPM10AQI: 20, EPAAQICategory: "Unhealthy for Sensitive Groups"}
+	pastatus.A.PM25Cf1 = 40.5
+	pastatus.A.EPAAQICategory = "Unhealthy for Sensitive Groups"
+	pastatus.A.PM25Aqi = 110
+
+	// as a client decodes it
+	decode := func(m *pb.Reading) *pb.Reading {
+		t.Helper()
+		b, err := proto.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reading := &pb.Reading{}
+		if err := proto.Unmarshal(b, reading); err != nil {
+			t.Fatal(err)
+		}
+		return reading
+	}
+	reading := decode(readingMessage(pastatus, time.Unix(1760000000, 5)))
+
+	if reading.GetSensorId() != "84:f3:eb:7b:c8:ee" {
+		t.Errorf("sensor_id = %q", reading.GetSensorId())
+	}
+	if got := reading.GetDevice().GetFirmwareVersion(); got != "7.02" {
+		t.Errorf("device.firmware_version = %q", got)
+	}
+	if got := reading.GetReceivedAt().AsTime(); !got.Equal(time.Unix(1760000000, 5)) {
+		t.Errorf("received_at = %v", got)
+	}
+	if got := reading.GetEnvironment().GetTemperatureF(); got != -4 {
+		t.Errorf("environment.temperature_f = %d", got)
+	}
+	if got := reading.GetEnvironment().GetPressureHpa(); got != 1006.5 {
+		t.Errorf("environment.pressure_hpa = %v", got)
+	}
+	if reading.GetEnvironment().Gas_680Kohm == nil {
+		t.Error("environment.gas_680_kohm is unset, though the sensor reported 0")
+	}
+	if reading.GetEnvironment().Temperature_680F != nil {
+		t.Error("environment.temperature_680_f is set, though the sensor has no BME68x")
+	}
+	if got := reading.GetParticulates().GetPm2_5Atm(); got != 38 {
+		t.Errorf("particulates.pm2_5_atm = %v", got)
+	}
+	if got := reading.GetParticulates().GetPm2_5Cf1(); got != 40.5 {
+		t.Errorf("particulates.pm2_5_cf1 = %v", got)
+	}
+	if got := reading.GetEpaAqi().GetAqi(); got != 113 {
+		t.Errorf("epa_aqi.aqi = %d", got)
+	}
+	if got := reading.GetEpaAqi().GetCategory(); got != pb.AQICategory_AQI_CATEGORY_UNHEALTHY_FOR_SENSITIVE_GROUPS {
+		t.Errorf("epa_aqi.category = %v", got)
+	}
+	if got := reading.GetChannelA().GetEpaAqi().GetCategory(); got != pb.AQICategory_AQI_CATEGORY_UNHEALTHY_FOR_SENSITIVE_GROUPS {
+		t.Errorf("channel_a.epa_aqi.category = %v", got)
+	}
+	if got := reading.GetChannelA().GetPurpleairPm2_5Aqi(); got != 110 {
+		t.Errorf("channel_a.purpleair_pm2_5_aqi = %d", got)
+	}
+	if reading.ChannelB != nil {
+		t.Error("channel_b is set, though channel B reports nothing")
+	}
+
+	pastatus.B.PM25Cf1 = 41
+	reading = decode(readingMessage(pastatus, time.Unix(1760000000, 0)))
+	if got := reading.GetChannelB().GetParticulates().GetPm2_5Cf1(); got != 41 {
+		t.Errorf("channel_b.particulates.pm2_5_cf1 = %v", got)
+	}
+}
+
+func TestGRPCReadingFilter(t *testing.T) {
+	filter := &pb.ReadingFilter{SensorIds: []string{"Back yard", "84:F3:EB:00:00:01"}, MinEpaAqi: 51}
+	tests := []struct {
+		name     string
+		reading  grpcReading
+		expected bool
+	}{
+		{"By name", grpcReading{sensorId: "84:f3:eb:00:00:02", name: "Back yard", epaAQI: 60}, true},
+		{"By MAC address", grpcReading{sensorId: "84:f3:eb:00:00:01", name: "Front", epaAQI: 60}, true},
+		{"Other sensor", grpcReading{sensorId: "84:f3:eb:00:00:03", name: "Garage", epaAQI: 60}, false},
+		{"Below minimum AQI", grpcReading{sensorId: "84:f3:eb:00:00:01", name: "Front", epaAQI: 50}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.reading.matches(filter); got != tt.expected {
+				t.Errorf("matches = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGRPCServer(t *testing.T) {
+	s, err := newGRPCServer(tomlConfigGRPC{Listen: "127.0.0.1:0", Token: "hunter22"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close(context.Background())
+	addr := s.listener.Addr().String()
+
+	conn, err := grpc.NewClient("passthrough:///"+addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewReadingsClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	getLatest := func(ctx context.Context, id string) (*pb.Reading, error) {
+		return client.GetLatest(ctx, &pb.GetLatestRequest{SensorId: id})
+	}
+	if _, err := getLatest(ctx, "Back yard"); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("without a token: %v", err)
+	}
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer hunter22")
+	if _, err := getLatest(ctx, ""); status.Code(err) != codes.NotFound {
+		t.Fatalf("before any reading: %v", err)
+	}
+
+	s.Publish(ctx, &purpleAirStatus{SensorId: "84:F3:EB:00:00:01", Geo: "Back yard", EPAAQI: 40})
+	for _, id := range []string{"", "Back yard", "84-f3-eb-00-00-01"} {
+		reading, err := getLatest(ctx, id)
+		if err != nil {
+			t.Fatalf("GetLatest(%q): %v", id, err)
+		}
+		if reading.GetSensorId() != "84:f3:eb:00:00:01" || reading.GetName() != "Back yard" || reading.GetEpaAqi().GetAqi() != 40 {
+			t.Errorf("GetLatest(%q) = %+v", id, reading)
+		}
+	}
+	s.Publish(ctx, &purpleAirStatus{SensorId: "84:F3:EB:00:00:02", Geo: "Front", EPAAQI: 20})
+	if _, err := getLatest(ctx, ""); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("without sensor_id for several sensors: %v", err)
+	}
+	if _, err := getLatest(ctx, "Garage"); status.Code(err) != codes.NotFound {
+		t.Errorf("unknown sensor: %v", err)
+	}
+
+	stream, err := client.StreamReadings(ctx, &pb.ReadingFilter{SensorIds: []string{"Back yard"}, MinEpaAqi: 30, IncludeLatest: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recv := func() *pb.Reading {
+		t.Helper()
+		reading, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reading
+	}
+	if reading := recv(); reading.GetEpaAqi().GetAqi() != 40 {
+		t.Errorf("latest reading has AQI %d, want 40", reading.GetEpaAqi().GetAqi())
+	}
+
+	// Wait for the stream to be registered before publishing to it.
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		s.mu.Lock()
+		n := len(s.streams)
+		s.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stream wasn't registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.Publish(ctx, &purpleAirStatus{SensorId: "84:F3:EB:00:00:02", Geo: "Front", EPAAQI: 90})
+	s.Publish(ctx, &purpleAirStatus{SensorId: "84:F3:EB:00:00:01", Geo: "Back yard", EPAAQI: 25})
+	s.Publish(ctx, &purpleAirStatus{SensorId: "84:F3:EB:00:00:01", Geo: "Back yard", EPAAQI: 75})
+	if reading := recv(); reading.GetName() != "Back yard" || reading.GetEpaAqi().GetAqi() != 75 {
+		t.Errorf("streamed %+v, want Back yard's reading with AQI 75", reading)
+	}
+
+	closeCtx, cancelClose := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelClose()
+	if err := s.Close(closeCtx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); !errors.Is(err, io.EOF) {
+		t.Errorf("after Close, stream ended with %v, want EOF", err)
+	}
+}
+
+func TestGRPCServerListenFails(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if _, err := newGRPCServer(tomlConfigGRPC{Listen: ln.Addr().String()}); err == nil {
+		t.Fatal("newGRPCServer() on a port in use: error = nil")
+	}
+}
+
+func TestGRPCStreamDropsOldest(t *testing.T) {
+	s := &grpcServer{latest: map[string]*grpcReading{}, streams: map[*grpcStream]bool{}, now: time.Now}
+	stream := &grpcStream{filter: &pb.ReadingFilter{}, readings: make(chan *grpcReading, 2)}
+	s.streams[stream] = true
+	for aqi := 1; aqi <= 3; aqi++ {
+		s.Publish(context.Background(), &purpleAirStatus{Geo: "Back yard", EPAAQI: aqi})
+	}
+	for _, want := range []int{2, 3} {
+		if got := (<-stream.readings).epaAQI; got != want {
+			t.Errorf("queued AQI %d, want %d", got, want)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"time"
+
+	pb "github.com/cdzombak/purpleair2mqtt/proto/purpleair2mqtt/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcReading is a reading as the gRPC API serves it, along with what the
+// server filters streams by. The message is shared by every client it's sent
+// to, so it mustn't be changed once built.
+type grpcReading struct {
+	sensorId string
+	name     string
+	epaAQI   int
+	message  *pb.Reading
+}
+
+func newGRPCReading(status *purpleAirStatus, receivedAt time.Time) *grpcReading {
+	message := readingMessage(status, receivedAt)
+	return &grpcReading{
+		sensorId: message.SensorId,
+		name:     message.Name,
+		epaAQI:   status.EPAAQI,
+		message:  message,
+	}
+}
+
+// readingMessage builds a purpleair2mqtt.v1.Reading from a reading.
+func readingMessage(status *purpleAirStatus, receivedAt time.Time) *pb.Reading {
+	reading := &pb.Reading{
+		SensorId:   normalizeMAC(status.SensorId),
+		Name:       status.Geo,
+		ReceivedAt: timestamppb.New(receivedAt),
+		Device: &pb.Device{
+			DateTime:           status.DateTime,
+			FirmwareVersion:    status.Version,
+			HardwareRevision:   status.HardwareRevision,
+			HardwareDiscovered: status.HardwareDiscovered,
+			UptimeSeconds:      int64(status.Uptime),
+			Rssi:               int32(status.RSSI),
+			Latitude:           status.Latitude,
+			Longitude:          status.Longitude,
+			Place:              status.Place,
+		},
+		Environment: &pb.Environment{
+			TemperatureF:     int32(status.Temperature),
+			Humidity:         int32(status.Humidity),
+			DewpointF:        int32(status.Dewpoint),
+			PressureHpa:      status.Pressure,
+			Temperature_680F: status.Temperature680,
+			Humidity_680:     status.Humidity680,
+			Dewpoint_680F:    status.Dewpoint680,
+			Pressure_680Hpa:  status.Pressure680,
+			Gas_680Kohm:      status.Gas680,
+		},
+		Particulates: &pb.Particulates{
+			Pm1_0Cf1:  status.PM10Cf1,
+			Pm2_5Cf1:  status.PM25Cf1,
+			Pm10_0Cf1: status.PM100Cf1,
+			Pm1_0Atm:  status.PM10Atm,
+			Pm2_5Atm:  status.PM25Atm,
+			Pm10_0Atm: status.PM100Atm,
+			P_0_3Um:   status.P03um,
+			P_0_5Um:   status.P05um,
+			P_1_0Um:   status.P10um,
+			P_2_5Um:   status.P25um,
+			P_5_0Um:   status.P50um,
+			P_10_0Um:  status.P100um,
+		},
+		EpaAqi: aqiMessage(status.EPAAQI, status.EPAPM25AQI, status.EPAPM10AQI,
+			status.EPAAQICategory, status.EPAAQIColor, status.EPAAQIColorRGB),
+		ChannelA: monitorMessage(status.A),
+	}
+	if channelReports(status.B) {
+		reading.ChannelB = monitorMessage(status.B)
+	}
+	return reading
+}
+
+// channelReports reports whether a laser counter reported anything at all;
+// channel B of a sensor with a single counter doesn't.
+func channelReports(m purpleAirMonitor) bool {
+	for _, v := range []float32{
+		m.PM10Cf1, m.PM25Cf1, m.PM100Cf1,
+		m.PM10Atm, m.PM25Atm, m.PM100Atm,
+		m.P03um, m.P05um, m.P10um, m.P25um, m.P50um, m.P100um,
+	} {
+		if v != 0 {
+			return true
+		}
+	}
+	return m.PM25AqiColor != ""
+}
+
+func monitorMessage(m purpleAirMonitor) *pb.Monitor {
+	return &pb.Monitor{
+		Particulates: &pb.Particulates{
+			Pm1_0Cf1:  m.PM10Cf1,
+			Pm2_5Cf1:  m.PM25Cf1,
+			Pm10_0Cf1: m.PM100Cf1,
+			Pm1_0Atm:  m.PM10Atm,
+			Pm2_5Atm:  m.PM25Atm,
+			Pm10_0Atm: m.PM100Atm,
+			P_0_3Um:   m.P03um,
+			P_0_5Um:   m.P05um,
+			P_1_0Um:   m.P10um,
+			P_2_5Um:   m.P25um,
+			P_5_0Um:   m.P50um,
+			P_10_0Um:  m.P100um,
+		},
+		EpaAqi: aqiMessage(m.EPAAQI, m.EPAPM25AQI, m.EPAPM10AQI,
+			m.EPAAQICategory, m.EPAAQIColor, m.EPAAQIColorRGB),
+		PurpleairPm2_5Aqi:      int32(m.PM25Aqi),
+		PurpleairPm2_5AqiColor: m.PM25AqiColor,
+	}
+}
+
+func aqiMessage(aqi, pm25, pm10 int, category, color, colorRGB string) *pb.AQI {
+	m := &pb.AQI{
+		Aqi:          int32(aqi),
+		Pm2_5Aqi:     int32(pm25),
+		Pm10Aqi:      int32(pm10),
+		CategoryName: category,
+		Color:        color,
+		ColorRgb:     colorRGB,
+	}
+	for i, c := range aqiCategories {
+		if c.Category == category {
+			// AQICategory numbers the categories from 1, after UNSPECIFIED.
+			m.Category = pb.AQICategory(i + 1)
+		}
+	}
+	return m
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// hassEntity is a reading field announced to Home Assistant as a sensor.
+type hassEntity struct {
+	key         string // identifies the entity within the device
+	name        string
+	field       string // purpleAirStatus field, published at <base topic>/<field>
+	deviceClass string
+	unit        string
+	icon        string
+}
+
+// hassEntities are announced for every device, except those whose field is
+// optional and missing from the reading, such as the Flex's BME68x values.
+var hassEntities = []hassEntity{
+	{key: "epa_aqi", name: "AQI", field: "EPAAQI", deviceClass: "aqi"},
+	{key: "epa_aqi_category", name: "AQI category", field: "EPAAQICategory", icon: "mdi:air-filter"},
+	{key: "pm1_0", name: "PM1.0", field: "PM10Atm", deviceClass: "pm1", unit: "µg/m³"},
+	{key: "pm2_5", name: "PM2.5", field: "PM25Atm", deviceClass: "pm25", unit: "µg/m³"},
+	{key: "pm10_0", name: "PM10", field: "PM100Atm", deviceClass: "pm10", unit: "µg/m³"},
+	{key: "temperature", name: "Temperature", field: "Temperature", deviceClass: "temperature", unit: "°F"},
+	{key: "humidity", name: "Humidity", field: "Humidity", deviceClass: "humidity", unit: "%"},
+	{key: "dewpoint", name: "Dew point", field: "Dewpoint", deviceClass: "temperature", unit: "°F"},
+	{key: "pressure", name: "Pressure", field: "Pressure", deviceClass: "pressure", unit: "hPa"},
+	{key: "rssi", name: "Wi-Fi signal", field: "RSSI", deviceClass: "signal_strength", unit: "dBm"},
+	{key: "temperature_680", name: "BME68x temperature", field: "Temperature680", deviceClass: "temperature", unit: "°F"},
+	{key: "humidity_680", name: "BME68x humidity", field: "Humidity680", deviceClass: "humidity", unit: "%"},
+	{key: "dewpoint_680", name: "BME68x dew point", field: "Dewpoint680", deviceClass: "temperature", unit: "°F"},
+	{key: "pressure_680", name: "BME68x pressure", field: "Pressure680", deviceClass: "pressure", unit: "hPa"},
+	// Home Assistant has no device class for gas resistance
+	{key: "gas_680", name: "Gas resistance", field: "Gas680", unit: "kΩ", icon: "mdi:molecule"},
+}
+
+// hassSensorConfig is the discovery payload for an MQTT sensor.
+type hassSensorConfig struct {
+	Name              string     `json:"name"`
+	UniqueId          string     `json:"unique_id"`
+	ObjectId          string     `json:"object_id"`
+	StateTopic        string     `json:"state_topic"`
+	AvailabilityTopic string     `json:"availability_topic"`
+	DeviceClass       string     `json:"device_class,omitempty"`
+	StateClass        string     `json:"state_class,omitempty"`
+	Unit              string     `json:"unit_of_measurement,omitempty"`
+	Icon              string     `json:"icon,omitempty"`
+	Device            hassDevice `json:"device"`
+}
+
+type hassDevice struct {
+	Identifiers  []string   `json:"identifiers"`
+	Connections  [][]string `json:"connections,omitempty"`
+	Name         string     `json:"name"`
+	Model        string     `json:"model"`
+	Manufacturer string     `json:"manufacturer"`
+	SwVersion    string     `json:"sw_version,omitempty"`
+}
+
+// hassObjectId returns the configured object ID, or one derived from the
+// device's MAC address so that each sensor is a separate device.
+func hassObjectId(cfg tomlConfigHass, status *purpleAirStatus) string {
+	if cfg.ObjectId != "" {
+		return cfg.ObjectId
+	}
+	if status.SensorId != "" {
+		return strings.ToLower(strings.ReplaceAll(status.SensorId, ":", ""))
+	}
+	return sanitizeTopicLevel(status.Geo)
+}
+
+// hassDiscoveryConfigs returns the retained discovery payloads for a reading,
+// keyed by config topic. stateTopic returns the topic a field is published
+// to, or false if it isn't published.
+func hassDiscoveryConfigs(cfg tomlConfigHass, status *purpleAirStatus, availability string, stateTopic func(field string) (string, bool)) (map[string]string, error) {
+	prefix := cfg.DiscoveryPrefix
+	if prefix == "" {
+		prefix = "homeassistant"
+	}
+	objectId := hassObjectId(cfg, status)
+	device := hassDevice{
+		Identifiers:  []string{objectId},
+		Name:         cfg.DeviceName,
+		Model:        cfg.DeviceModel,
+		Manufacturer: cfg.Manufacturer,
+		SwVersion:    status.Version,
+	}
+	if status.SensorId != "" {
+		device.Connections = [][]string{{"mac", strings.ToLower(status.SensorId)}}
+	}
+	if device.Name == "" {
+		device.Name = status.Geo
+	}
+	if device.Model == "" {
+		device.Model = "PurpleAir"
+	}
+	if device.Manufacturer == "" {
+		device.Manufacturer = "PurpleAir"
+	}
+
+	v := reflect.ValueOf(*status)
+	configs := map[string]string{}
+	for _, e := range hassEntities {
+		if _, ok := statusFieldValue(v.FieldByName(e.field)); !ok {
+			continue
+		}
+		state, ok := stateTopic(e.field)
+		if !ok {
+			continue
+		}
+		c := hassSensorConfig{
+			Name:              e.name,
+			UniqueId:          objectId + "_" + e.key,
+			ObjectId:          objectId + "_" + e.key,
+			StateTopic:        state,
+			AvailabilityTopic: availability,
+			DeviceClass:       e.deviceClass,
+			Unit:              e.unit,
+			Icon:              e.icon,
+			Device:            device,
+		}
+		if e.deviceClass != "" || e.unit != "" {
+			c.StateClass = "measurement"
+		}
+		b, err := json.Marshal(c)
+		if err != nil {
+			return nil, err
+		}
+		configs[fmt.Sprintf("%s/sensor/%s/%s/config", prefix, objectId, e.key)] = string(b)
+	}
+	return configs, nil
+}
+
+// announce publishes the discovery config of any entity not yet announced,
+// so Home Assistant picks up each device on its first reading and the Flex
+// entities once they're present.
+func (s *mqttSink) announce(ctx context.Context, status *purpleAirStatus) error {
+	stateTopic := func(field string) (string, bool) { return s.topic(status, "", field) }
+	configs, err := hassDiscoveryConfigs(s.hass, status, availabilityTopic(s.cfg, status), stateTopic)
+	if err != nil {
+		return fmt.Errorf("building Home Assistant discovery config: %w", err)
+	}
+	if s.announced == nil {
+		s.announced = map[string]bool{}
+	}
+	for topic, payload := range configs {
+		if s.announced[topic] {
+			continue
+		}
+		if err := s.publishRetained(ctx, topic, payload); err != nil {
+			return err
+		}
+		s.announced[topic] = true
+	}
+	return nil
+}
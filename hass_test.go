@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flexFixture is a /json response from a PurpleAir Flex, which adds a BME688
+// alongside the BME280.
+const flexFixture = `{"SensorId": "84:f3:eb:00:00:02", "DateTime": "2024/01/01T12:00:00z", "Geo": "PurpleAir-5678",
+"version": "7.04", "hardwarediscovered": "3.0+OPENLOG+NO-DISK+RV3028+BME68X+BME280+PMSX003-A+PMSX003-B",
+"current_temp_f": 71, "current_humidity": 40, "current_dewpoint_f": 46, "pressure": 1006.5,
+"current_temp_f_680": 72.25, "current_humidity_680": 38.5, "current_dewpoint_f_680": 45.5, "pressure_680": 1006.75, "gas_680": 123.5,
+"pm2_5_atm": 12.0, "pm2_5_cf_1": 12.0, "pm10_0_atm": 20.0, "pm2.5_aqi": 50,
+"pm2_5_atm_b": 14.0, "pm2_5_cf_1_b": 14.0, "pm10_0_atm_b": 22.0, "pm2.5_aqi_b": 55}`
+
+func decodeFixture(t *testing.T, fixture string) *purpleAirStatus {
+	t.Helper()
+	status := new(purpleAirStatus)
+	if err := json.Unmarshal([]byte(fixture), status); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	normalizePaStatus(status)
+	calculateEPAAQI(status)
+	return status
+}
+
+func TestFlexReadings(t *testing.T) {
+	tests := []struct {
+		name              string
+		fixture           string
+		expectedMQTT      map[string]string // topic suffix to payload; "" means not published
+		expectedInfluxGas interface{}
+	}{
+		{"Flex", flexFixture, map[string]string{
+			"Temperature680": "72.25",
+			"Humidity680":    "38.5",
+			"Dewpoint680":    "45.5",
+			"Pressure680":    "1006.75",
+			"Gas680":         "123.5",
+		}, 123.5},
+		{"Without BME68x", readFixture, map[string]string{
+			"Temperature680": "",
+			"Gas680":         "",
+		}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := decodeFixture(t, tt.fixture)
+			if len(status.Extras) != 0 {
+				t.Errorf("Extras = %v, want none", status.Extras)
+			}
+
+			client := &fakeMQTTClient{}
+			s := newTestMQTTSink(t, tomlConfigMQTT{}, client)
+			if err := s.Publish(context.Background(), status); err != nil {
+				t.Fatalf("Publish() error = %v", err)
+			}
+			for field, payload := range tt.expectedMQTT {
+				got, ok := client.published["purpleair/"+status.Geo+"/"+field]
+				if payload == "" && ok {
+					t.Errorf("%s published as %q, want it left out", field, got)
+				} else if payload != "" && got != payload {
+					t.Errorf("%s = %q, want %q", field, got, payload)
+				}
+			}
+
+			p, err := status_to_point(status, "purpleair_status", nil, time.Unix(0, 0))
+			if err != nil {
+				t.Fatalf("status_to_point() error = %v", err)
+			}
+			fields, err := p.Fields()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fields["gas_680"] != tt.expectedInfluxGas {
+				t.Errorf("gas_680 = %v, want %v", fields["gas_680"], tt.expectedInfluxGas)
+			}
+		})
+	}
+}
+
+func TestHassDiscovery(t *testing.T) {
+	tests := []struct {
+		name            string
+		hass            tomlConfigHass
+		fixture         string
+		expectedConfigs map[string]string // config topic to the device class it should announce
+		missingConfigs  []string
+		expectedDevice  string
+	}{
+		{"Flex, default object ID", tomlConfigHass{Discovery: true}, flexFixture, map[string]string{
+			"homeassistant/sensor/84f3eb000002/epa_aqi/config":         "aqi",
+			"homeassistant/sensor/84f3eb000002/pm2_5/config":           "pm25",
+			"homeassistant/sensor/84f3eb000002/temperature/config":     "temperature",
+			"homeassistant/sensor/84f3eb000002/temperature_680/config": "temperature",
+			"homeassistant/sensor/84f3eb000002/humidity_680/config":    "humidity",
+			"homeassistant/sensor/84f3eb000002/pressure_680/config":    "pressure",
+			"homeassistant/sensor/84f3eb000002/gas_680/config":         "",
+		}, nil, "PurpleAir-5678"},
+		{"Without BME68x, configured names", tomlConfigHass{Discovery: true, DiscoveryPrefix: "ha", ObjectId: "pa-sd-ii", DeviceName: "Back yard"}, readFixture, map[string]string{
+			"ha/sensor/pa-sd-ii/pm10_0/config":   "pm10",
+			"ha/sensor/pa-sd-ii/humidity/config": "humidity",
+		}, []string{
+			"ha/sensor/pa-sd-ii/temperature_680/config",
+			"ha/sensor/pa-sd-ii/gas_680/config",
+		}, "Back yard"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := decodeFixture(t, tt.fixture)
+			client := &fakeMQTTClient{}
+			s := newTestMQTTSink(t, tomlConfigMQTT{TopicPrefix: "airquality"}, client)
+			s.hass = tt.hass
+			if err := s.Publish(context.Background(), status); err != nil {
+				t.Fatalf("Publish() error = %v", err)
+			}
+
+			for topic, deviceClass := range tt.expectedConfigs {
+				payload, ok := client.published[topic]
+				if !ok {
+					t.Errorf("no discovery config published to %s", topic)
+					continue
+				}
+				var c hassSensorConfig
+				if err := json.Unmarshal([]byte(payload), &c); err != nil {
+					t.Fatalf("%s: %v", topic, err)
+				}
+				if c.DeviceClass != deviceClass {
+					t.Errorf("%s device_class = %q, want %q", topic, c.DeviceClass, deviceClass)
+				}
+				if !strings.HasPrefix(c.StateTopic, "airquality/"+status.Geo+"/") {
+					t.Errorf("%s state_topic = %q", topic, c.StateTopic)
+				}
+				if _, ok := client.published[c.StateTopic]; !ok {
+					t.Errorf("%s state_topic %q was never published", topic, c.StateTopic)
+				}
+				if c.Device.Name != tt.expectedDevice {
+					t.Errorf("%s device name = %q, want %q", topic, c.Device.Name, tt.expectedDevice)
+				}
+			}
+			for _, topic := range tt.missingConfigs {
+				if _, ok := client.published[topic]; ok {
+					t.Errorf("discovery config published to %s, want it left out", topic)
+				}
+			}
+
+			// configs are announced once, not with every reading
+			client.published = nil
+			if err := s.Publish(context.Background(), status); err != nil {
+				t.Fatalf("Publish() error = %v", err)
+			}
+			for topic := range tt.expectedConfigs {
+				if _, ok := client.published[topic]; ok {
+					t.Errorf("discovery config re-published to %s", topic)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// homieRoot is the base topic of every Homie device.
+const homieRoot = "homie"
+
+// Homie device states; see https://homieiot.github.io/specification/
+const (
+	homieStateInit         = "init"
+	homieStateReady        = "ready"
+	homieStateDisconnected = "disconnected"
+	homieStateLost         = "lost" // our last will
+)
+
+// homieNode is a node of the Homie device a sensor is published as: its
+// overall status, or one of its two laser counters.
+type homieNode struct {
+	id         string
+	name       string
+	typ        string
+	properties []homieProperty
+}
+
+// homieProperty is a field of a reading, with its value formatted for its
+// Homie datatype.
+type homieProperty struct {
+	id       string
+	name     string
+	datatype string
+	unit     string
+	format   string
+	value    string
+}
+
+// homieNodeIds maps the channel a field belongs to onto its node.
+var homieNodeIds = map[string]string{"": "status", "sensor_A": "channel-a", "sensor_B": "channel-b"}
+
+// homieId turns a name into a Homie ID: lower-case words of letters and
+// digits joined by hyphens, so EPAAQI is epa-aqi and Back yard is back-yard.
+func homieId(name string) string {
+	var words []string
+	for _, w := range fieldWords(name) {
+		w = strings.Map(func(r rune) rune {
+			if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+				return r
+			}
+			return -1
+		}, strings.ToLower(w))
+		if w != "" {
+			words = append(words, w)
+		}
+	}
+	return strings.Join(words, "-")
+}
+
+// homieDeviceId returns the ID a sensor is published under: the target's
+// topic if set, otherwise the sensor's name or, failing that, its MAC address.
+func (s *mqttSink) homieDeviceId(status *purpleAirStatus) string {
+	for _, name := range []string{s.cfg.Topic, status.Geo, status.SensorId} {
+		if id := homieId(name); id != "" {
+			return id
+		}
+	}
+	return "purpleair"
+}
+
+// homieBase is the topic under which a device's attributes and values are
+// published; Homie 5 adds the major version to it.
+func (s *mqttSink) homieBase(id string) string {
+	if s.cfg.HomieVersion == 5 {
+		return homieRoot + "/5/" + id
+	}
+	return homieRoot + "/" + id
+}
+
+// homieNodes groups the fields the target publishes into the device's
+// nodes. Nodes left without properties by the target's fields are dropped.
+func (s *mqttSink) homieNodes(fields []mqttField) []homieNode {
+	nodes := []homieNode{
+		{id: "status", name: "Status", typ: "PurpleAir"},
+		{id: "channel-a", name: "Channel A", typ: "laser particle counter"},
+		{id: "channel-b", name: "Channel B", typ: "laser particle counter"},
+	}
+	seen := map[string]bool{}
+	for _, f := range fields {
+		channel := f.channel
+		if channel != "" {
+			channel = sanitizeTopicLevel(styleFieldName(channel, s.cfg.FieldStyle))
+		}
+		if !s.selected(channel, sanitizeTopicLevel(styleFieldName(f.name, s.cfg.FieldStyle))) {
+			continue
+		}
+		p := newHomieProperty(f)
+		key := homieNodeIds[f.channel] + "/" + p.id
+		if p.id == "" || seen[key] {
+			logger.Debugf("Homie: skipping %s, which has no usable ID of its own", f.name)
+			continue
+		}
+		seen[key] = true
+		for i := range nodes {
+			if nodes[i].id == homieNodeIds[f.channel] {
+				nodes[i].properties = append(nodes[i].properties, p)
+			}
+		}
+	}
+
+	var kept []homieNode
+	for _, n := range nodes {
+		if len(n.properties) > 0 {
+			kept = append(kept, n)
+		}
+	}
+	return kept
+}
+
+var particleCountField = regexp.MustCompile(`^p\d+um$`)
+
+// fieldUnit returns the unit of an overall reading field: those Home
+// Assistant is told, µg/m³ for concentrations and particles per deciliter
+// for counts. It's empty for unitless fields and those it doesn't know.
+func fieldUnit(field string) string {
+	for _, e := range hassEntities {
+		if e.field == field {
+			return e.unit
+		}
+	}
+	snake := styleFieldName(field, fieldStyleSnake)
+	switch {
+	case strings.HasPrefix(snake, "pm") && (strings.HasSuffix(snake, "_atm") || strings.HasSuffix(snake, "_cf1")):
+		return "µg/m³"
+	case particleCountField.MatchString(snake):
+		return "#/dl"
+	}
+	return ""
+}
+
+// newHomieProperty describes a field by its value's type. The AQI category
+// is an enum of the EPA's categories, and RGB colors are colors.
+func newHomieProperty(f mqttField) homieProperty {
+	snake := styleFieldName(f.name, fieldStyleSnake)
+	p := homieProperty{id: homieId(f.name), name: f.name, unit: fieldUnit(f.name)}
+	for _, e := range hassEntities {
+		if e.field == f.name {
+			p.name = e.name
+		}
+	}
+
+	v := reflect.ValueOf(f.value)
+	switch v.Kind() {
+	case reflect.Bool:
+		p.datatype, p.value = "boolean", strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		p.datatype, p.value = "integer", strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		p.datatype, p.value = "integer", strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32:
+		p.datatype, p.value = "float", strconv.FormatFloat(v.Float(), 'f', -1, 32)
+	case reflect.Float64:
+		p.datatype, p.value = "float", strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	default:
+		p.datatype, p.value = "string", fmt.Sprintf("%v", f.value)
+	}
+
+	if snake == "epa_aqi_category" {
+		var names []string
+		for _, c := range aqiCategories {
+			names = append(names, c.Category)
+		}
+		p.datatype, p.format = "enum", strings.Join(names, ",")
+	}
+	if strings.HasSuffix(snake, "color_rgb") || snake == "pm25_aqi_color" {
+		p.datatype, p.format = "color", "rgb"
+		var r, g, b int
+		if _, err := fmt.Sscanf(p.value, "rgb(%d,%d,%d)", &r, &g, &b); err == nil {
+			p.value = fmt.Sprintf("%d,%d,%d", r, g, b)
+		}
+	}
+	return p
+}
+
+// homieDescription returns a device's retained attributes, keyed by topic
+// relative to the device: Homie 4 gives each its own topic, and Homie 5 puts
+// them all in one JSON $description.
+func (s *mqttSink) homieDescription(name string, nodes []homieNode) (map[string]string, error) {
+	if s.cfg.HomieVersion == 5 {
+		b, err := homie5Description(name, nodes)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{"$description": string(b)}, nil
+	}
+
+	var nodeIds []string
+	attrs := map[string]string{"$homie": "4.0.0", "$name": name}
+	for _, n := range nodes {
+		nodeIds = append(nodeIds, n.id)
+		var propertyIds []string
+		attrs[n.id+"/$name"] = n.name
+		attrs[n.id+"/$type"] = n.typ
+		for _, p := range n.properties {
+			propertyIds = append(propertyIds, p.id)
+			attrs[n.id+"/"+p.id+"/$name"] = p.name
+			attrs[n.id+"/"+p.id+"/$datatype"] = p.datatype
+			if p.unit != "" {
+				attrs[n.id+"/"+p.id+"/$unit"] = p.unit
+			}
+			if p.format != "" {
+				attrs[n.id+"/"+p.id+"/$format"] = p.format
+			}
+		}
+		attrs[n.id+"/$properties"] = strings.Join(propertyIds, ",")
+	}
+	attrs["$nodes"] = strings.Join(nodeIds, ",")
+	return attrs, nil
+}
+
+type homie5Device struct {
+	Homie   string                `json:"homie"`
+	Version int64                 `json:"version"`
+	Name    string                `json:"name"`
+	Nodes   map[string]homie5Node `json:"nodes"`
+}
+
+type homie5Node struct {
+	Name       string                    `json:"name"`
+	Type       string                    `json:"type"`
+	Properties map[string]homie5Property `json:"properties"`
+}
+
+type homie5Property struct {
+	Name     string `json:"name"`
+	Datatype string `json:"datatype"`
+	Format   string `json:"format,omitempty"`
+	Unit     string `json:"unit,omitempty"`
+}
+
+// homie5Description encodes a Homie 5 $description. Its version, which has
+// to change whenever the description does, is a hash of the rest of it.
+func homie5Description(name string, nodes []homieNode) ([]byte, error) {
+	d := homie5Device{Homie: "5.0", Name: name, Nodes: map[string]homie5Node{}}
+	for _, n := range nodes {
+		node := homie5Node{Name: n.name, Type: n.typ, Properties: map[string]homie5Property{}}
+		for _, p := range n.properties {
+			node.Properties[p.id] = homie5Property{Name: p.name, Datatype: p.datatype, Format: p.format, Unit: p.unit}
+		}
+		d.Nodes[n.id] = node
+	}
+	b, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	h := fnv.New32a()
+	h.Write(b)
+	d.Version = int64(h.Sum32())
+	return json.Marshal(d)
+}
+
+// publishHomie publishes a reading as a Homie device. The device is
+// described when it's first seen and again whenever its properties change,
+// such as when a Flex's BME68x values appear: $state goes to init, the
+// attributes and values are published, and $state goes to ready. Later
+// readings only update the values. Everything is retained, as Homie
+// requires. After a reconnect every device is described again, since the
+// last will has marked one of them lost.
+func (s *mqttSink) publishHomie(ctx context.Context, status *purpleAirStatus, fields []mqttField) error {
+	if s.reconnected.Swap(false) {
+		s.homieDevices = nil
+	}
+	id := s.homieDeviceId(status)
+	base := s.homieBase(id)
+	nodes := s.homieNodes(fields)
+	name := status.Geo
+	if name == "" {
+		name = id
+	}
+	attrs, err := s.homieDescription(name, nodes)
+	if err != nil {
+		return fmt.Errorf("describing Homie device %s: %w", id, err)
+	}
+
+	topics := make([]string, 0, len(attrs))
+	for topic := range attrs {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	var description strings.Builder
+	for _, topic := range topics {
+		fmt.Fprintf(&description, "%s=%s\n", topic, attrs[topic])
+	}
+
+	described := s.homieDevices[id] == description.String()
+	if !described {
+		logger.Infof("Describing Homie device %s at %s", id, base)
+		if err := s.publishRetained(ctx, base+"/$state", homieStateInit); err != nil {
+			return err
+		}
+		for _, topic := range topics {
+			if err := s.publishRetained(ctx, base+"/"+topic, attrs[topic]); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, n := range nodes {
+		for _, p := range n.properties {
+			if err := s.publishRetained(ctx, base+"/"+n.id+"/"+p.id, p.value); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !described {
+		if err := s.publishRetained(ctx, base+"/$state", homieStateReady); err != nil {
+			return err
+		}
+		if s.homieDevices == nil {
+			s.homieDevices = map[string]string{}
+		}
+		s.homieDevices[id] = description.String()
+	}
+	return nil
+}
+
+// closeHomie marks every device described so far disconnected.
+func (s *mqttSink) closeHomie(ctx context.Context) error {
+	for _, id := range sortedKeys(s.homieDevices) {
+		if err := s.publishRetained(ctx, s.homieBase(id)+"/$state", homieStateDisconnected); err != nil {
+			return err
+		}
+	}
+	return nil
+}
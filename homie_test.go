@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHomieId(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"EPAAQI", "epa-aqi"},
+		{"PM25Atm", "pm25-atm"},
+		{"epa_aqi_color_rgb", "epa-aqi-color-rgb"},
+		{"Back yard #2", "back-yard-2"},
+		{"84:f3:eb:00:00:01", "84-f3-eb-00-00-01"},
+		{"Café", "caf"},
+		{"$$$", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := homieId(tt.name); got != tt.expected {
+				t.Errorf("homieId(%q) = %q, want %q", tt.name, got, tt.expected)
+			}
+		})
+	}
+}
+
+func homieTestStatus() *purpleAirStatus {
+	status := &purpleAirStatus{
+		SensorId:       "84:f3:eb:00:00:01",
+		Geo:            "Back yard",
+		EPAAQI:         42,
+		EPAAQICategory: "Good",
+		EPAAQIColorRGB: "rgb(0,228,0)",
+		PM25Atm:        12.5,
+	}
+	status.A.EPAAQI = 40
+	status.A.EPAAQICategory = "Good"
+	status.B.EPAAQI = 44
+	status.B.EPAAQICategory = "Good"
+	return status
+}
+
+func TestMQTTSinkHomie4(t *testing.T) {
+	client := &fakeMQTTClient{}
+	s := newTestMQTTSink(t, tomlConfigMQTT{Output: mqttOutputHomie}, client)
+
+	if err := s.Publish(context.Background(), homieTestStatus()); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	for topic, payload := range map[string]string{
+		"homie/back-yard/$homie":                             "4.0.0",
+		"homie/back-yard/$name":                              "Back yard",
+		"homie/back-yard/$state":                             "ready",
+		"homie/back-yard/$nodes":                             "status,channel-a,channel-b",
+		"homie/back-yard/status/$name":                       "Status",
+		"homie/back-yard/status/epa-aqi":                     "42",
+		"homie/back-yard/status/epa-aqi/$name":               "AQI",
+		"homie/back-yard/status/epa-aqi/$datatype":           "integer",
+		"homie/back-yard/status/epa-aqi-category":            "Good",
+		"homie/back-yard/status/epa-aqi-category/$datatype":  "enum",
+		"homie/back-yard/status/epa-aqi-category/$format":    "Good,Moderate,Unhealthy for Sensitive Groups,Unhealthy,Very Unhealthy,Hazardous",
+		"homie/back-yard/status/epa-aqi-color-rgb":           "0,228,0",
+		"homie/back-yard/status/epa-aqi-color-rgb/$datatype": "color",
+		"homie/back-yard/status/epa-aqi-color-rgb/$format":   "rgb",
+		"homie/back-yard/status/pm25-atm":                    "12.5",
+		"homie/back-yard/status/pm25-atm/$datatype":          "float",
+		"homie/back-yard/status/pm25-atm/$unit":              "µg/m³",
+		"homie/back-yard/channel-a/$name":                    "Channel A",
+		"homie/back-yard/channel-a/epa-aqi":                  "40",
+		"homie/back-yard/channel-b/epa-aqi":                  "44",
+	} {
+		if got := client.published[topic]; got != payload {
+			t.Errorf("topic %s = %q, want %q", topic, got, payload)
+		}
+	}
+	if _, ok := client.published["homie/back-yard/$extensions"]; ok {
+		t.Errorf("$extensions was published, though an empty retained message clears it")
+	}
+	if _, ok := client.published["purpleair/Back_yard/EPAAQI"]; ok {
+		t.Errorf("field topics were published alongside the Homie device")
+	}
+
+	// later readings only update values
+	client.published = nil
+	status := homieTestStatus()
+	status.EPAAQI = 43
+	if err := s.Publish(context.Background(), status); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if got := client.published["homie/back-yard/status/epa-aqi"]; got != "43" {
+		t.Errorf("epa-aqi = %q, want 43", got)
+	}
+	if _, ok := client.published["homie/back-yard/$state"]; ok {
+		t.Errorf("device was described again although its properties hadn't changed")
+	}
+
+	// a new property describes it again
+	client.published = nil
+	gas := float32(91.5)
+	status.Gas680 = &gas
+	if err := s.Publish(context.Background(), status); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if got := client.published["homie/back-yard/status/gas680/$unit"]; got != "kΩ" {
+		t.Errorf("gas680 unit = %q, want kΩ", got)
+	}
+	if got := client.published["homie/back-yard/$state"]; got != "ready" {
+		t.Errorf("$state = %q, want ready", got)
+	}
+
+	// after a reconnect, the device is ready again, though its last will
+	// marked it lost
+	client.published = nil
+	s.reconnected.Store(true)
+	if err := s.Publish(context.Background(), status); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if got := client.published["homie/back-yard/$state"]; got != "ready" {
+		t.Errorf("$state after a reconnect = %q, want ready", got)
+	}
+
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if got := client.published["homie/back-yard/$state"]; got != "disconnected" {
+		t.Errorf("$state after Close() = %q, want disconnected", got)
+	}
+	for topic := range client.published {
+		if strings.HasSuffix(topic, "/availability") {
+			t.Errorf("availability was published to %s for a Homie device", topic)
+		}
+	}
+}
+
+func TestMQTTSinkHomieWill(t *testing.T) {
+	broker := startFakeBroker(t, "127.0.0.1:0")
+	s, err := newMQTTSink(tomlConfigMQTT{
+		BrokerHost: "127.0.0.1",
+		BrokerPort: broker.ln.Addr().(*net.TCPAddr).Port,
+		ClientId:   "purpleair2mqtt-test",
+		Output:     mqttOutputHomie,
+	}, tomlConfigHass{})
+	if err != nil {
+		t.Fatalf("newMQTTSink() error = %v", err)
+	}
+	defer func() { _ = s.Close(context.Background()) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.waitConnected(ctx); err != nil {
+		t.Fatalf("didn't connect before the first reading: %v", err)
+	}
+	if err := s.Publish(ctx, homieTestStatus()); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	broker.mu.Lock()
+	will, willPayload := broker.will, broker.willPayload
+	broker.mu.Unlock()
+	if will != "homie/back-yard/$state" || willPayload != homieStateLost {
+		t.Errorf("will = %s %q, want homie/back-yard/$state %q", will, willPayload, homieStateLost)
+	}
+}
+
+func TestMQTTSinkHomie5(t *testing.T) {
+	client := &fakeMQTTClient{}
+	s := newTestMQTTSink(t, tomlConfigMQTT{
+		Output:       mqttOutputHomie,
+		HomieVersion: 5,
+		Topic:        "garden",
+		Fields:       []string{"epa_aqi", "sensor_a/*"},
+		FieldStyle:   fieldStyleSnake,
+	}, client)
+
+	if err := s.Publish(context.Background(), homieTestStatus()); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	var description homie5Device
+	if err := json.Unmarshal([]byte(client.published["homie/5/garden/$description"]), &description); err != nil {
+		t.Fatalf("$description isn't JSON: %v", err)
+	}
+	if description.Homie != "5.0" || description.Version == 0 || description.Name != "Back yard" {
+		t.Errorf("$description = %+v, want homie 5.0 with a version and name", description)
+	}
+	if _, ok := description.Nodes["channel-b"]; ok {
+		t.Errorf("channel-b was described, but isn't in fields")
+	}
+	if got := description.Nodes["status"].Properties["epa-aqi"].Datatype; got != "integer" {
+		t.Errorf("epa-aqi datatype = %q, want integer", got)
+	}
+	if _, ok := description.Nodes["status"].Properties["pm25-atm"]; ok {
+		t.Errorf("pm25-atm was described, but isn't in fields")
+	}
+	if got := description.Nodes["channel-a"].Properties["epa-aqi-category"].Format; got == "" {
+		t.Errorf("channel-a epa-aqi-category has no format")
+	}
+	for topic, payload := range map[string]string{
+		"homie/5/garden/$state":            "ready",
+		"homie/5/garden/status/epa-aqi":    "42",
+		"homie/5/garden/channel-a/epa-aqi": "40",
+	} {
+		if got := client.published[topic]; got != payload {
+			t.Errorf("topic %s = %q, want %q", topic, got, payload)
+		}
+	}
+}
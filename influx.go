@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	_ "github.com/influxdata/influxdb1-client" // this is important because of the bug in go mod
+	influxclient "github.com/influxdata/influxdb1-client/v2"
+)
+
+// influxSink writes each reading to InfluxDB v1 as one status point and one
+// monitor point per channel.
+type influxSink struct {
+	cfg    tomlConfigInflux
+	client influxclient.Client
+}
+
+func newInfluxSink(cfg tomlConfigInflux) (*influxSink, error) {
+	timeout := 15
+	if cfg.Timeout > 0 {
+		timeout = cfg.Timeout
+	}
+	httpConfig := influxclient.HTTPConfig{
+		Addr:    fmt.Sprintf("http://%s:%d", cfg.Hostname, cfg.Port),
+		Timeout: time.Duration(timeout) * time.Second,
+	}
+	if cfg.Username != "" && cfg.Password != "" {
+		httpConfig.Username = cfg.Username
+		httpConfig.Password = cfg.Password
+	}
+	c, err := influxclient.NewHTTPClient(httpConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error creating InfluxDB Client: %w", err)
+	}
+
+	if cfg.MeasurementName == "" {
+		cfg.MeasurementName = "purpleair_monitor"
+	}
+	if cfg.StatusMeasurementName == "" {
+		cfg.StatusMeasurementName = "purpleair_status"
+	}
+	return &influxSink{cfg: cfg, client: c}, nil
+}
+
+func (s *influxSink) Name() string {
+	return "InfluxDB"
+}
+
+func (s *influxSink) Publish(ctx context.Context, status *purpleAirStatus) error {
+	return s.WriteReadings(ctx, []timedReading{{Status: status, At: time.Now()}})
+}
+
+// timedReading is a reading along with when it was taken.
+type timedReading struct {
+	Status *purpleAirStatus
+	At     time.Time
+}
+
+// WriteReadings writes several readings in a single batch, each with its own
+// timestamp.
+func (s *influxSink) WriteReadings(ctx context.Context, readings []timedReading) error {
+	bp, err := influxclient.NewBatchPoints(influxclient.BatchPointsConfig{
+		Database:  s.cfg.Database,
+		Precision: "s",
+	})
+	if err != nil {
+		return fmt.Errorf("error creating batchpoints: %w", err)
+	}
+
+	for _, r := range readings {
+		points, err := readingPoints(r.Status, s.cfg.MeasurementName, s.cfg.StatusMeasurementName, s.cfg.ExtraFields, r.At)
+		if err != nil {
+			return err
+		}
+		bp.AddPoints(points)
+	}
+
+	// the v1 client doesn't take a context, so the write is bounded by the
+	// client's own timeout and we stop waiting on it when ctx is done
+	result := make(chan error, 1)
+	go func() { result <- s.client.Write(bp) }()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("writing to InfluxDB: %w", ctx.Err())
+	}
+}
+
+func (s *influxSink) Close(ctx context.Context) error {
+	return s.client.Close()
+}
+
+// readingPoints translates a reading into one monitor point per channel and
+// a status point, all timestamped t. The extra fields extraFields allows are
+// added to the status point.
+func readingPoints(status *purpleAirStatus, measurementName, statusMeasurementName, extraFields string, t time.Time) ([]*influxclient.Point, error) {
+	pointA, err := monitor_to_point(&status.A, measurementName, t)
+	if err != nil {
+		return nil, fmt.Errorf("error translating monitor sample to point: %w", err)
+	}
+	pointB, err := monitor_to_point(&status.B, measurementName, t)
+	if err != nil {
+		return nil, fmt.Errorf("error translating monitor sample to point: %w", err)
+	}
+	pointS, err := status_to_point(status, statusMeasurementName, selectExtras(status.Extras, extraFields), t)
+	if err != nil {
+		return nil, fmt.Errorf("error translating status to point: %w", err)
+	}
+	return []*influxclient.Point{pointA, pointB, pointS}, nil
+}
+
+func status_to_point(status *purpleAirStatus, measurementName string, extras map[string]interface{}, t time.Time) (*influxclient.Point, error) {
+	tags := map[string]string{"sensorId": status.SensorId}
+	values := map[string]interface{}{}
+
+	// the fields below take precedence over any extra with the same name
+	for key, value := range extras {
+		values[key] = value
+	}
+
+	values["temperature"] = status.Temperature
+	values["humidity"] = status.Humidity
+	values["pressure"] = status.Pressure
+	values["dewpoint"] = status.Dewpoint
+	values["rssi"] = status.RSSI
+
+	// BME68x readings, on the devices that have one
+	for key, value := range map[string]*float32{
+		"temperature_680": status.Temperature680,
+		"humidity_680":    status.Humidity680,
+		"dewpoint_680":    status.Dewpoint680,
+		"pressure_680":    status.Pressure680,
+		"gas_680":         status.Gas680,
+	} {
+		if value != nil {
+			values[key] = *value
+		}
+	}
+
+	// Add US EPA AQI values
+	values["epa_aqi"] = status.EPAAQI
+	values["epa_pm25_aqi"] = status.EPAPM25AQI
+	values["epa_pm10_aqi"] = status.EPAPM10AQI
+	values["epa_aqi_category"] = status.EPAAQICategory
+	values["epa_aqi_color"] = status.EPAAQIColor
+	values["epa_aqi_color_rgb"] = status.EPAAQIColorRGB
+
+	return influxclient.NewPoint(measurementName, tags, values, t)
+}
+
+func monitor_to_point(monitor *purpleAirMonitor, measurementName string, t time.Time) (*influxclient.Point, error) {
+	tags := map[string]string{"sensorId": monitor.SensorId, "sensor": monitor.Sensor}
+	values := map[string]interface{}{}
+	values["pm2.5_aqic"] = monitor.PM25AqiColor
+	values["pm2.5_aqi"] = monitor.PM25Aqi
+	values["pm1.0_cf_1"] = monitor.PM10Cf1
+	values["pm0.3_um"] = monitor.P03um
+	values["pm2.5_cf_1"] = monitor.PM25Cf1
+	values["pm0.5_um"] = monitor.P05um
+	values["pm10.0_cf_1"] = monitor.PM100Cf1
+	values["pm1.0_um"] = monitor.P10um
+	values["pm1.0_atm"] = monitor.PM10Atm
+	values["pm2.5_um"] = monitor.P25um
+	values["pm2.5_atm"] = monitor.PM25Atm
+	values["pm5.0_um"] = monitor.P50um
+	values["pm10.0_atm"] = monitor.PM100Atm
+	values["pm10.0_um"] = monitor.P100um
+	values["key1_response"] = monitor.Key1Response
+	values["key1_response_date"] = monitor.Key1ResponseDate
+	values["key1_count"] = monitor.Key1Count
+	values["ts_latency"] = monitor.TsLatency
+	values["key2_response"] = monitor.Key2Response
+	values["key2_response_date"] = monitor.Key2ResponseDate
+	values["key2_count"] = monitor.Key2Count
+	values["ts_s_latency"] = monitor.TsSLatency
+	values["epa_aqi"] = monitor.EPAAQI
+	values["epa_pm25_aqi"] = monitor.EPAPM25AQI
+	values["epa_pm10_aqi"] = monitor.EPAPM10AQI
+	values["epa_aqi_category"] = monitor.EPAAQICategory
+	values["epa_aqi_color"] = monitor.EPAAQIColor
+	values["epa_aqi_color_rgb"] = monitor.EPAAQIColorRGB
+
+	return influxclient.NewPoint(measurementName, tags, values, t)
+}
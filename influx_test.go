@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInfluxSinkPublish(t *testing.T) {
+	var gotDB, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotDB = r.URL.Query().Get("db")
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+	s, err := newInfluxSink(tomlConfigInflux{Hostname: u.Hostname(), Port: port, Database: "purpleair"})
+	if err != nil {
+		t.Fatalf("newInfluxSink() error = %v", err)
+	}
+	defer func() { _ = s.Close(context.Background()) }()
+
+	status := &purpleAirStatus{SensorId: "84:f3:eb:00:00:01", EPAAQI: 42}
+	status.A.SensorId, status.A.Sensor = status.SensorId, "A"
+	status.B.SensorId, status.B.Sensor = status.SensorId, "B"
+	if err := s.Publish(context.Background(), status); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	if gotDB != "purpleair" {
+		t.Errorf("db = %s, want purpleair", gotDB)
+	}
+	lines := strings.Split(strings.TrimSpace(gotBody), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("wrote %d points, want 3:\n%s", len(lines), gotBody)
+	}
+	for _, prefix := range []string{"purpleair_monitor,sensor=A", "purpleair_monitor,sensor=B", "purpleair_status,"} {
+		found := false
+		for _, l := range lines {
+			if strings.HasPrefix(l, prefix) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("no point starting with %q in:\n%s", prefix, gotBody)
+		}
+	}
+}
+
+func TestInfluxSinkPublishError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"database not found"}`, http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+	s, err := newInfluxSink(tomlConfigInflux{Hostname: u.Hostname(), Port: port, Database: "missing"})
+	if err != nil {
+		t.Fatalf("newInfluxSink() error = %v", err)
+	}
+	defer func() { _ = s.Close(context.Background()) }()
+
+	if err := s.Publish(context.Background(), &purpleAirStatus{}); err == nil {
+		t.Errorf("Publish() error = nil, want error from server")
+	}
+}
+
+func TestStatusToPointExtras(t *testing.T) {
+	status := &purpleAirStatus{SensorId: "84:f3:eb:00:00:01", Temperature: 71}
+	extras := map[string]interface{}{"voc_index": 123.5, "temperature": 99.0}
+	p, err := status_to_point(status, "purpleair_status", extras, time.Unix(0, 0))
+	if err != nil {
+		t.Fatalf("status_to_point() error = %v", err)
+	}
+	fields, err := p.Fields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields["voc_index"] != 123.5 {
+		t.Errorf("voc_index = %v, want 123.5", fields["voc_index"])
+	}
+	if fields["temperature"] != int64(71) {
+		t.Errorf("temperature = %v, want the reading's 71 rather than the extra", fields["temperature"])
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+)
+
+type tomlConfigModbus struct {
+	Listen     string         // Address to serve Modbus TCP on, e.g. ":502"
+	Units      map[string]int // Unit ID of each sensor, by MAC address or name; if empty, every unit ID reads the one sensor
+	StaleAfter int            // Seconds without a reading before a unit's stale flag is set (default: 900)
+}
+
+// modbusConfigured reports whether the [modbus] section is in use.
+func modbusConfigured(cfg tomlConfigModbus) bool {
+	return !reflect.DeepEqual(cfg, tomlConfigModbus{})
+}
+
+// The register map, the same for every unit and served as both holding and
+// input registers. Values are 16-bit; those marked signed are two's
+// complement. Concentrations, temperatures, humidity and pressure are scaled
+// by 10, so 12.3 µg/m³ reads as 123.
+const (
+	modbusRegAQI         = iota // US EPA AQI
+	modbusRegCategory           // AQI category: 0 Good … 5 Hazardous; 65535 if unknown
+	modbusRegPM25AQI            // US EPA PM2.5 AQI
+	modbusRegPM10AQI            // US EPA PM10 AQI
+	modbusRegPM1                // PM1.0, µg/m³ ×10
+	modbusRegPM25               // PM2.5, µg/m³ ×10
+	modbusRegPM10               // PM10, µg/m³ ×10
+	modbusRegPM25A              // channel A PM2.5, µg/m³ ×10
+	modbusRegPM25B              // channel B PM2.5, µg/m³ ×10
+	modbusRegTemperature        // °F ×10, signed
+	modbusRegHumidity           // % ×10
+	modbusRegDewpoint           // °F ×10, signed
+	modbusRegPressure           // hPa ×10
+	modbusRegHealth             // health flags, below
+	modbusRegAge                // seconds since the last reading, up to 65535
+	modbusRegRSSI               // Wi-Fi signal, dBm, signed
+	modbusRegisters
+)
+
+// Health flags, in modbusRegHealth.
+const (
+	modbusHealthStale     = 1 << iota // no reading for stale_after, or none yet
+	modbusHealthDisagree              // channels A and B disagree on PM2.5
+	modbusHealthNoChannel             // channel B reports nothing while A does
+	modbusHealthClamped               // a value didn't fit its register and was clamped
+)
+
+// Modbus function and exception codes the server uses.
+const (
+	modbusReadHoldingRegisters = 0x03
+	modbusReadInputRegisters   = 0x04
+
+	modbusIllegalFunction     = 0x01
+	modbusIllegalDataAddress  = 0x02
+	modbusIllegalDataValue    = 0x03
+	modbusGatewayTargetFailed = 0x0b
+)
+
+// modbusIdleTimeout closes connections that go quiet, so clients that vanish
+// don't hold on to them.
+const modbusIdleTimeout = 5 * time.Minute
+
+// modbusServer serves the latest reading of each sensor as Modbus TCP
+// registers, one unit ID per sensor.
+type modbusServer struct {
+	cfg   tomlConfigModbus
+	macs  map[string]byte // unit IDs by MAC address, as normalizeMAC returns them
+	names map[string]byte // unit IDs by sensor name
+	now   func() time.Time
+
+	mu       sync.RWMutex
+	readings map[byte]modbusReading
+
+	listener net.Listener
+	conns    map[net.Conn]bool // open connections, behind mu
+	closed   bool              // set by Close, behind mu; no connections are added after
+	wg       sync.WaitGroup
+}
+
+type modbusReading struct {
+	status     *purpleAirStatus
+	receivedAt time.Time
+}
+
+func newModbusServer(cfg tomlConfigModbus) (*modbusServer, error) {
+	if cfg.StaleAfter <= 0 {
+		cfg.StaleAfter = 900
+	}
+	s := &modbusServer{
+		cfg:      cfg,
+		macs:     map[string]byte{},
+		names:    map[string]byte{},
+		now:      time.Now,
+		readings: map[byte]modbusReading{},
+		conns:    map[net.Conn]bool{},
+	}
+	for sensor, unit := range cfg.Units {
+		if _, err := net.ParseMAC(sensor); err == nil {
+			s.macs[normalizeMAC(sensor)] = byte(unit)
+		} else {
+			s.names[sensor] = byte(unit)
+		}
+	}
+	ln, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("starting Modbus server: %w", err)
+	}
+	s.listener = ln
+	logger.Infof("Serving Modbus TCP on %s", ln.Addr())
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+func (s *modbusServer) Name() string {
+	return "Modbus"
+}
+
+// unit returns the unit ID a sensor's readings are served under; ok is false
+// for a sensor that isn't in units.
+func (s *modbusServer) unit(status *purpleAirStatus) (unit byte, ok bool) {
+	if len(s.cfg.Units) == 0 {
+		return 1, true
+	}
+	if unit, ok := s.macs[normalizeMAC(status.SensorId)]; ok {
+		return unit, true
+	}
+	unit, ok = s.names[status.Geo]
+	return unit, ok
+}
+
+// Publish keeps the reading for the sensor's unit, replacing the last one.
+func (s *modbusServer) Publish(ctx context.Context, status *purpleAirStatus) error {
+	unit, ok := s.unit(status)
+	if !ok {
+		logger.Debugf("Modbus: no unit ID for %s (%s); not serving it", status.Geo, status.SensorId)
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readings[unit] = modbusReading{status: status, receivedAt: s.now()}
+	return nil
+}
+
+// Close stops accepting connections and closes those open.
+func (s *modbusServer) Close(ctx context.Context) error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+func (s *modbusServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Errorf("Modbus server stopped: %s", err)
+			}
+			return
+		}
+		// a connection accepted as Close runs is turned away rather than
+		// left open after it
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = true
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(conn)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// serveConn answers Modbus TCP requests on conn until it's closed or sends
+// something that isn't Modbus.
+func (s *modbusServer) serveConn(conn net.Conn) {
+	header := make([]byte, 7)
+	for {
+		conn.SetReadDeadline(time.Now().Add(modbusIdleTimeout))
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		// MBAP header: transaction ID, protocol ID (0 for Modbus), length of
+		// the unit ID and PDU, unit ID
+		protocol := binary.BigEndian.Uint16(header[2:4])
+		length := int(binary.BigEndian.Uint16(header[4:6]))
+		if protocol != 0 || length < 2 || length > 254 {
+			logger.Warnf("Modbus: closing connection from %s after a malformed request", conn.RemoteAddr())
+			return
+		}
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			return
+		}
+
+		reply := s.handle(header[6], pdu)
+		response := make([]byte, 7, 7+len(reply))
+		copy(response, header[:4])
+		binary.BigEndian.PutUint16(response[4:6], uint16(len(reply)+1))
+		response[6] = header[6]
+		if _, err := conn.Write(append(response, reply...)); err != nil {
+			return
+		}
+	}
+}
+
+// handle answers a single request PDU for a unit. Only the register reads
+// are supported; the registers can't be written.
+func (s *modbusServer) handle(unit byte, pdu []byte) []byte {
+	function := pdu[0]
+	exception := func(code byte) []byte { return []byte{function | 0x80, code} }
+
+	if function != modbusReadHoldingRegisters && function != modbusReadInputRegisters {
+		return exception(modbusIllegalFunction)
+	}
+	if len(pdu) != 5 {
+		return exception(modbusIllegalDataValue)
+	}
+	start := int(binary.BigEndian.Uint16(pdu[1:3]))
+	count := int(binary.BigEndian.Uint16(pdu[3:5]))
+	if count < 1 || count > 125 {
+		return exception(modbusIllegalDataValue)
+	}
+	if start+count > modbusRegisters {
+		return exception(modbusIllegalDataAddress)
+	}
+	registers, ok := s.registers(unit)
+	if !ok {
+		return exception(modbusGatewayTargetFailed)
+	}
+
+	reply := []byte{function, byte(count * 2)}
+	for _, r := range registers[start : start+count] {
+		reply = binary.BigEndian.AppendUint16(reply, r)
+	}
+	return reply
+}
+
+// registers returns a unit's registers as of now; ok is false for a unit ID
+// that isn't configured. A configured unit with no reading yet reads as
+// zeros, flagged stale.
+func (s *modbusServer) registers(unit byte) (registers [modbusRegisters]uint16, ok bool) {
+	s.mu.RLock()
+	reading, seen := s.readings[unit]
+	if len(s.cfg.Units) == 0 {
+		// every unit ID reads the one sensor
+		reading, seen = s.readings[1]
+	}
+	s.mu.RUnlock()
+
+	if len(s.cfg.Units) > 0 && !seen {
+		for _, u := range s.cfg.Units {
+			if byte(u) == unit {
+				ok = true
+			}
+		}
+		if !ok {
+			return registers, false
+		}
+	}
+	if !seen {
+		registers[modbusRegCategory] = math.MaxUint16
+		registers[modbusRegHealth] = modbusHealthStale
+		registers[modbusRegAge] = math.MaxUint16
+		return registers, true
+	}
+	return modbusRegisterMap(reading.status, s.now().Sub(reading.receivedAt), time.Duration(s.cfg.StaleAfter)*time.Second), true
+}
+
+// modbusRegisterMap lays a reading out in registers, age being how long ago
+// it arrived.
+func modbusRegisterMap(status *purpleAirStatus, age, staleAfter time.Duration) [modbusRegisters]uint16 {
+	var r [modbusRegisters]uint16
+	var health uint16
+	unsigned := func(v float64) uint16 {
+		if v < 0 || v > math.MaxUint16 {
+			health |= modbusHealthClamped
+		}
+		return uint16(min(max(math.Round(v), 0), math.MaxUint16))
+	}
+	signed := func(v float64) uint16 {
+		if v < math.MinInt16 || v > math.MaxInt16 {
+			health |= modbusHealthClamped
+		}
+		return uint16(int16(min(max(math.Round(v), math.MinInt16), math.MaxInt16)))
+	}
+
+	r[modbusRegAQI] = unsigned(float64(status.EPAAQI))
+	r[modbusRegCategory] = math.MaxUint16
+	for i, c := range aqiCategories {
+		if c.Category == status.EPAAQICategory {
+			r[modbusRegCategory] = uint16(i)
+		}
+	}
+	r[modbusRegPM25AQI] = unsigned(float64(status.EPAPM25AQI))
+	r[modbusRegPM10AQI] = unsigned(float64(status.EPAPM10AQI))
+	r[modbusRegPM1] = unsigned(float64(status.PM10Atm) * 10)
+	r[modbusRegPM25] = unsigned(float64(status.PM25Atm) * 10)
+	r[modbusRegPM10] = unsigned(float64(status.PM100Atm) * 10)
+	r[modbusRegPM25A] = unsigned(float64(status.A.PM25Atm) * 10)
+	r[modbusRegPM25B] = unsigned(float64(status.B.PM25Atm) * 10)
+	r[modbusRegTemperature] = signed(float64(status.Temperature) * 10)
+	r[modbusRegHumidity] = unsigned(float64(status.Humidity) * 10)
+	r[modbusRegDewpoint] = signed(float64(status.Dewpoint) * 10)
+	r[modbusRegPressure] = unsigned(float64(status.Pressure) * 10)
+	r[modbusRegRSSI] = signed(float64(status.RSSI))
+	r[modbusRegAge] = uint16(min(age.Seconds(), math.MaxUint16))
+
+	if age >= staleAfter {
+		health |= modbusHealthStale
+	}
+	if channelsDisagree(float64(status.A.PM25Atm), float64(status.B.PM25Atm)) {
+		health |= modbusHealthDisagree
+	}
+	if status.B.PM25Atm == 0 && status.B.PM10Atm == 0 && status.B.PM100Atm == 0 && status.A.PM25Atm > 0 {
+		health |= modbusHealthNoChannel
+	}
+	r[modbusRegHealth] = health
+	return r
+}
+
+// channelsDisagree applies the usual check of a PurpleAir's two laser
+// counters: they disagree when their PM2.5 differs by more than 5 µg/m³ and
+// by more than 70% of their mean. A channel reporting nothing is flagged
+// separately.
+func channelsDisagree(a, b float64) bool {
+	if a == 0 || b == 0 {
+		return false
+	}
+	diff := math.Abs(a - b)
+	return diff > 5 && diff/((a+b)/2) > 0.7
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestModbusRegisterMap(t *testing.T) {
+	status := &purpleAirStatus{EPAAQI: 57, EPAAQICategory: "Moderate", EPAPM25AQI: 57, EPAPM10AQI: 12,
+		PM10Atm: 8.04, PM25Atm: 13.5, PM100Atm: 15.25}
+	status.Temperature = -4
+	status.Humidity = 40
+	status.Dewpoint = -12
+	status.Pressure = 1006.5
+	status.RSSI = -61
+	status.A.PM25Atm = 13
+	status.B.PM25Atm = 14
+
+	r := modbusRegisterMap(status, 90*time.Second, 15*time.Minute)
+	expected := map[int]uint16{
+		modbusRegAQI:         57,
+		modbusRegCategory:    1,
+		modbusRegPM25AQI:     57,
+		modbusRegPM10AQI:     12,
+		modbusRegPM1:         80,
+		modbusRegPM25:        135,
+		modbusRegPM10:        153,
+		modbusRegPM25A:       130,
+		modbusRegPM25B:       140,
+		modbusRegTemperature: uint16(0x10000 - 40),
+		modbusRegHumidity:    400,
+		modbusRegDewpoint:    uint16(0x10000 - 120),
+		modbusRegPressure:    10065,
+		modbusRegHealth:      0,
+		modbusRegAge:         90,
+		modbusRegRSSI:        uint16(0x10000 - 61),
+	}
+	for reg, want := range expected {
+		if r[reg] != want {
+			t.Errorf("register %d = %d, want %d", reg, r[reg], want)
+		}
+	}
+}
+
+func TestModbusRegisterMapHealth(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     float32
+		pm25     float32
+		age      time.Duration
+		expected uint16
+	}{
+		{"Healthy", 10, 11, 10, time.Minute, 0},
+		{"Stale", 10, 11, 10, time.Hour, modbusHealthStale},
+		{"Channels disagree", 4, 30, 17, time.Minute, modbusHealthDisagree},
+		{"Small absolute difference", 1, 4, 2.5, time.Minute, 0},
+		{"Channel B missing", 10, 0, 10, time.Minute, modbusHealthNoChannel},
+		{"Clamped", 10, 11, 7000, time.Minute, modbusHealthClamped},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := &purpleAirStatus{PM25Atm: tt.pm25}
+			status.A.PM25Atm = tt.a
+			status.B.PM25Atm = tt.b
+			r := modbusRegisterMap(status, tt.age, 15*time.Minute)
+			if r[modbusRegHealth] != tt.expected {
+				t.Errorf("health = %04b, want %04b", r[modbusRegHealth], tt.expected)
+			}
+		})
+	}
+}
+
+func TestModbusServerHandle(t *testing.T) {
+	s := &modbusServer{
+		cfg:      tomlConfigModbus{Units: map[string]int{"84:f3:eb:00:00:01": 2, "Back yard": 3}, StaleAfter: 900},
+		macs:     map[string]byte{"84:f3:eb:00:00:01": 2},
+		names:    map[string]byte{"Back yard": 3},
+		now:      time.Now,
+		readings: map[byte]modbusReading{},
+	}
+	if err := s.Publish(context.Background(), &purpleAirStatus{SensorId: "84:F3:EB:00:00:01", EPAAQI: 42}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		unit     byte
+		pdu      []byte
+		expected []byte
+	}{
+		{"Holding registers", 2, []byte{0x03, 0, 0, 0, 2}, []byte{0x03, 4, 0, 42, 0xff, 0xff}},
+		{"Input registers", 2, []byte{0x04, 0, 0, 0, 1}, []byte{0x04, 2, 0, 42}},
+		{"No reading yet", 3, []byte{0x03, 0, modbusRegHealth, 0, 1}, []byte{0x03, 2, 0, modbusHealthStale}},
+		{"Unknown unit", 9, []byte{0x03, 0, 0, 0, 1}, []byte{0x83, modbusGatewayTargetFailed}},
+		{"Past the last register", 2, []byte{0x03, 0, modbusRegisters - 1, 0, 2}, []byte{0x83, modbusIllegalDataAddress}},
+		{"Too many registers", 2, []byte{0x03, 0, 0, 0, 126}, []byte{0x83, modbusIllegalDataValue}},
+		{"Write", 2, []byte{0x06, 0, 0, 0, 1}, []byte{0x86, modbusIllegalFunction}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.handle(tt.unit, tt.pdu); string(got) != string(tt.expected) {
+				t.Errorf("handle() = % x, want % x", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestModbusServerServes(t *testing.T) {
+	s, err := newModbusServer(tomlConfigModbus{Listen: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatalf("newModbusServer() error = %v", err)
+	}
+	if err := s.Publish(context.Background(), &purpleAirStatus{Geo: "Back yard", EPAAQI: 42, EPAAQICategory: "Good"}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	conn, err := net.Dial("tcp", s.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// transaction 7, unit 5: with no units configured every unit reads the sensor
+	if _, err := conn.Write([]byte{0, 7, 0, 0, 0, 6, 5, 0x03, 0, 0, 0, 2}); err != nil {
+		t.Fatal(err)
+	}
+	response := make([]byte, 13)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, response); err != nil {
+		t.Fatal(err)
+	}
+	if binary.BigEndian.Uint16(response[0:2]) != 7 || binary.BigEndian.Uint16(response[4:6]) != 7 || response[6] != 5 {
+		t.Errorf("MBAP header = % x", response[:7])
+	}
+	if aqi, category := binary.BigEndian.Uint16(response[9:11]), binary.BigEndian.Uint16(response[11:13]); aqi != 42 || category != 0 {
+		t.Errorf("AQI = %d, category = %d; want 42 and 0", aqi, category)
+	}
+
+	if err := s.Close(context.Background()); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if _, err := io.ReadFull(conn, response[:1]); err == nil {
+		t.Errorf("connection still open after Close()")
+	}
+}
+
+// lateListener hands out one connection only once it's been closed, as a
+// connection accepted just before Close would be.
+type lateListener struct {
+	net.Listener
+	closed chan struct{}
+	conn   net.Conn
+}
+
+func (l *lateListener) Accept() (net.Conn, error) {
+	<-l.closed
+	if c := l.conn; c != nil {
+		l.conn = nil
+		return c, nil
+	}
+	return nil, net.ErrClosed
+}
+
+func (l *lateListener) Close() error {
+	close(l.closed)
+	return nil
+}
+
+func TestModbusServerCloseRefusesLateConnections(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	s := &modbusServer{
+		readings: map[byte]modbusReading{},
+		listener: &lateListener{closed: make(chan struct{}), conn: server},
+		conns:    map[net.Conn]bool{},
+	}
+	s.wg.Add(1)
+	go s.serve()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Close(ctx); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read from a connection accepted during Close() = %v, want EOF", err)
+	}
+}
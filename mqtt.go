@@ -0,0 +1,720 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+var connectHandler mqtt.OnConnectHandler = func(client mqtt.Client) {
+	r := client.OptionsReader()
+	logger.Infof("Connected to MQTT at %s", r.Servers())
+}
+
+var connectLostHandler mqtt.ConnectionLostHandler = func(client mqtt.Client, err error) {
+	logger.Errorf("MQTT Connection lost: %v", err)
+}
+
+const (
+	availabilityOnline  = "online"
+	availabilityOffline = "offline"
+)
+
+const (
+	mqttModeAlways   = "always"
+	mqttModeFailover = "failover"
+)
+
+const (
+	mqttOutputTopics    = "topics"
+	mqttOutputHomie     = "homie"
+	mqttOutputSparkplug = "sparkplug"
+)
+
+// mqttSink publishes each field of a reading to its own MQTT topic.
+type mqttSink struct {
+	cfg    tomlConfigMQTT
+	hass   tomlConfigHass
+	client mqtt.Client
+
+	payload *template.Template            // for each field's message; nil to send the value as is
+	topics  map[string]*template.Template // extra topics, by name
+
+	opts        *mqtt.ClientOptions // the client is made from on connect; nil in tests
+	connecting  mqtt.Token          // completes once the first connection is made; nil in tests
+	willPending bool                // the last will names the sensor, so waits for its first reading
+	willTopic   string              // where the broker publishes our last will
+	online      map[string]bool     // availability topics marked online, besides willTopic
+	announced   map[string]bool     // discovery config topics already published
+
+	legacyPublished []string        // legacy topics published for the current reading
+	legacyWarned    map[string]bool // sensors whose legacy topics have been logged
+
+	homieDevices map[string]string // description last published for each Homie device, by ID
+	reconnected  atomic.Bool       // set on each connection, so Homie devices are described again
+	sparkplug    *sparkplugNode    // nil unless the output is sparkplug
+}
+
+// availabilityTopic is where the bridge publishes "online" for a sensor and
+// "offline" on shutdown, under the same prefix and {geo} as its readings, with
+// empty levels left out as they are from those.
+func availabilityTopic(cfg tomlConfigMQTT, status *purpleAirStatus) string {
+	return renderTopic("{prefix}/{geo}/availability", topicValues{
+		prefix: cfg.TopicPrefix,
+		topic:  cfg.Topic,
+		geo:    status.Geo,
+	})
+}
+
+// newMQTTSink starts connecting to the broker without waiting for it, so the
+// bridge starts even if the broker isn't up yet: the connection is retried
+// until it succeeds, and re-established whenever it drops. Unless the topic is
+// configured, the last will names the sensor, so the sink connects without
+// one and reconnects with it once the first reading arrives.
+func newMQTTSink(cfg tomlConfigMQTT, hass tomlConfigHass) (*mqttSink, error) {
+	s, err := newMQTTSinkWithClient(cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.hass = hass
+	opts := mqtt.NewClientOptions()
+	broker := fmt.Sprintf("tcp://%s:%d", cfg.BrokerHost, cfg.BrokerPort)
+
+	opts.AddBroker(broker)
+	if cfg.BrokerPassword != "" && cfg.BrokerUsername != "" {
+		opts.SetUsername(cfg.BrokerUsername)
+		opts.SetPassword(cfg.BrokerPassword)
+	}
+	opts.SetClientID(cfg.ClientId)
+	var connected atomic.Bool
+	var attempts atomic.Int32
+	opts.OnConnect = func(client mqtt.Client) {
+		connected.Store(true)
+		connectHandler(client)
+		switch {
+		case s.sparkplug != nil:
+			s.sparkplugConnected(client)
+		case s.cfg.Output == mqttOutputHomie:
+			s.reconnected.Store(true)
+		default:
+			// a client connected before the first reading has no will, and
+			// nothing to mark online
+			if r := client.OptionsReader(); r.WillEnabled() {
+				client.Publish(r.WillTopic(), 1, true, availabilityOnline)
+			}
+		}
+	}
+	opts.OnConnectionLost = connectLostHandler
+	opts.OnReconnecting = func(_ mqtt.Client, opts *mqtt.ClientOptions) {
+		logger.Warnf("Reconnecting to MQTT at %s", broker)
+		if s.sparkplug != nil {
+			// every connection is a new Sparkplug session with its own bdSeq
+			opts.SetBinaryWill(s.sparkplug.topic("NDEATH", ""), s.sparkplug.nextSession(), 1, false)
+		}
+	}
+
+	if cfg.KeepAlive > 0 {
+		opts.SetKeepAlive(time.Duration(cfg.KeepAlive) * time.Second)
+	}
+	opts.SetConnectRetry(true)
+	if cfg.ConnectRetryInterval > 0 {
+		opts.SetConnectRetryInterval(time.Duration(cfg.ConnectRetryInterval) * time.Second)
+	}
+	opts.SetAutoReconnect(true)
+	if cfg.MaxReconnectInterval > 0 {
+		opts.SetMaxReconnectInterval(time.Duration(cfg.MaxReconnectInterval) * time.Second)
+	}
+	opts.SetCleanSession(!cfg.PersistentSession)
+	if cfg.StoreDirectory != "" {
+		if err := os.MkdirAll(cfg.StoreDirectory, 0o700); err != nil {
+			return nil, fmt.Errorf("creating MQTT store directory: %w", err)
+		}
+		opts.SetStore(mqtt.NewFileStore(cfg.StoreDirectory))
+	}
+
+	// paho doesn't report failed attempts, only the next one
+	opts.SetConnectionAttemptHandler(func(u *url.URL, tlsCfg *tls.Config) *tls.Config {
+		if attempts.Add(1) > 1 && !connected.Load() {
+			logger.Warnf("MQTT broker at %s isn't reachable yet; retrying every %s", u.Host, opts.ConnectRetryInterval)
+		}
+		return tlsCfg
+	})
+
+	s.opts = opts
+	if s.sparkplug != nil || cfg.Topic != "" {
+		s.connect(&purpleAirStatus{})
+	} else {
+		s.willPending = true
+		s.connect(nil)
+	}
+	if s.sparkplug != nil {
+		s.sparkplug.stop = make(chan struct{})
+		go s.watchSparkplugDevices()
+	}
+	return s, nil
+}
+
+// connect makes a client and starts connecting, with a last will for the
+// sensor of status: NDEATH for a Sparkplug node, $state lost for a Homie
+// device, otherwise "offline" on its availability topic. A nil status, for a
+// sensor that isn't known yet, connects without a will.
+func (s *mqttSink) connect(status *purpleAirStatus) {
+	switch {
+	case s.sparkplug != nil:
+		s.willTopic = s.sparkplug.topic("NDEATH", "")
+		s.opts.SetBinaryWill(s.willTopic, s.sparkplug.deathPayload(), 1, false)
+	case status == nil:
+	case s.cfg.Output == mqttOutputHomie:
+		s.willTopic = s.homieBase(s.homieDeviceId(status)) + "/$state"
+		s.opts.SetWill(s.willTopic, homieStateLost, 1, true)
+	default:
+		s.willTopic = availabilityTopic(s.cfg, status)
+		s.opts.SetWill(s.willTopic, availabilityOffline, 1, true)
+	}
+	s.client = mqtt.NewClient(s.opts)
+	logger.Infof("Connecting to MQTT at %s", s.opts.Servers[0])
+	// with connect retry on, this only completes once connected, so nothing
+	// waits on it; publishes made in the meantime are handled by publish
+	s.connecting = s.client.Connect()
+}
+
+// setWill reconnects with a last will for the sensor of status, once its
+// first reading names it. Nothing has been published on the connection made
+// without one, so dropping it loses nothing; if it was up, the reading waits
+// for the new one.
+func (s *mqttSink) setWill(ctx context.Context, status *purpleAirStatus) error {
+	s.willPending = false
+	wasOpen := s.client.IsConnectionOpen()
+	s.client.Disconnect(quiesce(ctx))
+	s.connect(status)
+	if !wasOpen {
+		return nil
+	}
+	return s.waitConnected(ctx)
+}
+
+// waitConnected blocks until the first connection to the broker is made, for
+// one-shot commands that would rather fail than queue.
+func (s *mqttSink) waitConnected(ctx context.Context) error {
+	if s.connecting == nil {
+		return nil
+	}
+	select {
+	case <-s.connecting.Done():
+		return s.connecting.Error()
+	case <-ctx.Done():
+		return fmt.Errorf("connecting to MQTT at %s:%d: %w", s.cfg.BrokerHost, s.cfg.BrokerPort, ctx.Err())
+	}
+}
+
+func newMQTTSinkWithClient(cfg tomlConfigMQTT, client mqtt.Client) (*mqttSink, error) {
+	if cfg.TopicPrefix == "" {
+		cfg.TopicPrefix = "purpleair"
+	}
+	if cfg.TopicTemplate == "" {
+		cfg.TopicTemplate = defaultTopicTemplate
+	}
+	if cfg.TopicLayout == "" {
+		cfg.TopicLayout = topicLayoutLegacy
+	}
+	if cfg.FieldStyle == "" {
+		cfg.FieldStyle = defaultFieldStyle(cfg.TopicLayout)
+	}
+	if cfg.Output == "" {
+		cfg.Output = mqttOutputTopics
+	}
+	if cfg.HomieVersion == 0 {
+		cfg.HomieVersion = 4
+	}
+	if cfg.SparkplugGroupId == "" {
+		cfg.SparkplugGroupId = "PurpleAir"
+	}
+	if cfg.SparkplugEdgeNode == "" {
+		cfg.SparkplugEdgeNode = sparkplugEdgeNode(cfg.ClientId)
+	}
+	if cfg.SparkplugOfflineAfter == 0 {
+		cfg.SparkplugOfflineAfter = 900
+	}
+	s := &mqttSink{cfg: cfg, client: client, topics: map[string]*template.Template{}}
+	if cfg.Output == mqttOutputSparkplug {
+		s.sparkplug = newSparkplugNode(cfg)
+	}
+	if cfg.PayloadTemplate != "" {
+		t, err := newPayloadTemplate("payload", cfg.PayloadTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("parsing MQTT payload template: %w", err)
+		}
+		s.payload = t
+	}
+	for name, text := range cfg.Topics {
+		t, err := newPayloadTemplate(name, text)
+		if err != nil {
+			return nil, fmt.Errorf("parsing template for MQTT topic %s: %w", name, err)
+		}
+		s.topics[name] = t
+	}
+	return s, nil
+}
+
+// mqttPayload is what a payload template is executed with for each field.
+type mqttPayload struct {
+	Field   string      // in the target's field style
+	Channel string      // sensor_A or sensor_B, in the target's field style; empty for overall fields
+	Value   interface{} // optional fields are dereferenced
+	Reading *purpleAirStatus
+}
+
+func (s *mqttSink) Name() string {
+	return "MQTT"
+}
+
+func (s *mqttSink) Publish(ctx context.Context, status *purpleAirStatus) error {
+	if s.willPending {
+		if err := s.setWill(ctx, status); err != nil {
+			return err
+		}
+	}
+	fields := readingFields(status, s.cfg.ExtraFields)
+	switch s.cfg.Output {
+	case mqttOutputHomie:
+		return s.publishHomie(ctx, status, fields)
+	case mqttOutputSparkplug:
+		return s.publishSparkplug(ctx, status, fields)
+	}
+
+	if err := s.markOnline(ctx, status); err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if err := s.publishField(ctx, status, f.channel, f.name, f.value); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range sortedKeys(s.topics) {
+		payload, err := executePayload(s.topics[name], status)
+		if err != nil {
+			return fmt.Errorf("rendering MQTT topic %s: %w", name, err)
+		}
+		if err := s.publish(ctx, s.namedTopic(status, name), payload); err != nil {
+			return err
+		}
+	}
+
+	s.warnLegacy(status)
+
+	if s.hass.Discovery {
+		return s.announce(ctx, status)
+	}
+	return nil
+}
+
+// markOnline publishes "online" to the sensor's availability topic the first
+// time it's read, unless it's the one the last will covers, which is marked
+// online on every connection.
+func (s *mqttSink) markOnline(ctx context.Context, status *purpleAirStatus) error {
+	topic := availabilityTopic(s.cfg, status)
+	if topic == s.willTopic || s.online[topic] {
+		return nil
+	}
+	if err := s.publishRetained(ctx, topic, availabilityOnline); err != nil {
+		return err
+	}
+	if s.online == nil {
+		s.online = map[string]bool{}
+	}
+	s.online[topic] = true
+	return nil
+}
+
+// publishField publishes a single field of the reading, if the target's
+// fields include it, formatted by the payload template if there is one.
+func (s *mqttSink) publishField(ctx context.Context, status *purpleAirStatus, channel, field string, value interface{}) error {
+	topic, ok := s.topic(status, channel, field)
+	if !ok {
+		return nil
+	}
+	payload := fmt.Sprintf("%v", value)
+	if s.payload != nil {
+		var err error
+		payload, err = executePayload(s.payload, mqttPayload{
+			Field:   styleFieldName(field, s.cfg.FieldStyle),
+			Channel: styleFieldName(channel, s.cfg.FieldStyle),
+			Value:   value,
+			Reading: status,
+		})
+		if err != nil {
+			return fmt.Errorf("rendering payload for %s: %w", topic, err)
+		}
+	}
+	logger.Infof("field[%s] = [%v]", field, value)
+	logger.Infof("topic = %s", topic)
+	if err := s.publish(ctx, topic, payload); err != nil {
+		return err
+	}
+
+	legacy := s.legacyTopic(status, channel, field)
+	switch {
+	case s.cfg.TopicLayout == topicLayoutLegacy && topic == legacy:
+		s.legacyPublished = append(s.legacyPublished, legacy)
+	case s.cfg.TopicLayout == topicLayoutBoth && topic != legacy:
+		if err := s.publish(ctx, legacy, payload); err != nil {
+			return err
+		}
+		s.legacyPublished = append(s.legacyPublished, legacy)
+	}
+	return nil
+}
+
+// legacyTopic returns the topic a field was published to before topic
+// layouts: the default template with Go field names.
+func (s *mqttSink) legacyTopic(status *purpleAirStatus, channel, field string) string {
+	return renderTopic(defaultTopicTemplate, topicValues{
+		prefix:   s.cfg.TopicPrefix,
+		topic:    s.cfg.Topic,
+		geo:      status.Geo,
+		sensorId: status.SensorId,
+		channel:  channel,
+		field:    field,
+	})
+}
+
+// warnLegacy logs the legacy topics just published for a sensor, the first
+// time they're published, so it's clear what automations still need moving
+// before the legacy layout is turned off.
+func (s *mqttSink) warnLegacy(status *purpleAirStatus) {
+	topics := s.legacyPublished
+	s.legacyPublished = nil
+	sensor := status.SensorId + "/" + status.Geo
+	if len(topics) == 0 || s.legacyWarned[sensor] {
+		return
+	}
+	if s.legacyWarned == nil {
+		s.legacyWarned = map[string]bool{}
+	}
+	s.legacyWarned[sensor] = true
+	if s.cfg.TopicLayout == topicLayoutBoth {
+		logger.Warnf("MQTT %s: still publishing %d legacy topics for %s alongside the v2 layout; once nothing subscribes to them, set topic_layout = \"%s\": %s",
+			mqttLabel(s.cfg), len(topics), status.Geo, topicLayoutV2, strings.Join(topics, ", "))
+	} else {
+		logger.Warnf("MQTT %s: publishing %d topics for %s in the legacy layout, which is deprecated; set topic_layout = \"%s\" to publish the v2 layout alongside them while moving automations over: %s",
+			mqttLabel(s.cfg), len(topics), status.Geo, topicLayoutBoth, strings.Join(topics, ", "))
+	}
+}
+
+// topic returns the topic a field of the reading is published to, from the
+// target's template and field style. channel is empty for fields that aren't
+// per-channel. ok is false if the target's fields don't include it.
+func (s *mqttSink) topic(status *purpleAirStatus, channel, field string) (topic string, ok bool) {
+	if channel != "" {
+		channel = sanitizeTopicLevel(styleFieldName(channel, s.cfg.FieldStyle))
+	}
+	field = sanitizeTopicLevel(styleFieldName(field, s.cfg.FieldStyle))
+	if !s.selected(channel, field) {
+		return "", false
+	}
+	return renderTopic(s.cfg.TopicTemplate, topicValues{
+		prefix:   s.cfg.TopicPrefix,
+		topic:    s.cfg.Topic,
+		geo:      status.Geo,
+		sensorId: status.SensorId,
+		channel:  channel,
+		field:    field,
+	}), true
+}
+
+// statusFieldValue returns the value of a reading field, dereferencing the
+// optional ones; ok is false for an optional field the device didn't report.
+func statusFieldValue(v reflect.Value) (value interface{}, ok bool) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, false
+		}
+		v = v.Elem()
+	}
+	return v.Interface(), true
+}
+
+// mqttField is a single value of a reading.
+type mqttField struct {
+	channel string // sensor_A or sensor_B; empty for overall fields
+	name    string
+	value   interface{}
+}
+
+// readingFields returns the fields of a reading that are published: each
+// field the device reported, the unrecognized ones extraFields selects, and
+// each channel's EPA AQI values.
+func readingFields(status *purpleAirStatus, extraFields string) []mqttField {
+	var fields []mqttField
+	v := reflect.ValueOf(*status)
+	typeOfStatus := v.Type()
+
+	for i := 0; i < v.NumField(); i++ {
+		fieldName := typeOfStatus.Field(i).Name
+
+		if fieldName == "A" || fieldName == "B" || fieldName == "Extras" {
+			continue
+		}
+
+		fieldValue, ok := statusFieldValue(v.Field(i))
+		if !ok {
+			continue
+		}
+		fields = append(fields, mqttField{name: fieldName, value: fieldValue})
+	}
+
+	extras := selectExtras(status.Extras, extraFields)
+	for _, key := range sortedKeys(extras) {
+		fields = append(fields, mqttField{name: key, value: extras[key]})
+	}
+
+	// Also publish sensor A and B EPA AQI values
+	fields = append(fields, sensorEPAAQIFields(&status.A, "A")...)
+	return append(fields, sensorEPAAQIFields(&status.B, "B")...)
+}
+
+func sensorEPAAQIFields(monitor *purpleAirMonitor, sensor string) []mqttField {
+	values := []struct {
+		name  string
+		value interface{}
+	}{
+		{"epa_aqi", monitor.EPAAQI},
+		{"epa_pm25_aqi", monitor.EPAPM25AQI},
+		{"epa_pm10_aqi", monitor.EPAPM10AQI},
+		{"epa_aqi_category", monitor.EPAAQICategory},
+		{"epa_aqi_color", monitor.EPAAQIColor},
+		{"epa_aqi_color_rgb", monitor.EPAAQIColorRGB},
+	}
+	fields := make([]mqttField, len(values))
+	for i, v := range values {
+		fields[i] = mqttField{channel: "sensor_" + sensor, name: v.name, value: v.value}
+	}
+	return fields
+}
+
+// namedTopic returns the topic of one of the target's extra topics: name
+// fills in {field}, as it's written, and {channel} is empty. The target's
+// fields don't apply.
+func (s *mqttSink) namedTopic(status *purpleAirStatus, name string) string {
+	return renderTopic(s.cfg.TopicTemplate, topicValues{
+		prefix:   s.cfg.TopicPrefix,
+		topic:    s.cfg.Topic,
+		geo:      status.Geo,
+		sensorId: status.SensorId,
+		field:    name,
+	})
+}
+
+// selected reports whether a field, named as {channel}/{field} or just
+// {field} would be, matches the target's fields; every field does if none
+// are given. channel and field are already in the target's field style.
+func (s *mqttSink) selected(channel, field string) bool {
+	if len(s.cfg.Fields) == 0 {
+		return true
+	}
+	topic := field
+	if channel != "" {
+		topic = channel + "/" + field
+	}
+	for _, pattern := range s.cfg.Fields {
+		if ok, _ := path.Match(pattern, topic); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// publish sends a single message at the configured QoS and waits for it to
+// complete, giving up when ctx is done so a hung broker can't stall the sink
+// forever.
+func (s *mqttSink) publish(ctx context.Context, topic string, payload string) error {
+	return s.send(ctx, topic, byte(s.cfg.Qos), false, payload)
+}
+
+func (s *mqttSink) publishRetained(ctx context.Context, topic string, payload string) error {
+	return s.send(ctx, topic, max(byte(s.cfg.Qos), 1), true, payload)
+}
+
+// send publishes a message. While the broker is unreachable, messages fail
+// straight away instead of waiting out the publish timeout, unless they can be
+// queued: paho only resends its stored QoS 1 and 2 messages when the session
+// is kept, and discards them on connect otherwise.
+func (s *mqttSink) send(ctx context.Context, topic string, qos byte, retained bool, payload string) error {
+	if !s.client.IsConnectionOpen() && (qos == 0 || !s.cfg.PersistentSession) {
+		return fmt.Errorf("publishing to %s: %w", topic, mqtt.ErrNotConnected)
+	}
+	token := s.client.Publish(topic, qos, retained, payload)
+	if !s.client.IsConnectionOpen() {
+		select {
+		case <-token.Done():
+			return token.Error()
+		default:
+			logger.Debugf("MQTT broker unreachable; %s queued until it reconnects", topic)
+			return nil
+		}
+	}
+	return s.wait(ctx, topic, token)
+}
+
+func (s *mqttSink) wait(ctx context.Context, topic string, token mqtt.Token) error {
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return fmt.Errorf("publishing to %s: %w", topic, ctx.Err())
+	}
+}
+
+// Close marks each sensor offline, its Homie device disconnected, or publishes
+// NDEATH for a Sparkplug node, and disconnects cleanly, so the broker doesn't
+// fire our last will. Nothing is queued if the broker isn't connected, since a
+// stored "offline" could be resent after the next run's "online".
+func (s *mqttSink) Close(ctx context.Context) error {
+	if s.sparkplug != nil && s.sparkplug.stop != nil {
+		close(s.sparkplug.stop)
+	}
+	var err error
+	if s.client.IsConnectionOpen() {
+		switch {
+		case s.sparkplug != nil:
+			err = s.closeSparkplug(ctx)
+		case s.cfg.Output == mqttOutputHomie:
+			err = s.closeHomie(ctx)
+		default:
+			err = s.markOffline(ctx)
+		}
+	}
+	s.client.Disconnect(quiesce(ctx))
+	return err
+}
+
+// quiesce is how many milliseconds Disconnect may wait for work in flight to
+// finish: 250, or whatever is left of ctx if that's less.
+func quiesce(ctx context.Context) uint {
+	if ctx.Err() != nil {
+		return 0
+	}
+	d := 250 * time.Millisecond
+	if deadline, ok := ctx.Deadline(); ok {
+		d = max(min(d, time.Until(deadline)), 0)
+	}
+	return uint(d.Milliseconds())
+}
+
+// markOffline publishes "offline" to every availability topic marked online.
+func (s *mqttSink) markOffline(ctx context.Context) error {
+	topics := sortedKeys(s.online)
+	if s.willTopic != "" {
+		topics = append([]string{s.willTopic}, topics...)
+	}
+	var errs []error
+	for _, topic := range topics {
+		errs = append(errs, s.publishRetained(ctx, topic, availabilityOffline))
+	}
+	return errors.Join(errs...)
+}
+
+// mqttLabel names a target in logs and errors.
+func mqttLabel(cfg tomlConfigMQTT) string {
+	if cfg.Name != "" {
+		return cfg.Name
+	}
+	return fmt.Sprintf("%s:%d", cfg.BrokerHost, cfg.BrokerPort)
+}
+
+// mqttFanout publishes each reading to every [[mqtt]] target at once. The
+// first target is the primary; failover targets are only published to while
+// the primary is down.
+type mqttFanout struct {
+	always   []*mqttSink // the primary first
+	failover []*mqttSink
+}
+
+// newMQTTFanout connects to every target. Home Assistant discovery is only
+// announced on the primary.
+func newMQTTFanout(targets []tomlConfigMQTT, hass tomlConfigHass) (*mqttFanout, error) {
+	f := &mqttFanout{}
+	for i, cfg := range targets {
+		if i > 0 {
+			hass = tomlConfigHass{}
+		}
+		s, err := newMQTTSink(cfg, hass)
+		if err != nil {
+			_ = f.Close(context.Background())
+			return nil, fmt.Errorf("%s: %w", mqttLabel(cfg), err)
+		}
+		f.add(s)
+	}
+	return f, nil
+}
+
+func (f *mqttFanout) add(s *mqttSink) {
+	if s.cfg.Mode == mqttModeFailover {
+		f.failover = append(f.failover, s)
+	} else {
+		f.always = append(f.always, s)
+	}
+}
+
+func (f *mqttFanout) Name() string {
+	return "MQTT"
+}
+
+func (f *mqttFanout) Publish(ctx context.Context, status *purpleAirStatus) error {
+	publish := func(s *mqttSink) error { return s.Publish(ctx, status) }
+	errs := f.each(f.always, publish)
+	if len(f.failover) > 0 && len(f.always) > 0 && (errs[0] != nil || !f.always[0].client.IsConnectionOpen()) {
+		// the failover targets stand in for the primary, so only their
+		// errors count, along with the other always targets'
+		if errs[0] != nil {
+			logger.Warnf("MQTT primary is down (%v); publishing to failover targets", errs[0])
+		} else {
+			logger.Warnf("MQTT primary %s is down; publishing to failover targets", mqttLabel(f.always[0].cfg))
+		}
+		errs = append(errs[1:], f.each(f.failover, publish)...)
+	}
+	return errors.Join(errs...)
+}
+
+// Close marks the bridge offline on, and disconnects from, every target.
+func (f *mqttFanout) Close(ctx context.Context) error {
+	closeSink := func(s *mqttSink) error { return s.Close(ctx) }
+	return errors.Join(append(f.each(f.always, closeSink), f.each(f.failover, closeSink)...)...)
+}
+
+// waitConnected blocks until every target other than the failover ones is
+// connected.
+func (f *mqttFanout) waitConnected(ctx context.Context) error {
+	return errors.Join(f.each(f.always, func(s *mqttSink) error { return s.waitConnected(ctx) })...)
+}
+
+// each calls fn for every sink concurrently, so a slow broker doesn't hold up
+// the others, and returns their errors labelled with the target, in order.
+func (f *mqttFanout) each(sinks []*mqttSink, fn func(s *mqttSink) error) []error {
+	errs := make([]error, len(sinks))
+	var wg sync.WaitGroup
+	for i, s := range sinks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(s); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", mqttLabel(s.cfg), err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errs
+}
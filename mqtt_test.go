@@ -0,0 +1,512 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// fakeToken is an mqtt.Token that is either already complete or, when hung
+// is set, never completes.
+type fakeToken struct {
+	err  error
+	hung bool
+}
+
+func (t *fakeToken) Wait() bool                     { return !t.hung }
+func (t *fakeToken) WaitTimeout(time.Duration) bool { return !t.hung }
+func (t *fakeToken) Done() <-chan struct{} {
+	c := make(chan struct{})
+	if !t.hung {
+		close(c)
+	}
+	return c
+}
+func (t *fakeToken) Error() error { return t.err }
+
+// fakeMQTTClient records published messages. Methods the sink doesn't use
+// are left to the embedded interface and panic if called.
+type fakeMQTTClient struct {
+	mqtt.Client
+	err          error
+	hung         bool
+	offline      bool
+	published    map[string]string
+	disconnected bool
+}
+
+func (c *fakeMQTTClient) IsConnectionOpen() bool {
+	return !c.offline
+}
+
+func (c *fakeMQTTClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	if c.published == nil {
+		c.published = map[string]string{}
+	}
+	c.published[topic] = payload.(string)
+	return &fakeToken{err: c.err, hung: c.hung}
+}
+
+func (c *fakeMQTTClient) Disconnect(quiesce uint) {
+	c.disconnected = true
+}
+
+func newTestMQTTSink(t *testing.T, cfg tomlConfigMQTT, client mqtt.Client) *mqttSink {
+	t.Helper()
+	s, err := newMQTTSinkWithClient(cfg, client)
+	if err != nil {
+		t.Fatalf("newMQTTSinkWithClient() error = %v", err)
+	}
+	return s
+}
+
+func TestMQTTSinkPublish(t *testing.T) {
+	client := &fakeMQTTClient{}
+	s := newTestMQTTSink(t, tomlConfigMQTT{TopicPrefix: "airquality"}, client)
+
+	status := &purpleAirStatus{Geo: "backyard", EPAAQI: 42, EPAAQICategory: "Good"}
+	status.A.EPAAQI = 40
+	status.B.EPAAQIColor = "Green"
+
+	if err := s.Publish(context.Background(), status); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	expected := map[string]string{
+		"airquality/backyard/EPAAQI":                 "42",
+		"airquality/backyard/EPAAQICategory":         "Good",
+		"airquality/backyard/sensor_A/epa_aqi":       "40",
+		"airquality/backyard/sensor_B/epa_aqi_color": "Green",
+	}
+	for topic, payload := range expected {
+		if got, ok := client.published[topic]; !ok || got != payload {
+			t.Errorf("topic %s = %q (published: %v), want %q", topic, got, ok, payload)
+		}
+	}
+	if _, ok := client.published["airquality/backyard/A"]; ok {
+		t.Errorf("channel struct A was published as a field")
+	}
+}
+
+func TestMQTTSinkPublishError(t *testing.T) {
+	client := &fakeMQTTClient{err: errors.New("not connected")}
+	s := newTestMQTTSink(t, tomlConfigMQTT{Topic: "backyard"}, client)
+
+	if err := s.Publish(context.Background(), &purpleAirStatus{}); err == nil {
+		t.Errorf("Publish() error = nil, want error from client")
+	}
+}
+
+func TestMQTTSinkPublishTimeout(t *testing.T) {
+	client := &fakeMQTTClient{hung: true}
+	s := newTestMQTTSink(t, tomlConfigMQTT{Topic: "backyard"}, client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := s.Publish(ctx, &purpleAirStatus{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Publish() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestQuiesce(t *testing.T) {
+	if got := quiesce(context.Background()); got != 250 {
+		t.Errorf("quiesce() without a deadline = %d, want 250", got)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if got := quiesce(ctx); got == 0 || got > 100 {
+		t.Errorf("quiesce() with 100ms left = %d, want at most 100", got)
+	}
+	cancel()
+	if got := quiesce(ctx); got != 0 {
+		t.Errorf("quiesce() once ctx is done = %d, want 0", got)
+	}
+}
+
+func TestMQTTSinkClose(t *testing.T) {
+	client := &fakeMQTTClient{}
+	s := newTestMQTTSink(t, tomlConfigMQTT{TopicPrefix: "airquality", ClientId: "purpleair2mqtt"}, client)
+
+	for _, geo := range []string{"backyard", "front yard", ""} {
+		if err := s.Publish(context.Background(), &purpleAirStatus{Geo: geo}); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+	availability := []string{"airquality/backyard/availability", "airquality/front_yard/availability", "airquality/availability"}
+	for _, topic := range availability {
+		if got := client.published[topic]; got != "online" {
+			t.Errorf("%s = %q after a reading, want online", topic, got)
+		}
+	}
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	for _, topic := range availability {
+		if got := client.published[topic]; got != "offline" {
+			t.Errorf("%s = %q, want offline", topic, got)
+		}
+	}
+	if !client.disconnected {
+		t.Errorf("Close() did not disconnect the client")
+	}
+}
+
+func TestMQTTSinkOffline(t *testing.T) {
+	tests := []struct {
+		name              string
+		cfg               tomlConfigMQTT
+		expectedErr       error
+		expectedPublished bool
+	}{
+		{"QoS 0", tomlConfigMQTT{Topic: "backyard"}, mqtt.ErrNotConnected, false},
+		{"QoS 1, clean session", tomlConfigMQTT{Topic: "backyard", Qos: 1}, mqtt.ErrNotConnected, false},
+		{"QoS 1, persistent session", tomlConfigMQTT{Topic: "backyard", Qos: 1, PersistentSession: true}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a hung token stands in for a message paho is holding until it reconnects
+			client := &fakeMQTTClient{offline: true, hung: true}
+			s := newTestMQTTSink(t, tt.cfg, client)
+
+			err := s.Publish(context.Background(), &purpleAirStatus{EPAAQI: 42})
+			if !errors.Is(err, tt.expectedErr) {
+				t.Errorf("Publish() error = %v, want %v", err, tt.expectedErr)
+			}
+			if _, ok := client.published["purpleair/backyard/EPAAQI"]; ok != tt.expectedPublished {
+				t.Errorf("published = %v, want %v", ok, tt.expectedPublished)
+			}
+		})
+	}
+}
+
+// fakeBroker is just enough of an MQTT broker for a paho client to connect
+// and publish at QoS 0 or 1.
+type fakeBroker struct {
+	ln          net.Listener
+	mu          sync.Mutex
+	published   map[string]string
+	will        string // topic of the last client's will
+	willPayload string
+}
+
+func startFakeBroker(t *testing.T, addr string) *fakeBroker {
+	t.Helper()
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{ln: ln, published: map[string]string{}}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	for {
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		var reply packets.ControlPacket
+		switch p := packet.(type) {
+		case *packets.ConnectPacket:
+			b.mu.Lock()
+			b.will, b.willPayload = p.WillTopic, string(p.WillMessage)
+			b.mu.Unlock()
+			reply = packets.NewControlPacket(packets.Connack)
+		case *packets.PublishPacket:
+			b.mu.Lock()
+			b.published[p.TopicName] = string(p.Payload)
+			b.mu.Unlock()
+			if p.Qos == 1 {
+				ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				ack.MessageID = p.MessageID
+				reply = ack
+			}
+		case *packets.PingreqPacket:
+			reply = packets.NewControlPacket(packets.Pingresp)
+		case *packets.DisconnectPacket:
+			return
+		}
+		if reply != nil {
+			if err := reply.Write(conn); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (b *fakeBroker) get(topic string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.published[topic]
+}
+
+func TestMQTTSinkBrokerStartsLate(t *testing.T) {
+	// reserve a port, then leave it closed until the broker "comes up"
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().(*net.TCPAddr)
+	_ = ln.Close()
+
+	s, err := newMQTTSink(tomlConfigMQTT{
+		BrokerHost:           "127.0.0.1",
+		BrokerPort:           addr.Port,
+		ClientId:             "purpleair2mqtt-test",
+		TopicPrefix:          "airquality",
+		Topic:                "backyard",
+		Qos:                  1,
+		KeepAlive:            30,
+		ConnectRetryInterval: 1,
+		MaxReconnectInterval: 1,
+		PersistentSession:    true,
+	}, tomlConfigHass{})
+	if err != nil {
+		t.Fatalf("newMQTTSink() error = %v", err)
+	}
+	defer func() { _ = s.Close(context.Background()) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Publish(ctx, &purpleAirStatus{EPAAQI: 42}); err != nil {
+		t.Fatalf("Publish() before the broker is up: error = %v, want the reading queued", err)
+	}
+
+	// let the first attempt fail, so the broker is only reached by a retry
+	time.Sleep(200 * time.Millisecond)
+	if s.client.IsConnectionOpen() {
+		t.Fatal("connected before the broker was started")
+	}
+	broker := startFakeBroker(t, addr.String())
+	waitFor(t, func() bool {
+		return broker.get("airquality/backyard/EPAAQI") == "42" &&
+			broker.get("airquality/backyard/availability") == availabilityOnline
+	})
+}
+
+func TestMQTTSinkSetsWillOnFirstReading(t *testing.T) {
+	broker := startFakeBroker(t, "127.0.0.1:0")
+	addr := broker.ln.Addr().(*net.TCPAddr)
+	s, err := newMQTTSink(tomlConfigMQTT{
+		BrokerHost: "127.0.0.1",
+		BrokerPort: addr.Port,
+		ClientId:   "purpleair2mqtt-test",
+	}, tomlConfigHass{})
+	if err != nil {
+		t.Fatalf("newMQTTSink() error = %v", err)
+	}
+	defer func() { _ = s.Close(context.Background()) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.waitConnected(ctx); err != nil {
+		t.Fatalf("didn't connect before the first reading: %v", err)
+	}
+	if err := s.Publish(ctx, &purpleAirStatus{Geo: "Back yard", EPAAQI: 42}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	broker.mu.Lock()
+	will := broker.will
+	broker.mu.Unlock()
+	if will != "purpleair/Back_yard/availability" {
+		t.Errorf("will topic = %q, want the sensor's availability topic", will)
+	}
+	waitFor(t, func() bool { return broker.get("purpleair/Back_yard/availability") == availabilityOnline })
+}
+
+func TestMQTTSinkFields(t *testing.T) {
+	client := &fakeMQTTClient{}
+	s := newTestMQTTSink(t, tomlConfigMQTT{
+		TopicPrefix: "fleet",
+		Topic:       "backyard",
+		Fields:      []string{"EPAAQI", "sensor_*/epa_aqi"},
+	}, client)
+	s.hass = tomlConfigHass{Discovery: true}
+
+	if err := s.Publish(context.Background(), &purpleAirStatus{SensorId: "84:f3:eb:00:00:01", EPAAQI: 42, PM25Atm: 10}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	var topics []string
+	for topic := range client.published {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	expected := []string{
+		"fleet/backyard/EPAAQI",
+		"fleet/backyard/availability",
+		"fleet/backyard/sensor_A/epa_aqi",
+		"fleet/backyard/sensor_B/epa_aqi",
+		"homeassistant/sensor/84f3eb000001/epa_aqi/config",
+	}
+	if strings.Join(topics, ",") != strings.Join(expected, ",") {
+		t.Errorf("published topics = %v, want %v", topics, expected)
+	}
+}
+
+func TestMQTTFanout(t *testing.T) {
+	tests := []struct {
+		name             string
+		primary          *fakeMQTTClient
+		mirror           *fakeMQTTClient
+		failover         *fakeMQTTClient
+		expectedFailover bool
+		expectedErr      string
+	}{
+		{"Primary up", &fakeMQTTClient{}, &fakeMQTTClient{}, &fakeMQTTClient{}, false, ""},
+		{"Primary failing", &fakeMQTTClient{err: errors.New("broker gone")}, &fakeMQTTClient{}, &fakeMQTTClient{}, true, ""},
+		{"Primary offline", &fakeMQTTClient{offline: true}, &fakeMQTTClient{}, &fakeMQTTClient{}, true, ""},
+		{"Primary and failover failing", &fakeMQTTClient{err: errors.New("broker gone")}, &fakeMQTTClient{}, &fakeMQTTClient{err: errors.New("also gone")}, true, "cloud: "},
+		{"Primary and mirror failing", &fakeMQTTClient{err: errors.New("broker gone")}, &fakeMQTTClient{err: errors.New("mirror gone")}, &fakeMQTTClient{}, true, "mirror: "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &mqttFanout{}
+			f.add(newTestMQTTSink(t, tomlConfigMQTT{Name: "local", Topic: "backyard"}, tt.primary))
+			f.add(newTestMQTTSink(t, tomlConfigMQTT{Name: "mirror", Topic: "backyard", Mode: mqttModeAlways}, tt.mirror))
+			f.add(newTestMQTTSink(t, tomlConfigMQTT{Name: "cloud", Topic: "backyard", Mode: mqttModeFailover}, tt.failover))
+
+			err := f.Publish(context.Background(), &purpleAirStatus{EPAAQI: 42})
+			if tt.expectedErr == "" && err != nil {
+				t.Errorf("Publish() error = %v, want nil", err)
+			} else if tt.expectedErr != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.expectedErr) || strings.Contains(err.Error(), "local: ")) {
+				t.Errorf("Publish() error = %v, want prefix %q and not the primary's", err, tt.expectedErr)
+			}
+			if got := tt.mirror.published["purpleair/backyard/EPAAQI"]; tt.mirror.err == nil && got != "42" {
+				t.Errorf("mirror EPAAQI = %q, want 42", got)
+			}
+			if ok := len(tt.failover.published) > 0; ok != tt.expectedFailover {
+				t.Errorf("published to failover = %v, want %v", ok, tt.expectedFailover)
+			}
+		})
+	}
+}
+
+func TestMQTTSinkTopicTemplate(t *testing.T) {
+	client := &fakeMQTTClient{}
+	s := newTestMQTTSink(t, tomlConfigMQTT{
+		TopicPrefix:   "aq",
+		TopicTemplate: "{prefix}/{sensor_id}/{channel}/{field}",
+		FieldStyle:    fieldStyleSnake,
+		Fields:        []string{"epa_aqi", "sensor_a/*"},
+	}, client)
+	s.hass = tomlConfigHass{Discovery: true}
+
+	status := &purpleAirStatus{SensorId: "84:f3:eb:00:00:01", Geo: "Back yard", EPAAQI: 42}
+	status.A.EPAAQI = 40
+	if err := s.Publish(context.Background(), status); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	for topic, payload := range map[string]string{
+		"aq/84:f3:eb:00:00:01/epa_aqi":          "42",
+		"aq/84:f3:eb:00:00:01/sensor_a/epa_aqi": "40",
+	} {
+		if got := client.published[topic]; got != payload {
+			t.Errorf("topic %s = %q, want %q", topic, got, payload)
+		}
+	}
+	if _, ok := client.published["aq/84:f3:eb:00:00:01/sensor_b/epa_aqi"]; ok {
+		t.Errorf("sensor_b was published, but isn't in fields")
+	}
+	config := client.published["homeassistant/sensor/84f3eb000001/epa_aqi/config"]
+	if !strings.Contains(config, `"state_topic":"aq/84:f3:eb:00:00:01/epa_aqi"`) {
+		t.Errorf("discovery config = %s, want state_topic from the template", config)
+	}
+}
+
+func TestMQTTSinkPayloadTemplates(t *testing.T) {
+	client := &fakeMQTTClient{}
+	s := newTestMQTTSink(t, tomlConfigMQTT{
+		Topic:           "backyard",
+		Fields:          []string{"PM25Atm", "sensor_A/epa_aqi"},
+		PayloadTemplate: `{{if eq .Field "PM25Atm"}}{{round 1 .Value}}{{else}}{{.Channel}}:{{.Value}}{{end}}`,
+		Topics:          map[string]string{"state": `{"pm25": {{round 1 .PM25Atm}}, "aqi": {{.EPAAQI}}}`},
+	}, client)
+
+	status := &purpleAirStatus{PM25Atm: 12.345679, EPAAQI: 57}
+	status.A.EPAAQI = 55
+	if err := s.Publish(context.Background(), status); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	expected := map[string]string{
+		"purpleair/backyard/PM25Atm":          "12.3",
+		"purpleair/backyard/availability":     "online",
+		"purpleair/backyard/sensor_A/epa_aqi": "sensor_A:55",
+		"purpleair/backyard/state":            `{"pm25": 12.3, "aqi": 57}`,
+	}
+	if len(client.published) != len(expected) {
+		t.Errorf("published %v, want %v", client.published, expected)
+	}
+	for topic, payload := range expected {
+		if got := client.published[topic]; got != payload {
+			t.Errorf("topic %s = %q, want %q", topic, got, payload)
+		}
+	}
+}
+
+func TestMQTTSinkTopicLayout(t *testing.T) {
+	tests := []struct {
+		layout            string
+		expectedPublished []string
+		expectedMissing   []string
+		expectedLegacy    bool
+	}{
+		{topicLayoutLegacy, []string{"purpleair/backyard/EPAAQI", "purpleair/backyard/sensor_A/epa_aqi"}, []string{"purpleair/backyard/epa_aqi"}, true},
+		{topicLayoutV2, []string{"purpleair/backyard/epa_aqi", "purpleair/backyard/sensor_a/epa_aqi"}, []string{"purpleair/backyard/EPAAQI"}, false},
+		{topicLayoutBoth, []string{"purpleair/backyard/EPAAQI", "purpleair/backyard/sensor_A/epa_aqi", "purpleair/backyard/epa_aqi", "purpleair/backyard/sensor_a/epa_aqi"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.layout, func(t *testing.T) {
+			client := &fakeMQTTClient{}
+			s := newTestMQTTSink(t, tomlConfigMQTT{TopicLayout: tt.layout}, client)
+
+			status := &purpleAirStatus{Geo: "backyard", EPAAQI: 42}
+			status.A.EPAAQI = 40
+			if err := s.Publish(context.Background(), status); err != nil {
+				t.Fatalf("Publish() error = %v", err)
+			}
+
+			for _, topic := range tt.expectedPublished {
+				if _, ok := client.published[topic]; !ok {
+					t.Errorf("topic %s wasn't published", topic)
+				}
+			}
+			for _, topic := range tt.expectedMissing {
+				if _, ok := client.published[topic]; ok {
+					t.Errorf("topic %s was published", topic)
+				}
+			}
+			if got := s.legacyWarned["/backyard"]; got != tt.expectedLegacy {
+				t.Errorf("legacy topics warned = %v, want %v", got, tt.expectedLegacy)
+			}
+			if len(s.legacyPublished) != 0 {
+				t.Errorf("legacy topics weren't reset after publishing: %v", s.legacyPublished)
+			}
+		})
+	}
+}
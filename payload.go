@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// payloadFuncs are the helpers available to payload templates, for MQTT
+// messages and webhook bodies alike. Numeric helpers take the value last, so
+// they chain: {{.Temperature | convert "F" "C" | round 1}}.
+var payloadFuncs = template.FuncMap{
+	"round":         roundValue,
+	"convert":       convertUnit,
+	"toJSON":        toJSON,
+	"categoryColor": categoryColor,
+}
+
+// newPayloadTemplate parses a payload template with the helpers available.
+func newPayloadTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(payloadFuncs).Parse(text)
+}
+
+// executePayload renders t with data.
+func executePayload(t *template.Template, data interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// payloadFloat converts any numeric reading value, including the optional
+// ones, to a float64.
+func payloadFloat(v interface{}) (float64, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return 0, fmt.Errorf("value is missing")
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), nil
+	case reflect.Float32:
+		// via its shortest decimal form, so 12.3 doesn't become 12.300000190734863
+		return strconv.ParseFloat(strconv.FormatFloat(rv.Float(), 'g', -1, 32), 64)
+	case reflect.Float64:
+		return rv.Float(), nil
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
+
+// roundValue rounds v to the given number of decimal places.
+func roundValue(places int, v interface{}) (float64, error) {
+	f, err := payloadFloat(v)
+	if err != nil {
+		return 0, err
+	}
+	scale := math.Pow(10, float64(places))
+	return math.Round(f*scale) / scale, nil
+}
+
+// unitConversions convert to and from a base unit for each kind of quantity:
+// kelvin for temperatures and hectopascals for pressures.
+var unitConversions = map[string]struct {
+	kind     string
+	toBase   func(float64) float64
+	fromBase func(float64) float64
+}{
+	"C":    {"temperature", func(v float64) float64 { return v + 273.15 }, func(v float64) float64 { return v - 273.15 }},
+	"F":    {"temperature", func(v float64) float64 { return (v-32)*5/9 + 273.15 }, func(v float64) float64 { return (v-273.15)*9/5 + 32 }},
+	"K":    {"temperature", func(v float64) float64 { return v }, func(v float64) float64 { return v }},
+	"hPa":  {"pressure", func(v float64) float64 { return v }, func(v float64) float64 { return v }},
+	"Pa":   {"pressure", func(v float64) float64 { return v / 100 }, func(v float64) float64 { return v * 100 }},
+	"kPa":  {"pressure", func(v float64) float64 { return v * 10 }, func(v float64) float64 { return v / 10 }},
+	"inHg": {"pressure", func(v float64) float64 { return v * 33.8639 }, func(v float64) float64 { return v / 33.8639 }},
+	"mmHg": {"pressure", func(v float64) float64 { return v * 1.33322 }, func(v float64) float64 { return v / 1.33322 }},
+}
+
+// convertUnit converts v between units of temperature (C, F, K) or pressure
+// (hPa, Pa, kPa, inHg, mmHg).
+func convertUnit(from, to string, v interface{}) (float64, error) {
+	f, err := payloadFloat(v)
+	if err != nil {
+		return 0, err
+	}
+	src, ok := unitConversions[from]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", from)
+	}
+	dst, ok := unitConversions[to]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", to)
+	}
+	if src.kind != dst.kind {
+		return 0, fmt.Errorf("can't convert %s (%s) to %s (%s)", from, src.kind, to, dst.kind)
+	}
+	return dst.fromBase(src.toBase(f)), nil
+}
+
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// categoryColor returns the hex color, e.g. #00e400, of a US EPA AQI
+// category, given either its name or an AQI value.
+func categoryColor(v interface{}) (string, error) {
+	var rgb string
+	if name, ok := v.(string); ok {
+		for _, c := range aqiCategories {
+			if strings.EqualFold(c.Category, name) {
+				rgb = c.ColorRGB
+			}
+		}
+		if rgb == "" {
+			return "", fmt.Errorf("unknown AQI category %q", name)
+		}
+	} else {
+		aqi, err := payloadFloat(v)
+		if err != nil {
+			return "", err
+		}
+		rgb = aqiCategories[len(aqiCategories)-1].ColorRGB
+		for _, c := range aqiCategories {
+			if aqi <= float64(c.Threshold) {
+				rgb = c.ColorRGB
+				break
+			}
+		}
+	}
+	var r, g, b int
+	if _, err := fmt.Sscanf(rgb, "rgb(%d,%d,%d)", &r, &g, &b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("#%02x%02x%02x", r, g, b), nil
+}
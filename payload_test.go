@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestPayloadTemplate(t *testing.T) {
+	gas := float32(152.7)
+	status := &purpleAirStatus{
+		Geo:            `Back "yard"`,
+		Temperature:    77,
+		Pressure:       1013.25,
+		PM25Atm:        12.345679,
+		Gas680:         &gas,
+		EPAAQI:         120,
+		EPAAQICategory: "Unhealthy for Sensitive Groups",
+	}
+	tests := []struct {
+		name          string
+		template      string
+		expected      string
+		expectedError bool
+	}{
+		{"Round", `{{round 1 .PM25Atm}}`, "12.3", false},
+		{"Round to integer", `{{.PM25Atm | round 0}}`, "12", false},
+		{"Round optional field", `{{round 0 .Gas680}}`, "153", false},
+		{"Temperature", `{{.Temperature | convert "F" "C" | round 1}}`, "25", false},
+		{"Pressure", `{{.Pressure | convert "hPa" "inHg" | round 2}}`, "29.92", false},
+		{"Color of category", `{{categoryColor .EPAAQICategory}}`, "#ff7e00", false},
+		{"Color of AQI", `{{categoryColor 350}}`, "#7e0023", false},
+		{"JSON", `{"geo": {{toJSON .Geo}}, "pm25": {{round 1 .PM25Atm}}}`, `{"geo": "Back \"yard\"", "pm25": 12.3}`, false},
+		{"Different kinds of unit", `{{convert "F" "hPa" .Temperature}}`, "", true},
+		{"Not a number", `{{round 1 .Geo}}`, "", true},
+		{"Missing optional field", `{{round 1 .Humidity680}}`, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := newPayloadTemplate("test", tt.template)
+			if err != nil {
+				t.Fatalf("newPayloadTemplate() error = %v", err)
+			}
+			got, err := executePayload(tmpl, status)
+			if (err != nil) != tt.expectedError {
+				t.Fatalf("executePayload() error = %v, want error: %v", err, tt.expectedError)
+			}
+			if got != tt.expected && !tt.expectedError {
+				t.Errorf("executePayload() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
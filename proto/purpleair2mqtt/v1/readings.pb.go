@@ -0,0 +1,984 @@
+// The gRPC API purpleair2mqtt serves when [grpc] is configured. The Go code
+// next to this file is generated from it with `make proto`; generate clients
+// from it as usual.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        (unknown)
+// source: purpleair2mqtt/v1/readings.proto
+
+package purpleair2mqttv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type AQICategory int32
+
+const (
+	AQICategory_AQI_CATEGORY_UNSPECIFIED                    AQICategory = 0
+	AQICategory_AQI_CATEGORY_GOOD                           AQICategory = 1
+	AQICategory_AQI_CATEGORY_MODERATE                       AQICategory = 2
+	AQICategory_AQI_CATEGORY_UNHEALTHY_FOR_SENSITIVE_GROUPS AQICategory = 3
+	AQICategory_AQI_CATEGORY_UNHEALTHY                      AQICategory = 4
+	AQICategory_AQI_CATEGORY_VERY_UNHEALTHY                 AQICategory = 5
+	AQICategory_AQI_CATEGORY_HAZARDOUS                      AQICategory = 6
+)
+
+// Enum value maps for AQICategory.
+var (
+	AQICategory_name = map[int32]string{
+		0: "AQI_CATEGORY_UNSPECIFIED",
+		1: "AQI_CATEGORY_GOOD",
+		2: "AQI_CATEGORY_MODERATE",
+		3: "AQI_CATEGORY_UNHEALTHY_FOR_SENSITIVE_GROUPS",
+		4: "AQI_CATEGORY_UNHEALTHY",
+		5: "AQI_CATEGORY_VERY_UNHEALTHY",
+		6: "AQI_CATEGORY_HAZARDOUS",
+	}
+	AQICategory_value = map[string]int32{
+		"AQI_CATEGORY_UNSPECIFIED":                    0,
+		"AQI_CATEGORY_GOOD":                           1,
+		"AQI_CATEGORY_MODERATE":                       2,
+		"AQI_CATEGORY_UNHEALTHY_FOR_SENSITIVE_GROUPS": 3,
+		"AQI_CATEGORY_UNHEALTHY":                      4,
+		"AQI_CATEGORY_VERY_UNHEALTHY":                 5,
+		"AQI_CATEGORY_HAZARDOUS":                      6,
+	}
+)
+
+func (x AQICategory) Enum() *AQICategory {
+	p := new(AQICategory)
+	*p = x
+	return p
+}
+
+func (x AQICategory) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (AQICategory) Descriptor() protoreflect.EnumDescriptor {
+	return file_purpleair2mqtt_v1_readings_proto_enumTypes[0].Descriptor()
+}
+
+func (AQICategory) Type() protoreflect.EnumType {
+	return &file_purpleair2mqtt_v1_readings_proto_enumTypes[0]
+}
+
+func (x AQICategory) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use AQICategory.Descriptor instead.
+func (AQICategory) EnumDescriptor() ([]byte, []int) {
+	return file_purpleair2mqtt_v1_readings_proto_rawDescGZIP(), []int{0}
+}
+
+type GetLatestRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The sensor's MAC address or name; may be empty when the bridge reads a
+	// single sensor.
+	SensorId      string `protobuf:"bytes,1,opt,name=sensor_id,json=sensorId,proto3" json:"sensor_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetLatestRequest) Reset() {
+	*x = GetLatestRequest{}
+	mi := &file_purpleair2mqtt_v1_readings_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetLatestRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetLatestRequest) ProtoMessage() {}
+
+func (x *GetLatestRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_purpleair2mqtt_v1_readings_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetLatestRequest.ProtoReflect.Descriptor instead.
+func (*GetLatestRequest) Descriptor() ([]byte, []int) {
+	return file_purpleair2mqtt_v1_readings_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *GetLatestRequest) GetSensorId() string {
+	if x != nil {
+		return x.SensorId
+	}
+	return ""
+}
+
+type ReadingFilter struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// MAC addresses or names of the sensors to stream; every sensor if empty.
+	SensorIds []string `protobuf:"bytes,1,rep,name=sensor_ids,json=sensorIds,proto3" json:"sensor_ids,omitempty"`
+	// Only stream readings whose US EPA AQI is at least this.
+	MinEpaAqi int32 `protobuf:"varint,2,opt,name=min_epa_aqi,json=minEpaAqi,proto3" json:"min_epa_aqi,omitempty"`
+	// Start with the latest reading of each matching sensor, rather than
+	// waiting for the next.
+	IncludeLatest bool `protobuf:"varint,3,opt,name=include_latest,json=includeLatest,proto3" json:"include_latest,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReadingFilter) Reset() {
+	*x = ReadingFilter{}
+	mi := &file_purpleair2mqtt_v1_readings_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReadingFilter) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReadingFilter) ProtoMessage() {}
+
+func (x *ReadingFilter) ProtoReflect() protoreflect.Message {
+	mi := &file_purpleair2mqtt_v1_readings_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReadingFilter.ProtoReflect.Descriptor instead.
+func (*ReadingFilter) Descriptor() ([]byte, []int) {
+	return file_purpleair2mqtt_v1_readings_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ReadingFilter) GetSensorIds() []string {
+	if x != nil {
+		return x.SensorIds
+	}
+	return nil
+}
+
+func (x *ReadingFilter) GetMinEpaAqi() int32 {
+	if x != nil {
+		return x.MinEpaAqi
+	}
+	return 0
+}
+
+func (x *ReadingFilter) GetIncludeLatest() bool {
+	if x != nil {
+		return x.IncludeLatest
+	}
+	return false
+}
+
+type Reading struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	SensorId    string                 `protobuf:"bytes,1,opt,name=sensor_id,json=sensorId,proto3" json:"sensor_id,omitempty"` // MAC address, e.g. 84:f3:eb:7b:c8:ee
+	Name        string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	ReceivedAt  *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=received_at,json=receivedAt,proto3" json:"received_at,omitempty"` // when the bridge took the reading
+	Device      *Device                `protobuf:"bytes,4,opt,name=device,proto3" json:"device,omitempty"`
+	Environment *Environment           `protobuf:"bytes,5,opt,name=environment,proto3" json:"environment,omitempty"`
+	// Overall particulate readings: channel A's on single-channel sensors.
+	Particulates  *Particulates `protobuf:"bytes,6,opt,name=particulates,proto3" json:"particulates,omitempty"`
+	EpaAqi        *AQI          `protobuf:"bytes,7,opt,name=epa_aqi,json=epaAqi,proto3" json:"epa_aqi,omitempty"`
+	ChannelA      *Monitor      `protobuf:"bytes,8,opt,name=channel_a,json=channelA,proto3" json:"channel_a,omitempty"`
+	ChannelB      *Monitor      `protobuf:"bytes,9,opt,name=channel_b,json=channelB,proto3" json:"channel_b,omitempty"` // unset when channel B reports nothing, as on sensors with one laser counter
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Reading) Reset() {
+	*x = Reading{}
+	mi := &file_purpleair2mqtt_v1_readings_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Reading) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Reading) ProtoMessage() {}
+
+func (x *Reading) ProtoReflect() protoreflect.Message {
+	mi := &file_purpleair2mqtt_v1_readings_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Reading.ProtoReflect.Descriptor instead.
+func (*Reading) Descriptor() ([]byte, []int) {
+	return file_purpleair2mqtt_v1_readings_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Reading) GetSensorId() string {
+	if x != nil {
+		return x.SensorId
+	}
+	return ""
+}
+
+func (x *Reading) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Reading) GetReceivedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ReceivedAt
+	}
+	return nil
+}
+
+func (x *Reading) GetDevice() *Device {
+	if x != nil {
+		return x.Device
+	}
+	return nil
+}
+
+func (x *Reading) GetEnvironment() *Environment {
+	if x != nil {
+		return x.Environment
+	}
+	return nil
+}
+
+func (x *Reading) GetParticulates() *Particulates {
+	if x != nil {
+		return x.Particulates
+	}
+	return nil
+}
+
+func (x *Reading) GetEpaAqi() *AQI {
+	if x != nil {
+		return x.EpaAqi
+	}
+	return nil
+}
+
+func (x *Reading) GetChannelA() *Monitor {
+	if x != nil {
+		return x.ChannelA
+	}
+	return nil
+}
+
+func (x *Reading) GetChannelB() *Monitor {
+	if x != nil {
+		return x.ChannelB
+	}
+	return nil
+}
+
+type Device struct {
+	state              protoimpl.MessageState `protogen:"open.v1"`
+	DateTime           string                 `protobuf:"bytes,1,opt,name=date_time,json=dateTime,proto3" json:"date_time,omitempty"` // UTC time on the device, as it reports it
+	FirmwareVersion    string                 `protobuf:"bytes,2,opt,name=firmware_version,json=firmwareVersion,proto3" json:"firmware_version,omitempty"`
+	HardwareRevision   string                 `protobuf:"bytes,3,opt,name=hardware_revision,json=hardwareRevision,proto3" json:"hardware_revision,omitempty"`
+	HardwareDiscovered string                 `protobuf:"bytes,4,opt,name=hardware_discovered,json=hardwareDiscovered,proto3" json:"hardware_discovered,omitempty"`
+	UptimeSeconds      int64                  `protobuf:"varint,5,opt,name=uptime_seconds,json=uptimeSeconds,proto3" json:"uptime_seconds,omitempty"`
+	Rssi               int32                  `protobuf:"varint,6,opt,name=rssi,proto3" json:"rssi,omitempty"` // Wi-Fi signal strength, dBm
+	Latitude           float32                `protobuf:"fixed32,7,opt,name=latitude,proto3" json:"latitude,omitempty"`
+	Longitude          float32                `protobuf:"fixed32,8,opt,name=longitude,proto3" json:"longitude,omitempty"`
+	Place              string                 `protobuf:"bytes,9,opt,name=place,proto3" json:"place,omitempty"` // inside or outside
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
+}
+
+func (x *Device) Reset() {
+	*x = Device{}
+	mi := &file_purpleair2mqtt_v1_readings_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Device) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Device) ProtoMessage() {}
+
+func (x *Device) ProtoReflect() protoreflect.Message {
+	mi := &file_purpleair2mqtt_v1_readings_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Device.ProtoReflect.Descriptor instead.
+func (*Device) Descriptor() ([]byte, []int) {
+	return file_purpleair2mqtt_v1_readings_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Device) GetDateTime() string {
+	if x != nil {
+		return x.DateTime
+	}
+	return ""
+}
+
+func (x *Device) GetFirmwareVersion() string {
+	if x != nil {
+		return x.FirmwareVersion
+	}
+	return ""
+}
+
+func (x *Device) GetHardwareRevision() string {
+	if x != nil {
+		return x.HardwareRevision
+	}
+	return ""
+}
+
+func (x *Device) GetHardwareDiscovered() string {
+	if x != nil {
+		return x.HardwareDiscovered
+	}
+	return ""
+}
+
+func (x *Device) GetUptimeSeconds() int64 {
+	if x != nil {
+		return x.UptimeSeconds
+	}
+	return 0
+}
+
+func (x *Device) GetRssi() int32 {
+	if x != nil {
+		return x.Rssi
+	}
+	return 0
+}
+
+func (x *Device) GetLatitude() float32 {
+	if x != nil {
+		return x.Latitude
+	}
+	return 0
+}
+
+func (x *Device) GetLongitude() float32 {
+	if x != nil {
+		return x.Longitude
+	}
+	return 0
+}
+
+func (x *Device) GetPlace() string {
+	if x != nil {
+		return x.Place
+	}
+	return ""
+}
+
+type Environment struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	TemperatureF int32                  `protobuf:"varint,1,opt,name=temperature_f,json=temperatureF,proto3" json:"temperature_f,omitempty"`
+	Humidity     int32                  `protobuf:"varint,2,opt,name=humidity,proto3" json:"humidity,omitempty"` // percent
+	DewpointF    int32                  `protobuf:"varint,3,opt,name=dewpoint_f,json=dewpointF,proto3" json:"dewpoint_f,omitempty"`
+	PressureHpa  float32                `protobuf:"fixed32,4,opt,name=pressure_hpa,json=pressureHpa,proto3" json:"pressure_hpa,omitempty"`
+	// BME680/688 readings, only set on sensors that have one.
+	Temperature_680F *float32 `protobuf:"fixed32,5,opt,name=temperature_680_f,json=temperature680F,proto3,oneof" json:"temperature_680_f,omitempty"`
+	Humidity_680     *float32 `protobuf:"fixed32,6,opt,name=humidity_680,json=humidity680,proto3,oneof" json:"humidity_680,omitempty"`
+	Dewpoint_680F    *float32 `protobuf:"fixed32,7,opt,name=dewpoint_680_f,json=dewpoint680F,proto3,oneof" json:"dewpoint_680_f,omitempty"`
+	Pressure_680Hpa  *float32 `protobuf:"fixed32,8,opt,name=pressure_680_hpa,json=pressure680Hpa,proto3,oneof" json:"pressure_680_hpa,omitempty"`
+	Gas_680Kohm      *float32 `protobuf:"fixed32,9,opt,name=gas_680_kohm,json=gas680Kohm,proto3,oneof" json:"gas_680_kohm,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *Environment) Reset() {
+	*x = Environment{}
+	mi := &file_purpleair2mqtt_v1_readings_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Environment) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Environment) ProtoMessage() {}
+
+func (x *Environment) ProtoReflect() protoreflect.Message {
+	mi := &file_purpleair2mqtt_v1_readings_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Environment.ProtoReflect.Descriptor instead.
+func (*Environment) Descriptor() ([]byte, []int) {
+	return file_purpleair2mqtt_v1_readings_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Environment) GetTemperatureF() int32 {
+	if x != nil {
+		return x.TemperatureF
+	}
+	return 0
+}
+
+func (x *Environment) GetHumidity() int32 {
+	if x != nil {
+		return x.Humidity
+	}
+	return 0
+}
+
+func (x *Environment) GetDewpointF() int32 {
+	if x != nil {
+		return x.DewpointF
+	}
+	return 0
+}
+
+func (x *Environment) GetPressureHpa() float32 {
+	if x != nil {
+		return x.PressureHpa
+	}
+	return 0
+}
+
+func (x *Environment) GetTemperature_680F() float32 {
+	if x != nil && x.Temperature_680F != nil {
+		return *x.Temperature_680F
+	}
+	return 0
+}
+
+func (x *Environment) GetHumidity_680() float32 {
+	if x != nil && x.Humidity_680 != nil {
+		return *x.Humidity_680
+	}
+	return 0
+}
+
+func (x *Environment) GetDewpoint_680F() float32 {
+	if x != nil && x.Dewpoint_680F != nil {
+		return *x.Dewpoint_680F
+	}
+	return 0
+}
+
+func (x *Environment) GetPressure_680Hpa() float32 {
+	if x != nil && x.Pressure_680Hpa != nil {
+		return *x.Pressure_680Hpa
+	}
+	return 0
+}
+
+func (x *Environment) GetGas_680Kohm() float32 {
+	if x != nil && x.Gas_680Kohm != nil {
+		return *x.Gas_680Kohm
+	}
+	return 0
+}
+
+// Concentrations in µg/m³ and particle counts per deciliter.
+type Particulates struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pm1_0Cf1      float32                `protobuf:"fixed32,1,opt,name=pm1_0_cf1,json=pm10Cf1,proto3" json:"pm1_0_cf1,omitempty"`
+	Pm2_5Cf1      float32                `protobuf:"fixed32,2,opt,name=pm2_5_cf1,json=pm25Cf1,proto3" json:"pm2_5_cf1,omitempty"`
+	Pm10_0Cf1     float32                `protobuf:"fixed32,3,opt,name=pm10_0_cf1,json=pm100Cf1,proto3" json:"pm10_0_cf1,omitempty"`
+	Pm1_0Atm      float32                `protobuf:"fixed32,4,opt,name=pm1_0_atm,json=pm10Atm,proto3" json:"pm1_0_atm,omitempty"`
+	Pm2_5Atm      float32                `protobuf:"fixed32,5,opt,name=pm2_5_atm,json=pm25Atm,proto3" json:"pm2_5_atm,omitempty"`
+	Pm10_0Atm     float32                `protobuf:"fixed32,6,opt,name=pm10_0_atm,json=pm100Atm,proto3" json:"pm10_0_atm,omitempty"`
+	P_0_3Um       float32                `protobuf:"fixed32,7,opt,name=p_0_3_um,json=p03Um,proto3" json:"p_0_3_um,omitempty"`
+	P_0_5Um       float32                `protobuf:"fixed32,8,opt,name=p_0_5_um,json=p05Um,proto3" json:"p_0_5_um,omitempty"`
+	P_1_0Um       float32                `protobuf:"fixed32,9,opt,name=p_1_0_um,json=p10Um,proto3" json:"p_1_0_um,omitempty"`
+	P_2_5Um       float32                `protobuf:"fixed32,10,opt,name=p_2_5_um,json=p25Um,proto3" json:"p_2_5_um,omitempty"`
+	P_5_0Um       float32                `protobuf:"fixed32,11,opt,name=p_5_0_um,json=p50Um,proto3" json:"p_5_0_um,omitempty"`
+	P_10_0Um      float32                `protobuf:"fixed32,12,opt,name=p_10_0_um,json=p100Um,proto3" json:"p_10_0_um,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Particulates) Reset() {
+	*x = Particulates{}
+	mi := &file_purpleair2mqtt_v1_readings_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Particulates) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Particulates) ProtoMessage() {}
+
+func (x *Particulates) ProtoReflect() protoreflect.Message {
+	mi := &file_purpleair2mqtt_v1_readings_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Particulates.ProtoReflect.Descriptor instead.
+func (*Particulates) Descriptor() ([]byte, []int) {
+	return file_purpleair2mqtt_v1_readings_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Particulates) GetPm1_0Cf1() float32 {
+	if x != nil {
+		return x.Pm1_0Cf1
+	}
+	return 0
+}
+
+func (x *Particulates) GetPm2_5Cf1() float32 {
+	if x != nil {
+		return x.Pm2_5Cf1
+	}
+	return 0
+}
+
+func (x *Particulates) GetPm10_0Cf1() float32 {
+	if x != nil {
+		return x.Pm10_0Cf1
+	}
+	return 0
+}
+
+func (x *Particulates) GetPm1_0Atm() float32 {
+	if x != nil {
+		return x.Pm1_0Atm
+	}
+	return 0
+}
+
+func (x *Particulates) GetPm2_5Atm() float32 {
+	if x != nil {
+		return x.Pm2_5Atm
+	}
+	return 0
+}
+
+func (x *Particulates) GetPm10_0Atm() float32 {
+	if x != nil {
+		return x.Pm10_0Atm
+	}
+	return 0
+}
+
+func (x *Particulates) GetP_0_3Um() float32 {
+	if x != nil {
+		return x.P_0_3Um
+	}
+	return 0
+}
+
+func (x *Particulates) GetP_0_5Um() float32 {
+	if x != nil {
+		return x.P_0_5Um
+	}
+	return 0
+}
+
+func (x *Particulates) GetP_1_0Um() float32 {
+	if x != nil {
+		return x.P_1_0Um
+	}
+	return 0
+}
+
+func (x *Particulates) GetP_2_5Um() float32 {
+	if x != nil {
+		return x.P_2_5Um
+	}
+	return 0
+}
+
+func (x *Particulates) GetP_5_0Um() float32 {
+	if x != nil {
+		return x.P_5_0Um
+	}
+	return 0
+}
+
+func (x *Particulates) GetP_10_0Um() float32 {
+	if x != nil {
+		return x.P_10_0Um
+	}
+	return 0
+}
+
+// The US EPA AQI, computed by the bridge from PM2.5 and PM10.
+type AQI struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Aqi           int32                  `protobuf:"varint,1,opt,name=aqi,proto3" json:"aqi,omitempty"` // the higher of pm2_5_aqi and pm10_aqi
+	Pm2_5Aqi      int32                  `protobuf:"varint,2,opt,name=pm2_5_aqi,json=pm25Aqi,proto3" json:"pm2_5_aqi,omitempty"`
+	Pm10Aqi       int32                  `protobuf:"varint,3,opt,name=pm10_aqi,json=pm10Aqi,proto3" json:"pm10_aqi,omitempty"`
+	Category      AQICategory            `protobuf:"varint,4,opt,name=category,proto3,enum=purpleair2mqtt.v1.AQICategory" json:"category,omitempty"`
+	CategoryName  string                 `protobuf:"bytes,5,opt,name=category_name,json=categoryName,proto3" json:"category_name,omitempty"` // e.g. Unhealthy for Sensitive Groups
+	Color         string                 `protobuf:"bytes,6,opt,name=color,proto3" json:"color,omitempty"`                                   // e.g. Orange
+	ColorRgb      string                 `protobuf:"bytes,7,opt,name=color_rgb,json=colorRgb,proto3" json:"color_rgb,omitempty"`             // e.g. rgb(255,126,0)
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AQI) Reset() {
+	*x = AQI{}
+	mi := &file_purpleair2mqtt_v1_readings_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AQI) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AQI) ProtoMessage() {}
+
+func (x *AQI) ProtoReflect() protoreflect.Message {
+	mi := &file_purpleair2mqtt_v1_readings_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AQI.ProtoReflect.Descriptor instead.
+func (*AQI) Descriptor() ([]byte, []int) {
+	return file_purpleair2mqtt_v1_readings_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *AQI) GetAqi() int32 {
+	if x != nil {
+		return x.Aqi
+	}
+	return 0
+}
+
+func (x *AQI) GetPm2_5Aqi() int32 {
+	if x != nil {
+		return x.Pm2_5Aqi
+	}
+	return 0
+}
+
+func (x *AQI) GetPm10Aqi() int32 {
+	if x != nil {
+		return x.Pm10Aqi
+	}
+	return 0
+}
+
+func (x *AQI) GetCategory() AQICategory {
+	if x != nil {
+		return x.Category
+	}
+	return AQICategory_AQI_CATEGORY_UNSPECIFIED
+}
+
+func (x *AQI) GetCategoryName() string {
+	if x != nil {
+		return x.CategoryName
+	}
+	return ""
+}
+
+func (x *AQI) GetColor() string {
+	if x != nil {
+		return x.Color
+	}
+	return ""
+}
+
+func (x *AQI) GetColorRgb() string {
+	if x != nil {
+		return x.ColorRgb
+	}
+	return ""
+}
+
+// One of the sensor's laser counters.
+type Monitor struct {
+	state                  protoimpl.MessageState `protogen:"open.v1"`
+	Particulates           *Particulates          `protobuf:"bytes,1,opt,name=particulates,proto3" json:"particulates,omitempty"`
+	EpaAqi                 *AQI                   `protobuf:"bytes,2,opt,name=epa_aqi,json=epaAqi,proto3" json:"epa_aqi,omitempty"`
+	PurpleairPm2_5Aqi      int32                  `protobuf:"varint,3,opt,name=purpleair_pm2_5_aqi,json=purpleairPm25Aqi,proto3" json:"purpleair_pm2_5_aqi,omitempty"` // the AQI the sensor computes itself
+	PurpleairPm2_5AqiColor string                 `protobuf:"bytes,4,opt,name=purpleair_pm2_5_aqi_color,json=purpleairPm25AqiColor,proto3" json:"purpleair_pm2_5_aqi_color,omitempty"`
+	unknownFields          protoimpl.UnknownFields
+	sizeCache              protoimpl.SizeCache
+}
+
+func (x *Monitor) Reset() {
+	*x = Monitor{}
+	mi := &file_purpleair2mqtt_v1_readings_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Monitor) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Monitor) ProtoMessage() {}
+
+func (x *Monitor) ProtoReflect() protoreflect.Message {
+	mi := &file_purpleair2mqtt_v1_readings_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Monitor.ProtoReflect.Descriptor instead.
+func (*Monitor) Descriptor() ([]byte, []int) {
+	return file_purpleair2mqtt_v1_readings_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Monitor) GetParticulates() *Particulates {
+	if x != nil {
+		return x.Particulates
+	}
+	return nil
+}
+
+func (x *Monitor) GetEpaAqi() *AQI {
+	if x != nil {
+		return x.EpaAqi
+	}
+	return nil
+}
+
+func (x *Monitor) GetPurpleairPm2_5Aqi() int32 {
+	if x != nil {
+		return x.PurpleairPm2_5Aqi
+	}
+	return 0
+}
+
+func (x *Monitor) GetPurpleairPm2_5AqiColor() string {
+	if x != nil {
+		return x.PurpleairPm2_5AqiColor
+	}
+	return ""
+}
+
+var File_purpleair2mqtt_v1_readings_proto protoreflect.FileDescriptor
+
+const file_purpleair2mqtt_v1_readings_proto_rawDesc = "" +
+	"\n" +
+	" purpleair2mqtt/v1/readings.proto\x12\x11purpleair2mqtt.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"/\n" +
+	"\x10GetLatestRequest\x12\x1b\n" +
+	"\tsensor_id\x18\x01 \x01(\tR\bsensorId\"u\n" +
+	"\rReadingFilter\x12\x1d\n" +
+	"\n" +
+	"sensor_ids\x18\x01 \x03(\tR\tsensorIds\x12\x1e\n" +
+	"\vmin_epa_aqi\x18\x02 \x01(\x05R\tminEpaAqi\x12%\n" +
+	"\x0einclude_latest\x18\x03 \x01(\bR\rincludeLatest\"\xd4\x03\n" +
+	"\aReading\x12\x1b\n" +
+	"\tsensor_id\x18\x01 \x01(\tR\bsensorId\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12;\n" +
+	"\vreceived_at\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"receivedAt\x121\n" +
+	"\x06device\x18\x04 \x01(\v2\x19.purpleair2mqtt.v1.DeviceR\x06device\x12@\n" +
+	"\venvironment\x18\x05 \x01(\v2\x1e.purpleair2mqtt.v1.EnvironmentR\venvironment\x12C\n" +
+	"\fparticulates\x18\x06 \x01(\v2\x1f.purpleair2mqtt.v1.ParticulatesR\fparticulates\x12/\n" +
+	"\aepa_aqi\x18\a \x01(\v2\x16.purpleair2mqtt.v1.AQIR\x06epaAqi\x127\n" +
+	"\tchannel_a\x18\b \x01(\v2\x1a.purpleair2mqtt.v1.MonitorR\bchannelA\x127\n" +
+	"\tchannel_b\x18\t \x01(\v2\x1a.purpleair2mqtt.v1.MonitorR\bchannelB\"\xb9\x02\n" +
+	"\x06Device\x12\x1b\n" +
+	"\tdate_time\x18\x01 \x01(\tR\bdateTime\x12)\n" +
+	"\x10firmware_version\x18\x02 \x01(\tR\x0ffirmwareVersion\x12+\n" +
+	"\x11hardware_revision\x18\x03 \x01(\tR\x10hardwareRevision\x12/\n" +
+	"\x13hardware_discovered\x18\x04 \x01(\tR\x12hardwareDiscovered\x12%\n" +
+	"\x0euptime_seconds\x18\x05 \x01(\x03R\ruptimeSeconds\x12\x12\n" +
+	"\x04rssi\x18\x06 \x01(\x05R\x04rssi\x12\x1a\n" +
+	"\blatitude\x18\a \x01(\x02R\blatitude\x12\x1c\n" +
+	"\tlongitude\x18\b \x01(\x02R\tlongitude\x12\x14\n" +
+	"\x05place\x18\t \x01(\tR\x05place\"\xca\x03\n" +
+	"\vEnvironment\x12#\n" +
+	"\rtemperature_f\x18\x01 \x01(\x05R\ftemperatureF\x12\x1a\n" +
+	"\bhumidity\x18\x02 \x01(\x05R\bhumidity\x12\x1d\n" +
+	"\n" +
+	"dewpoint_f\x18\x03 \x01(\x05R\tdewpointF\x12!\n" +
+	"\fpressure_hpa\x18\x04 \x01(\x02R\vpressureHpa\x12/\n" +
+	"\x11temperature_680_f\x18\x05 \x01(\x02H\x00R\x0ftemperature680F\x88\x01\x01\x12&\n" +
+	"\fhumidity_680\x18\x06 \x01(\x02H\x01R\vhumidity680\x88\x01\x01\x12)\n" +
+	"\x0edewpoint_680_f\x18\a \x01(\x02H\x02R\fdewpoint680F\x88\x01\x01\x12-\n" +
+	"\x10pressure_680_hpa\x18\b \x01(\x02H\x03R\x0epressure680Hpa\x88\x01\x01\x12%\n" +
+	"\fgas_680_kohm\x18\t \x01(\x02H\x04R\n" +
+	"gas680Kohm\x88\x01\x01B\x14\n" +
+	"\x12_temperature_680_fB\x0f\n" +
+	"\r_humidity_680B\x11\n" +
+	"\x0f_dewpoint_680_fB\x13\n" +
+	"\x11_pressure_680_hpaB\x0f\n" +
+	"\r_gas_680_kohm\"\xd2\x02\n" +
+	"\fParticulates\x12\x1a\n" +
+	"\tpm1_0_cf1\x18\x01 \x01(\x02R\apm10Cf1\x12\x1a\n" +
+	"\tpm2_5_cf1\x18\x02 \x01(\x02R\apm25Cf1\x12\x1c\n" +
+	"\n" +
+	"pm10_0_cf1\x18\x03 \x01(\x02R\bpm100Cf1\x12\x1a\n" +
+	"\tpm1_0_atm\x18\x04 \x01(\x02R\apm10Atm\x12\x1a\n" +
+	"\tpm2_5_atm\x18\x05 \x01(\x02R\apm25Atm\x12\x1c\n" +
+	"\n" +
+	"pm10_0_atm\x18\x06 \x01(\x02R\bpm100Atm\x12\x17\n" +
+	"\bp_0_3_um\x18\a \x01(\x02R\x05p03Um\x12\x17\n" +
+	"\bp_0_5_um\x18\b \x01(\x02R\x05p05Um\x12\x17\n" +
+	"\bp_1_0_um\x18\t \x01(\x02R\x05p10Um\x12\x17\n" +
+	"\bp_2_5_um\x18\n" +
+	" \x01(\x02R\x05p25Um\x12\x17\n" +
+	"\bp_5_0_um\x18\v \x01(\x02R\x05p50Um\x12\x19\n" +
+	"\tp_10_0_um\x18\f \x01(\x02R\x06p100Um\"\xe2\x01\n" +
+	"\x03AQI\x12\x10\n" +
+	"\x03aqi\x18\x01 \x01(\x05R\x03aqi\x12\x1a\n" +
+	"\tpm2_5_aqi\x18\x02 \x01(\x05R\apm25Aqi\x12\x19\n" +
+	"\bpm10_aqi\x18\x03 \x01(\x05R\apm10Aqi\x12:\n" +
+	"\bcategory\x18\x04 \x01(\x0e2\x1e.purpleair2mqtt.v1.AQICategoryR\bcategory\x12#\n" +
+	"\rcategory_name\x18\x05 \x01(\tR\fcategoryName\x12\x14\n" +
+	"\x05color\x18\x06 \x01(\tR\x05color\x12\x1b\n" +
+	"\tcolor_rgb\x18\a \x01(\tR\bcolorRgb\"\xe8\x01\n" +
+	"\aMonitor\x12C\n" +
+	"\fparticulates\x18\x01 \x01(\v2\x1f.purpleair2mqtt.v1.ParticulatesR\fparticulates\x12/\n" +
+	"\aepa_aqi\x18\x02 \x01(\v2\x16.purpleair2mqtt.v1.AQIR\x06epaAqi\x12-\n" +
+	"\x13purpleair_pm2_5_aqi\x18\x03 \x01(\x05R\x10purpleairPm25Aqi\x128\n" +
+	"\x19purpleair_pm2_5_aqi_color\x18\x04 \x01(\tR\x15purpleairPm25AqiColor*\xe7\x01\n" +
+	"\vAQICategory\x12\x1c\n" +
+	"\x18AQI_CATEGORY_UNSPECIFIED\x10\x00\x12\x15\n" +
+	"\x11AQI_CATEGORY_GOOD\x10\x01\x12\x19\n" +
+	"\x15AQI_CATEGORY_MODERATE\x10\x02\x12/\n" +
+	"+AQI_CATEGORY_UNHEALTHY_FOR_SENSITIVE_GROUPS\x10\x03\x12\x1a\n" +
+	"\x16AQI_CATEGORY_UNHEALTHY\x10\x04\x12\x1f\n" +
+	"\x1bAQI_CATEGORY_VERY_UNHEALTHY\x10\x05\x12\x1a\n" +
+	"\x16AQI_CATEGORY_HAZARDOUS\x10\x062\xaa\x01\n" +
+	"\bReadings\x12L\n" +
+	"\tGetLatest\x12#.purpleair2mqtt.v1.GetLatestRequest\x1a\x1a.purpleair2mqtt.v1.Reading\x12P\n" +
+	"\x0eStreamReadings\x12 .purpleair2mqtt.v1.ReadingFilter\x1a\x1a.purpleair2mqtt.v1.Reading0\x01BMZKgithub.com/cdzombak/purpleair2mqtt/proto/purpleair2mqtt/v1;purpleair2mqttv1b\x06proto3"
+
+var (
+	file_purpleair2mqtt_v1_readings_proto_rawDescOnce sync.Once
+	file_purpleair2mqtt_v1_readings_proto_rawDescData []byte
+)
+
+func file_purpleair2mqtt_v1_readings_proto_rawDescGZIP() []byte {
+	file_purpleair2mqtt_v1_readings_proto_rawDescOnce.Do(func() {
+		file_purpleair2mqtt_v1_readings_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_purpleair2mqtt_v1_readings_proto_rawDesc), len(file_purpleair2mqtt_v1_readings_proto_rawDesc)))
+	})
+	return file_purpleair2mqtt_v1_readings_proto_rawDescData
+}
+
+var file_purpleair2mqtt_v1_readings_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_purpleair2mqtt_v1_readings_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_purpleair2mqtt_v1_readings_proto_goTypes = []any{
+	(AQICategory)(0),              // 0: purpleair2mqtt.v1.AQICategory
+	(*GetLatestRequest)(nil),      // 1: purpleair2mqtt.v1.GetLatestRequest
+	(*ReadingFilter)(nil),         // 2: purpleair2mqtt.v1.ReadingFilter
+	(*Reading)(nil),               // 3: purpleair2mqtt.v1.Reading
+	(*Device)(nil),                // 4: purpleair2mqtt.v1.Device
+	(*Environment)(nil),           // 5: purpleair2mqtt.v1.Environment
+	(*Particulates)(nil),          // 6: purpleair2mqtt.v1.Particulates
+	(*AQI)(nil),                   // 7: purpleair2mqtt.v1.AQI
+	(*Monitor)(nil),               // 8: purpleair2mqtt.v1.Monitor
+	(*timestamppb.Timestamp)(nil), // 9: google.protobuf.Timestamp
+}
+var file_purpleair2mqtt_v1_readings_proto_depIdxs = []int32{
+	9,  // 0: purpleair2mqtt.v1.Reading.received_at:type_name -> google.protobuf.Timestamp
+	4,  // 1: purpleair2mqtt.v1.Reading.device:type_name -> purpleair2mqtt.v1.Device
+	5,  // 2: purpleair2mqtt.v1.Reading.environment:type_name -> purpleair2mqtt.v1.Environment
+	6,  // 3: purpleair2mqtt.v1.Reading.particulates:type_name -> purpleair2mqtt.v1.Particulates
+	7,  // 4: purpleair2mqtt.v1.Reading.epa_aqi:type_name -> purpleair2mqtt.v1.AQI
+	8,  // 5: purpleair2mqtt.v1.Reading.channel_a:type_name -> purpleair2mqtt.v1.Monitor
+	8,  // 6: purpleair2mqtt.v1.Reading.channel_b:type_name -> purpleair2mqtt.v1.Monitor
+	0,  // 7: purpleair2mqtt.v1.AQI.category:type_name -> purpleair2mqtt.v1.AQICategory
+	6,  // 8: purpleair2mqtt.v1.Monitor.particulates:type_name -> purpleair2mqtt.v1.Particulates
+	7,  // 9: purpleair2mqtt.v1.Monitor.epa_aqi:type_name -> purpleair2mqtt.v1.AQI
+	1,  // 10: purpleair2mqtt.v1.Readings.GetLatest:input_type -> purpleair2mqtt.v1.GetLatestRequest
+	2,  // 11: purpleair2mqtt.v1.Readings.StreamReadings:input_type -> purpleair2mqtt.v1.ReadingFilter
+	3,  // 12: purpleair2mqtt.v1.Readings.GetLatest:output_type -> purpleair2mqtt.v1.Reading
+	3,  // 13: purpleair2mqtt.v1.Readings.StreamReadings:output_type -> purpleair2mqtt.v1.Reading
+	12, // [12:14] is the sub-list for method output_type
+	10, // [10:12] is the sub-list for method input_type
+	10, // [10:10] is the sub-list for extension type_name
+	10, // [10:10] is the sub-list for extension extendee
+	0,  // [0:10] is the sub-list for field type_name
+}
+
+func init() { file_purpleair2mqtt_v1_readings_proto_init() }
+func file_purpleair2mqtt_v1_readings_proto_init() {
+	if File_purpleair2mqtt_v1_readings_proto != nil {
+		return
+	}
+	file_purpleair2mqtt_v1_readings_proto_msgTypes[4].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_purpleair2mqtt_v1_readings_proto_rawDesc), len(file_purpleair2mqtt_v1_readings_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   8,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_purpleair2mqtt_v1_readings_proto_goTypes,
+		DependencyIndexes: file_purpleair2mqtt_v1_readings_proto_depIdxs,
+		EnumInfos:         file_purpleair2mqtt_v1_readings_proto_enumTypes,
+		MessageInfos:      file_purpleair2mqtt_v1_readings_proto_msgTypes,
+	}.Build()
+	File_purpleair2mqtt_v1_readings_proto = out.File
+	file_purpleair2mqtt_v1_readings_proto_goTypes = nil
+	file_purpleair2mqtt_v1_readings_proto_depIdxs = nil
+}
@@ -0,0 +1,177 @@
+// The gRPC API purpleair2mqtt serves when [grpc] is configured. The Go code
+// next to this file is generated from it with `make proto`; generate clients
+// from it as usual.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: purpleair2mqtt/v1/readings.proto
+
+package purpleair2mqttv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Readings_GetLatest_FullMethodName      = "/purpleair2mqtt.v1.Readings/GetLatest"
+	Readings_StreamReadings_FullMethodName = "/purpleair2mqtt.v1.Readings/StreamReadings"
+)
+
+// ReadingsClient is the client API for Readings service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ReadingsClient interface {
+	// GetLatest returns the latest reading of a sensor. It fails with
+	// NOT_FOUND if the bridge hasn't read the sensor since it started.
+	GetLatest(ctx context.Context, in *GetLatestRequest, opts ...grpc.CallOption) (*Reading, error)
+	// StreamReadings sends readings as the bridge takes them, until the
+	// client cancels or the bridge shuts down. A client that falls behind
+	// misses its oldest unsent readings.
+	StreamReadings(ctx context.Context, in *ReadingFilter, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Reading], error)
+}
+
+type readingsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewReadingsClient(cc grpc.ClientConnInterface) ReadingsClient {
+	return &readingsClient{cc}
+}
+
+func (c *readingsClient) GetLatest(ctx context.Context, in *GetLatestRequest, opts ...grpc.CallOption) (*Reading, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Reading)
+	err := c.cc.Invoke(ctx, Readings_GetLatest_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *readingsClient) StreamReadings(ctx context.Context, in *ReadingFilter, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Reading], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Readings_ServiceDesc.Streams[0], Readings_StreamReadings_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ReadingFilter, Reading]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Readings_StreamReadingsClient = grpc.ServerStreamingClient[Reading]
+
+// ReadingsServer is the server API for Readings service.
+// All implementations must embed UnimplementedReadingsServer
+// for forward compatibility.
+type ReadingsServer interface {
+	// GetLatest returns the latest reading of a sensor. It fails with
+	// NOT_FOUND if the bridge hasn't read the sensor since it started.
+	GetLatest(context.Context, *GetLatestRequest) (*Reading, error)
+	// StreamReadings sends readings as the bridge takes them, until the
+	// client cancels or the bridge shuts down. A client that falls behind
+	// misses its oldest unsent readings.
+	StreamReadings(*ReadingFilter, grpc.ServerStreamingServer[Reading]) error
+	mustEmbedUnimplementedReadingsServer()
+}
+
+// UnimplementedReadingsServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedReadingsServer struct{}
+
+func (UnimplementedReadingsServer) GetLatest(context.Context, *GetLatestRequest) (*Reading, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetLatest not implemented")
+}
+func (UnimplementedReadingsServer) StreamReadings(*ReadingFilter, grpc.ServerStreamingServer[Reading]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamReadings not implemented")
+}
+func (UnimplementedReadingsServer) mustEmbedUnimplementedReadingsServer() {}
+func (UnimplementedReadingsServer) testEmbeddedByValue()                  {}
+
+// UnsafeReadingsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ReadingsServer will
+// result in compilation errors.
+type UnsafeReadingsServer interface {
+	mustEmbedUnimplementedReadingsServer()
+}
+
+func RegisterReadingsServer(s grpc.ServiceRegistrar, srv ReadingsServer) {
+	// If the following call pancis, it indicates UnimplementedReadingsServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Readings_ServiceDesc, srv)
+}
+
+func _Readings_GetLatest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetLatestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReadingsServer).GetLatest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Readings_GetLatest_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReadingsServer).GetLatest(ctx, req.(*GetLatestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Readings_StreamReadings_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ReadingFilter)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ReadingsServer).StreamReadings(m, &grpc.GenericServerStream[ReadingFilter, Reading]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Readings_StreamReadingsServer = grpc.ServerStreamingServer[Reading]
+
+// Readings_ServiceDesc is the grpc.ServiceDesc for Readings service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Readings_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "purpleair2mqtt.v1.Readings",
+	HandlerType: (*ReadingsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetLatest",
+			Handler:    _Readings_GetLatest_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamReadings",
+			Handler:       _Readings_StreamReadings_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "purpleair2mqtt/v1/readings.proto",
+}
@@ -1,34 +1,61 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
-	"reflect"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/avast/retry-go/v4"
-	mqtt "github.com/eclipse/paho.mqtt.golang"
-	"github.com/naoina/toml"
 	"github.com/withmandala/go-log"
-
-	_ "github.com/influxdata/influxdb1-client" // this is important because of the bug in go mod
-	influxclient "github.com/influxdata/influxdb1-client/v2"
 )
 
 var version = "<dev>"
 
 // MQTT settings for overall configuration
 type tomlConfigMQTT struct {
-	BrokerHost     string
-	BrokerPort     int
-	BrokerUsername string
-	BrokerPassword string
-	ClientId       string
-	TopicPrefix    string
-	Topic          string
+	Name         string // Label for the target in logs (default: host:port)
+	Mode         string // always (default) or failover: publish only while the primary target is down
+	Output       string // topics (default), a topic per field; homie, a Homie device per sensor; or sparkplug, a Sparkplug B edge node
+	HomieVersion int    // Homie convention the homie output follows: 4 (default) or 5
+	// Sparkplug B group and edge node the sparkplug output publishes as
+	// (default: PurpleAir, and the client ID or purpleair2mqtt)
+	SparkplugGroupId  string
+	SparkplugEdgeNode string
+	// Seconds without a reading after which a sensor's Sparkplug device is
+	// declared dead (default: 900)
+	SparkplugOfflineAfter int
+	BrokerHost            string
+	BrokerPort            int
+	BrokerUsername        string
+	BrokerPassword        string
+	ClientId              string
+	TopicPrefix           string
+	Topic                 string // Replaces the sensor's Geo name in topics
+	TopicTemplate         string // Topic of each field, from {prefix}, {geo}, {sensor_id}, {channel} and {field}
+	TopicLayout           string // legacy (default), v2, or both while migrating from legacy to v2
+	FieldStyle            string // How fields are named in topics: go, snake_case or camelCase (default: go for the legacy layout, snake_case otherwise)
+	// Go text/template for each field's payload; the value as is if empty
+	PayloadTemplate string
+	// Extra topics, by the name filled in for {field}, whose payloads are
+	// Go text/templates of the whole reading
+	Topics      map[string]string
+	ExtraFields string   // Which unrecognized device fields to publish: none, numeric or all
+	Fields      []string // Topics to publish, relative to the sensor's topic, e.g. "EPAAQI" or "sensor_*/epa_aqi"; all if empty
+
+	Qos                  int    // QoS readings are published with: 0, 1 or 2
+	KeepAlive            int    // Seconds between keepalive pings
+	ConnectRetryInterval int    // Seconds between attempts to reach the broker at startup
+	MaxReconnectInterval int    // Longest wait, in seconds, between attempts to reconnect
+	PersistentSession    bool   // Keep the session on the broker across reconnects (clean session off)
+	StoreDirectory       string // Where unacknowledged QoS 1 and 2 messages are kept; in memory if empty
 }
 
 type tomlConfigHass struct {
@@ -48,6 +75,8 @@ type tomlConfigInflux struct {
 	Password              string
 	MeasurementName       string // Name of the measurement in InfluxDB for monitor data
 	StatusMeasurementName string // Name of the measurement in InfluxDB for status data
+	Timeout               int    // Timeout in seconds for HTTP requests
+	ExtraFields           string // Which unrecognized device fields to write: none, numeric or all
 }
 
 type tomlConfigPurpleAir struct {
@@ -56,11 +85,69 @@ type tomlConfigPurpleAir struct {
 	Timeout  int // Timeout in seconds for HTTP requests
 }
 
+// purpleAirSensors holds either a single [purpleair] table or, to poll
+// several devices from one bridge, an array of [[purpleair]] tables.
+type purpleAirSensors []tomlConfigPurpleAir
+
+func (s *purpleAirSensors) UnmarshalTOML(decode func(interface{}) error) error {
+	var raw interface{}
+	if err := decode(&raw); err != nil {
+		return err
+	}
+	if _, ok := raw.([]interface{}); ok {
+		var sensors []tomlConfigPurpleAir
+		if err := decode(&sensors); err != nil {
+			return err
+		}
+		*s = sensors
+		return nil
+	}
+	var sensor tomlConfigPurpleAir
+	if err := decode(&sensor); err != nil {
+		return err
+	}
+	*s = purpleAirSensors{sensor}
+	return nil
+}
+
+// mqttTargets holds either a single [mqtt] table or, to publish to several
+// brokers, an array of [[mqtt]] tables. The first is the primary target.
+type mqttTargets []tomlConfigMQTT
+
+func (t *mqttTargets) UnmarshalTOML(decode func(interface{}) error) error {
+	var raw interface{}
+	if err := decode(&raw); err != nil {
+		return err
+	}
+	if _, ok := raw.([]interface{}); ok {
+		var targets []tomlConfigMQTT
+		if err := decode(&targets); err != nil {
+			return err
+		}
+		*t = targets
+		return nil
+	}
+	var target tomlConfigMQTT
+	if err := decode(&target); err != nil {
+		return err
+	}
+	*t = mqttTargets{target}
+	return nil
+}
+
 type tomlConfig struct {
-	PurpleAir tomlConfigPurpleAir
-	Mqtt      tomlConfigMQTT
+	PurpleAir purpleAirSensors
+	Cloud     tomlConfigCloud
+	Receiver  tomlConfigReceiver
+	Mqtt      mqttTargets
 	Hass      tomlConfigHass
 	Influx    tomlConfigInflux
+	Webhook   tomlConfigWebhook
+	Modbus    tomlConfigModbus
+	Snmp      tomlConfigSNMP
+	Grpc      tomlConfigGRPC
+	Output    tomlConfigOutput
+	Capture   tomlConfigCapture
 }
 
 type purpleAirMonitor struct {
@@ -126,6 +213,15 @@ type purpleAirStatus struct {
 	Dewpoint           int     `json:"current_dewpoint_f"` // current dewpoint in fahrenheit rounded to nearest degree
 	Pressure           float32 `json:"pressure"`           // current pressure in mmHg
 
+	// BME680/688 readings, only reported by the Flex and newer units; nil
+	// when the device has no such sensor. Kept as floats since firmware
+	// hasn't been consistent about rounding them.
+	Temperature680 *float32 `json:"current_temp_f_680,omitempty"`     // fahrenheit temperature
+	Humidity680    *float32 `json:"current_humidity_680,omitempty"`   // humidity in percent
+	Dewpoint680    *float32 `json:"current_dewpoint_f_680,omitempty"` // dewpoint in fahrenheit
+	Pressure680    *float32 `json:"pressure_680,omitempty"`           // pressure in hPa
+	Gas680         *float32 `json:"gas_680,omitempty"`                // gas resistance in kΩ; falls as VOCs rise
+
 	A                purpleAirMonitor `json:"sensor_a,omitempty"` // breakout for sensor a
 	PM25AqiColor     string           `json:"p25aqic"`
 	PM25Aqi          int              `json:"pm2.5_aqi"`
@@ -198,31 +294,33 @@ type purpleAirStatus struct {
 	EPAAQICategory string // US EPA AQI category
 	EPAAQIColor    string // US EPA AQI color (English name)
 	EPAAQIColorRGB string // US EPA AQI color (RGB value)
+
+	// Device fields that have no field above, e.g. from newer firmware
+	Extras map[string]interface{} `json:"extras,omitempty"`
 }
 
 // set up a global logger...
 // see: https://stackoverflow.com/a/43827612/57626
 var logger *log.Logger
 
-var config tomlConfig
-
-// var components tomlComponents
-var client mqtt.Client
-
-var connectHandler mqtt.OnConnectHandler = func(client mqtt.Client) {
-	r := client.OptionsReader()
-	logger.Infof("Connected to MQTT at %s", r.Servers())
-}
-
-var connectLostHandler mqtt.ConnectionLostHandler = func(client mqtt.Client, err error) {
-	logger.Errorf("MQTT Connection lost: %v", err)
-}
-
 func main() {
 	logger = log.New(os.Stderr).WithColor()
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "read":
+			os.Exit(runRead(os.Args[2:]))
+		case "replay":
+			os.Exit(runReplay(os.Args[2:]))
+		}
+	}
+
 	configFile := flag.String("config", "", "Filename with configuration")
+	watchConfig := flag.Bool("watch-config", false, "Reload the configuration file whenever it changes")
+	checkConfig := flag.Bool("check-config", false, "Check the configuration file for problems and exit")
 	printVersion := flag.Bool("version", false, "Print version and exit")
+	var overrides configFlags
+	overrides.register(flag.CommandLine)
 	flag.Parse()
 
 	if *printVersion {
@@ -230,89 +328,112 @@ func main() {
 		os.Exit(0)
 	}
 
-	if *configFile != "" {
-		f, err := os.Open(*configFile)
-		if err != nil {
-			panic(err)
-		}
-		defer func() { _ = f.Close() }()
-		if err := toml.NewDecoder(f).Decode(&config); err != nil {
-			panic(err)
-		}
-	} else {
-		logger.Fatal("Must specify configuration file with -config FILENAME")
+	if *watchConfig && *configFile == "" {
+		logger.Fatal("-watch-config requires -config FILENAME")
+	}
+	if *checkConfig {
+		os.Exit(runCheckConfig(*configFile, overrides))
+	}
+	config, err := loadConfig(*configFile, overrides)
+	if err != nil {
+		logger.Fatal(err)
 	}
 
-	if config.Mqtt != (tomlConfigMQTT{}) {
-		opts := mqtt.NewClientOptions()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-		opts.AddBroker(fmt.Sprintf("tcp://%s:%d", config.Mqtt.BrokerHost, config.Mqtt.BrokerPort))
-		if config.Mqtt.BrokerPassword != "" && config.Mqtt.BrokerUsername != "" {
-			opts.SetUsername(config.Mqtt.BrokerUsername)
-			opts.SetPassword(config.Mqtt.BrokerPassword)
-		}
-		opts.SetClientID(config.Mqtt.ClientId)
-		opts.OnConnect = connectHandler
-		opts.OnConnectionLost = connectLostHandler
+	b, err := newBridge(ctx, config)
+	if err != nil {
+		logger.Fatal(err)
+	}
 
-		client = mqtt.NewClient(opts)
-		if token := client.Connect(); token.Wait() && token.Error() != nil {
-			panic(token.Error())
+	reload := make(chan struct{}, 1)
+	requestReload := func() {
+		select {
+		case reload <- struct{}{}:
+		default:
 		}
-		if config.Mqtt.TopicPrefix == "" {
-			config.Mqtt.TopicPrefix = "purpleair"
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			logger.Info("Received SIGHUP")
+			requestReload()
 		}
-	} else {
-		logger.Info("No MQTT configuration found - not publishing to MQTT broker")
-		if config.Hass != (tomlConfigHass{}) {
-			logger.Fatal("Hass configuration found but no MQTT configuration found - please configure MQTT broker")
+	}()
+	if *watchConfig {
+		if err := watchConfigFile(ctx, *configFile, requestReload); err != nil {
+			logger.Fatal(err)
 		}
 	}
 
-	logger.Infof("HTTP Target: %s", config.PurpleAir.Url)
-	timeout := 15
-	if config.PurpleAir.Timeout > 0 {
-		timeout = config.PurpleAir.Timeout
+	for {
+		select {
+		case <-reload:
+			newConfig, err := loadConfig(*configFile, overrides)
+			if err != nil {
+				logger.Errorf("Keeping the current configuration: %s", err)
+				continue
+			}
+			b.Reload(newConfig)
+		case <-ctx.Done():
+			// a second signal kills the process, should shutting down hang
+			stop()
+			shutdownTimeout := b.ShutdownTimeout()
+			logger.Infof("Shutting down; waiting up to %s for outputs to finish", shutdownTimeout)
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			b.Close(shutdownCtx)
+			return
+		}
 	}
-	var myClient = &http.Client{Timeout: time.Duration(timeout) * time.Second}
+}
 
+// poll fetches readings from a sensor and hands them to the outputs until
+// ctx is cancelled.
+func poll(ctx context.Context, sensor tomlConfigPurpleAir, myClient *http.Client, outputs *dispatcher, capture *captureWriter) {
 	for {
-		pastatus := new(purpleAirStatus)
-		// see: https://stackoverflow.com/a/31129967/57626
-		if err := getJson(config.PurpleAir.Url, pastatus, myClient); err != nil {
-			panic(err)
-		}
-		normalizePaStatus(pastatus)
-		calculateEPAAQI(pastatus)
-
-		// if we don't set the specific topic, then we can grab and set the topic from the Geo field
-		// this is useful if you're polling from multiple different sensors and aggregating them and
-		// don't want to think about the topic name for each one
-
-		logger.Infof("Geo: %s", pastatus.Geo)
-		logger.Infof("Sensor ID: %s", pastatus.SensorId)
-		logger.Infof("Timestamp: %s", pastatus.DateTime)
-		logger.Infof("Sensor 1 Color: %s", pastatus.PM25AqiColor)
-		logger.Infof("Sensor 1 AQI: %d", pastatus.PM25Aqi)
-		logger.Infof("Sensor 2 Color: %s", pastatus.PM25AqiColorB)
-		logger.Infof("Sensor 2 AQI: %d", pastatus.B.PM25Aqi)
-		logger.Infof("US EPA AQI: %d (%s - %s)", pastatus.EPAAQI, pastatus.EPAAQICategory, pastatus.EPAAQIColor)
-		logger.Infof("US EPA PM2.5 AQI: %d, PM10 AQI: %d", pastatus.EPAPM25AQI, pastatus.EPAPM10AQI)
-
-		if config.Influx != (tomlConfigInflux{}) {
-			write_influx(pastatus, &pastatus.A, &pastatus.B)
+		pastatus, err := fetchStatus(ctx, sensor.Url, myClient, capture)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Errorf("error fetching %s: %s", sensor.Url, err)
+		} else {
+			logger.Infof("Geo: %s", pastatus.Geo)
+			logger.Infof("Sensor ID: %s", pastatus.SensorId)
+			logger.Infof("Timestamp: %s", pastatus.DateTime)
+			logger.Infof("Sensor 1 Color: %s", pastatus.PM25AqiColor)
+			logger.Infof("Sensor 1 AQI: %d", pastatus.PM25Aqi)
+			logger.Infof("Sensor 2 Color: %s", pastatus.PM25AqiColorB)
+			logger.Infof("Sensor 2 AQI: %d", pastatus.B.PM25Aqi)
+			logger.Infof("US EPA AQI: %d (%s - %s)", pastatus.EPAAQI, pastatus.EPAAQICategory, pastatus.EPAAQIColor)
+			logger.Infof("US EPA PM2.5 AQI: %d, PM10 AQI: %d", pastatus.EPAPM25AQI, pastatus.EPAPM10AQI)
+
+			outputs.Publish(ctx, pastatus)
 		}
 
-		if config.Mqtt != (tomlConfigMQTT{}) {
-			if config.Mqtt.Topic == "" {
-				config.Mqtt.Topic = pastatus.Geo
-			}
-			publishMQTT(pastatus)
+		logger.Debugf("Sleeping for %d seconds", sensor.PollRate)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(sensor.PollRate) * time.Second):
 		}
+	}
+}
 
-		logger.Debugf("Sleeping for %d seconds", config.PurpleAir.PollRate)
-		time.Sleep(time.Duration(config.PurpleAir.PollRate) * time.Second)
+// fetchStatus reads the sensor at url and fills in the per-channel breakouts
+// and US EPA AQI. Raw responses are recorded to capture, which may be nil.
+func fetchStatus(ctx context.Context, url string, myClient *http.Client, capture *captureWriter) (*purpleAirStatus, error) {
+	pastatus := new(purpleAirStatus)
+	// see: https://stackoverflow.com/a/31129967/57626
+	if err := getJson(ctx, url, pastatus, myClient, capture); err != nil {
+		return nil, err
 	}
+	normalizePaStatus(pastatus)
+	calculateEPAAQI(pastatus)
+	return pastatus, nil
 }
 
 func normalizePaStatus(pastatus *purpleAirStatus) *purpleAirStatus {
@@ -420,16 +541,28 @@ func calculateEPAAQI(pastatus *purpleAirStatus) {
 	}
 }
 
-func getJson(url string, target interface{}, myClient *http.Client) error {
+func getJson(ctx context.Context, url string, target interface{}, myClient *http.Client, capture *captureWriter) error {
 	return retry.Do(
 		func() error {
-			r, err := myClient.Get(url)
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 			if err != nil {
+				return retry.Unrecoverable(err)
+			}
+			fetchedAt := time.Now()
+			r, err := myClient.Do(req)
+			if err != nil {
+				capture.Record(newCaptureRecord(url, fetchedAt, nil, nil, err))
 				return err
 			}
 			defer func() { _ = r.Body.Close() }()
-			return json.NewDecoder(r.Body).Decode(target)
+			body, err := io.ReadAll(r.Body)
+			capture.Record(newCaptureRecord(url, fetchedAt, r, body, err))
+			if err != nil {
+				return err
+			}
+			return json.NewDecoder(bytes.NewReader(body)).Decode(target)
 		},
+		retry.Context(ctx),
 		retry.Attempts(5),
 		retry.Delay(1*time.Second),
 		retry.DelayType(retry.BackOffDelay),
@@ -438,157 +571,3 @@ func getJson(url string, target interface{}, myClient *http.Client) error {
 		}),
 	)
 }
-
-func status_to_point(status *purpleAirStatus) (*influxclient.Point, error) {
-	tags := map[string]string{"sensorId": status.SensorId}
-	values := map[string]interface{}{}
-
-	values["temperature"] = status.Temperature
-	values["humidity"] = status.Humidity
-	values["pressure"] = status.Pressure
-	values["dewpoint"] = status.Dewpoint
-	values["rssi"] = status.RSSI
-
-	// Add US EPA AQI values
-	values["epa_aqi"] = status.EPAAQI
-	values["epa_pm25_aqi"] = status.EPAPM25AQI
-	values["epa_pm10_aqi"] = status.EPAPM10AQI
-	values["epa_aqi_category"] = status.EPAAQICategory
-	values["epa_aqi_color"] = status.EPAAQIColor
-	values["epa_aqi_color_rgb"] = status.EPAAQIColorRGB
-
-	measurementName := "purpleair_status"
-	if config.Influx.StatusMeasurementName != "" {
-		measurementName = config.Influx.StatusMeasurementName
-	}
-
-	return influxclient.NewPoint(measurementName, tags, values, time.Now())
-}
-
-func monitor_to_point(monitor *purpleAirMonitor) (*influxclient.Point, error) {
-	tags := map[string]string{"sensorId": monitor.SensorId, "sensor": monitor.Sensor}
-	values := map[string]interface{}{}
-	values["pm2.5_aqic"] = monitor.PM25AqiColor
-	values["pm2.5_aqi"] = monitor.PM25Aqi
-	values["pm1.0_cf_1"] = monitor.PM10Cf1
-	values["pm0.3_um"] = monitor.P03um
-	values["pm2.5_cf_1"] = monitor.PM25Cf1
-	values["pm0.5_um"] = monitor.P05um
-	values["pm10.0_cf_1"] = monitor.PM100Cf1
-	values["pm1.0_um"] = monitor.P10um
-	values["pm1.0_atm"] = monitor.PM10Atm
-	values["pm2.5_um"] = monitor.P25um
-	values["pm2.5_atm"] = monitor.PM25Atm
-	values["pm5.0_um"] = monitor.P50um
-	values["pm10.0_atm"] = monitor.PM100Atm
-	values["pm10.0_um"] = monitor.P100um
-	values["key1_response"] = monitor.Key1Response
-	values["key1_response_date"] = monitor.Key1ResponseDate
-	values["key1_count"] = monitor.Key1Count
-	values["ts_latency"] = monitor.TsLatency
-	values["key2_response"] = monitor.Key2Response
-	values["key2_response_date"] = monitor.Key2ResponseDate
-	values["key2_count"] = monitor.Key2Count
-	values["ts_s_latency"] = monitor.TsSLatency
-	values["epa_aqi"] = monitor.EPAAQI
-	values["epa_pm25_aqi"] = monitor.EPAPM25AQI
-	values["epa_pm10_aqi"] = monitor.EPAPM10AQI
-	values["epa_aqi_category"] = monitor.EPAAQICategory
-	values["epa_aqi_color"] = monitor.EPAAQIColor
-	values["epa_aqi_color_rgb"] = monitor.EPAAQIColorRGB
-
-	measurementName := "purpleair_monitor"
-	if config.Influx.MeasurementName != "" {
-		measurementName = config.Influx.MeasurementName
-	}
-
-	return influxclient.NewPoint(measurementName, tags, values, time.Now())
-}
-
-func write_influx(status *purpleAirStatus, monitorA *purpleAirMonitor, monitorB *purpleAirMonitor) {
-	c, err := influxclient.NewHTTPClient(influxclient.HTTPConfig{
-		Addr: fmt.Sprintf("http://%s:%d", config.Influx.Hostname, config.Influx.Port),
-	})
-	if err != nil {
-		logger.Errorf("Error creating InfluxDB Client: %s", err.Error())
-	}
-	defer func() { _ = c.Close() }()
-
-	bp, err := influxclient.NewBatchPoints(influxclient.BatchPointsConfig{
-		Database:  config.Influx.Database,
-		Precision: "s",
-	})
-	if err != nil {
-		logger.Errorf("error creating batchpoints: %s", err)
-	}
-
-	pointA, err := monitor_to_point(monitorA)
-	if err != nil {
-		logger.Errorf("error translating monitor sample to point")
-	}
-	bp.AddPoint(pointA)
-
-	pointB, err := monitor_to_point(monitorB)
-	if err != nil {
-		logger.Errorf("error translating monitor sample to point")
-	}
-	bp.AddPoint(pointB)
-
-	pointS, err := status_to_point(status)
-	if err != nil {
-		logger.Errorf("error translating status to point")
-	}
-	bp.AddPoint(pointS)
-
-	err = c.Write(bp)
-
-	if err != nil {
-		logger.Fatal(err)
-	}
-}
-
-func publishMQTT(status *purpleAirStatus) {
-	v := reflect.ValueOf(*status)
-	typeOfStatus := v.Type()
-
-	for i := 0; i < v.NumField(); i++ {
-		fieldName := typeOfStatus.Field(i).Name
-
-		if fieldName == "A" || fieldName == "B" {
-			continue
-		}
-
-		fieldValue := v.Field(i).Interface()
-		topic := fmt.Sprintf("%s/%s/%s", config.Mqtt.TopicPrefix, config.Mqtt.Topic, fieldName)
-		logger.Infof("field[%s] = [%v]", fieldName, fieldValue)
-		logger.Infof("topic = %s", topic)
-		token := client.Publish(topic, 0, false, fmt.Sprintf("%v", fieldValue))
-		token.Wait()
-	}
-
-	// Also publish sensor A and B EPA AQI values
-	publishSensorEPAAQI(&status.A, "A")
-	publishSensorEPAAQI(&status.B, "B")
-}
-
-func publishSensorEPAAQI(monitor *purpleAirMonitor, sensor string) {
-	baseTopic := fmt.Sprintf("%s/%s/sensor_%s", config.Mqtt.TopicPrefix, config.Mqtt.Topic, sensor)
-
-	token := client.Publish(fmt.Sprintf("%s/epa_aqi", baseTopic), 0, false, fmt.Sprintf("%d", monitor.EPAAQI))
-	token.Wait()
-
-	token = client.Publish(fmt.Sprintf("%s/epa_pm25_aqi", baseTopic), 0, false, fmt.Sprintf("%d", monitor.EPAPM25AQI))
-	token.Wait()
-
-	token = client.Publish(fmt.Sprintf("%s/epa_pm10_aqi", baseTopic), 0, false, fmt.Sprintf("%d", monitor.EPAPM10AQI))
-	token.Wait()
-
-	token = client.Publish(fmt.Sprintf("%s/epa_aqi_category", baseTopic), 0, false, monitor.EPAAQICategory)
-	token.Wait()
-
-	token = client.Publish(fmt.Sprintf("%s/epa_aqi_color", baseTopic), 0, false, monitor.EPAAQIColor)
-	token.Wait()
-
-	token = client.Publish(fmt.Sprintf("%s/epa_aqi_color_rgb", baseTopic), 0, false, monitor.EPAAQIColorRGB)
-	token.Wait()
-}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPollStopsWhenCancelled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"SensorId": "84:f3:eb:00:00:01", "Geo": "PurpleAir-1234", "pm2_5_cf_1": 10.0}`))
+	}))
+	defer srv.Close()
+
+	received := &fakeSink{name: "fake"}
+	outputs := newDispatcher([]sink{received}, tomlConfigOutput{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		poll(ctx, tomlConfigPurpleAir{Url: srv.URL, PollRate: 3600}, srv.Client(), outputs, nil)
+		close(done)
+	}()
+
+	waitFor(t, func() bool { return received.count() == 1 })
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("poll did not return after its context was cancelled")
+	}
+
+	outputs.Close(context.Background())
+	if received.published[0].EPAPM25AQI != 42 {
+		t.Errorf("EPAPM25AQI = %d, want 42", received.published[0].EPAPM25AQI)
+	}
+}
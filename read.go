@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	readFormatTable  = "table"
+	readFormatJSON   = "json"
+	readFormatInflux = "influx"
+)
+
+// runRead implements `purpleair2mqtt read`: fetch one reading, print it and
+// return the process exit code.
+func runRead(args []string) int {
+	fs := flag.NewFlagSet("read", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s read -url URL [-format table|json|influx]\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	sensorUrl := fs.String("url", "", "Sensor JSON endpoint, e.g. http://192.168.1.24/json (required)")
+	format := fs.String("format", readFormatTable, "Output format: table, json or influx (line protocol)")
+	timeout := fs.Int("timeout", 15, "Seconds to wait for the sensor to respond")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *sensorUrl == "" {
+		fmt.Fprintln(os.Stderr, "read: -url is required")
+		fs.Usage()
+		return 2
+	}
+	switch *format {
+	case readFormatTable, readFormatJSON, readFormatInflux:
+	default:
+		fmt.Fprintf(os.Stderr, "read: unknown format %q; use table, json or influx\n", *format)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	myClient := &http.Client{Timeout: time.Duration(*timeout) * time.Second}
+	status, err := fetchStatus(ctx, *sensorUrl, myClient, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "read: error fetching %s: %s\n", *sensorUrl, err)
+		return 1
+	}
+	if err := writeReading(os.Stdout, status, *format, time.Now()); err != nil {
+		fmt.Fprintf(os.Stderr, "read: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// writeReading prints a normalized reading in the given format. t is the
+// timestamp used for InfluxDB line protocol.
+func writeReading(w io.Writer, status *purpleAirStatus, format string, t time.Time) error {
+	switch format {
+	case readFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(status)
+	case readFormatInflux:
+		points, err := readingPoints(status, "purpleair_monitor", "purpleair_status", extraFieldsAll, t)
+		if err != nil {
+			return err
+		}
+		for _, p := range points {
+			if _, err := fmt.Fprintln(w, p.String()); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return writeReadingTable(w, status)
+	}
+}
+
+// readDeviceFields are copied into each channel but describe the device.
+var readDeviceFields = map[string]bool{"SensorId": true, "DateTime": true}
+
+// writeReadingTable prints the device-wide fields, then the per-channel
+// fields with channels A and B side by side.
+func writeReadingTable(w io.Writer, status *purpleAirStatus) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	monitorType := reflect.TypeOf(purpleAirMonitor{})
+	v := reflect.ValueOf(*status)
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Name
+		if name == "A" || name == "B" || name == "Extras" {
+			continue
+		}
+		// the raw per-channel fields are shown in the channel table below
+		if _, ok := monitorType.FieldByName(strings.TrimSuffix(name, "B")); ok && !readDeviceFields[name] && !strings.HasPrefix(name, "EPA") {
+			continue
+		}
+		if value, ok := statusFieldValue(v.Field(i)); ok {
+			fmt.Fprintf(tw, "%s\t%v\n", name, value)
+		}
+	}
+
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "Channel\tA\tB")
+	a, b := reflect.ValueOf(status.A), reflect.ValueOf(status.B)
+	for i := 0; i < monitorType.NumField(); i++ {
+		name := monitorType.Field(i).Name
+		if readDeviceFields[name] || name == "Sensor" {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%v\t%v\n", name, a.Field(i).Interface(), b.Field(i).Interface())
+	}
+
+	if len(status.Extras) > 0 {
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "Extra fields\t")
+		for _, key := range sortedKeys(status.Extras) {
+			fmt.Fprintf(tw, "%s\t%v\n", key, status.Extras[key])
+		}
+	}
+	return tw.Flush()
+}
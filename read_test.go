@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const readFixture = `{"SensorId": "84:f3:eb:00:00:01", "DateTime": "2024/01/01T12:00:00z", "Geo": "PurpleAir-1234",
+"current_temp_f": 71, "current_humidity": 40,
+"pm2_5_atm": 12.0, "pm2_5_cf_1": 12.0, "pm10_0_atm": 20.0, "pm2.5_aqi": 50,
+"pm2_5_atm_b": 14.0, "pm2_5_cf_1_b": 14.0, "pm10_0_atm_b": 22.0, "pm2.5_aqi_b": 55}`
+
+func TestWriteReading(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(readFixture))
+	}))
+	defer srv.Close()
+
+	status, err := fetchStatus(t.Context(), srv.URL, srv.Client(), nil)
+	if err != nil {
+		t.Fatalf("fetchStatus() error = %v", err)
+	}
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		format        string
+		expectedLines []string
+	}{
+		{readFormatTable, []string{
+			"SensorId 84:f3:eb:00:00:01",
+			"Geo PurpleAir-1234",
+			"Temperature 71",
+			"EPAAQI 50",
+			"Channel A B",
+			"PM25Atm 12 14",
+			"EPAAQICategory Good Moderate",
+		}},
+		{readFormatInflux, []string{
+			`purpleair_monitor,sensor=A,sensorId=84:f3:eb:00:00:01 `,
+			`purpleair_monitor,sensor=B,sensorId=84:f3:eb:00:00:01 `,
+			`purpleair_status,sensorId=84:f3:eb:00:00:01 `,
+			" 1704110400000000000",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := writeReading(&out, status, tt.format, at); err != nil {
+				t.Fatalf("writeReading() error = %v", err)
+			}
+			// compare the table without its column padding
+			var normalized []string
+			for _, line := range strings.Split(out.String(), "\n") {
+				normalized = append(normalized, strings.Join(strings.Fields(line), " "))
+			}
+			for _, line := range tt.expectedLines {
+				if !strings.Contains(strings.Join(normalized, "\n"), line) {
+					t.Errorf("output doesn't contain %q:\n%s", line, out.String())
+				}
+			}
+		})
+	}
+
+	t.Run(readFormatJSON, func(t *testing.T) {
+		var out bytes.Buffer
+		if err := writeReading(&out, status, readFormatJSON, at); err != nil {
+			t.Fatalf("writeReading() error = %v", err)
+		}
+		var decoded purpleAirStatus
+		if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+			t.Fatalf("output isn't JSON: %v", err)
+		}
+		if decoded.EPAAQI != status.EPAAQI || decoded.B.PM25Atm != 14 {
+			t.Errorf("decoded = %+v", decoded)
+		}
+	})
+}
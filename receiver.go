@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+type tomlConfigReceiver struct {
+	Listen  string   // Address to accept pushes on, e.g. ":8080"
+	Path    string   // URL path sensors push to
+	Secret  string   // Shared secret pushes must carry, as ?key= or a bearer token
+	Sensors []string // MAC addresses allowed to push; any sensor if empty
+}
+
+// receiverMaxBody bounds a push, which is a single /json document of a few
+// kilobytes.
+const receiverMaxBody = 1 << 20
+
+// receiverConfigured reports whether the [receiver] section is in use.
+func receiverConfigured(cfg tomlConfigReceiver) bool {
+	return !reflect.DeepEqual(cfg, tomlConfigReceiver{})
+}
+
+// pushReceiver accepts the readings sensors push to a custom data processor
+// URL, and hands them to the outputs like polled readings.
+type pushReceiver struct {
+	cfg     tomlConfigReceiver
+	allowed map[string]bool // MAC addresses, as normalizeMAC returns them
+	outputs *dispatcher
+	capture *captureWriter
+
+	listener net.Listener
+	server   *http.Server
+	done     chan struct{}
+}
+
+func newPushReceiver(cfg tomlConfigReceiver, outputs *dispatcher, capture *captureWriter) *pushReceiver {
+	if cfg.Path == "" {
+		cfg.Path = "/purpleair"
+	}
+	allowed := map[string]bool{}
+	for _, mac := range cfg.Sensors {
+		allowed[normalizeMAC(mac)] = true
+	}
+	return &pushReceiver{cfg: cfg, allowed: allowed, outputs: outputs, capture: capture}
+}
+
+// Start listens on the configured address and serves pushes in the
+// background until Close is called.
+func (r *pushReceiver) Start() error {
+	ln, err := net.Listen("tcp", r.cfg.Listen)
+	if err != nil {
+		return fmt.Errorf("starting receiver: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(r.cfg.Path, r)
+	r.listener = ln
+	r.server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	r.done = make(chan struct{})
+	logger.Infof("Accepting sensor pushes at http://%s%s", ln.Addr(), r.cfg.Path)
+	go func() {
+		defer close(r.done)
+		if err := r.server.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("receiver stopped: %s", err)
+		}
+	}()
+	return nil
+}
+
+// Close stops accepting pushes, waiting for those in progress until ctx is
+// done.
+func (r *pushReceiver) Close(ctx context.Context) error {
+	if r.server == nil {
+		return nil
+	}
+	err := r.server.Shutdown(ctx)
+	<-r.done
+	return err
+}
+
+func (r *pushReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !r.authorized(req) {
+		logger.Warnf("rejected push from %s: missing or wrong secret", req.RemoteAddr)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	receivedAt := time.Now()
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, receiverMaxBody))
+	// the secret may be in the query, so only the path is recorded
+	r.capture.Record(newCaptureRecord(req.URL.Path, receivedAt, nil, body, err))
+	if err != nil {
+		http.Error(w, "reading body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	status := new(purpleAirStatus)
+	if err := json.Unmarshal(body, status); err != nil {
+		logger.Warnf("rejected push from %s: %s", req.RemoteAddr, err)
+		http.Error(w, "decoding reading: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(r.allowed) > 0 && !r.allowed[normalizeMAC(status.SensorId)] {
+		logger.Warnf("rejected push from %s: sensor %q isn't in receiver.sensors", req.RemoteAddr, status.SensorId)
+		http.Error(w, "sensor not allowed", http.StatusForbidden)
+		return
+	}
+
+	normalizePaStatus(status)
+	calculateEPAAQI(status)
+	logger.Infof("Push from %s (%s): US EPA AQI %d (%s)", status.SensorId, status.Geo, status.EPAAQI, status.EPAAQICategory)
+	r.outputs.Publish(req.Context(), status)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// normalizeMAC returns mac in the lower-case, colon-separated form sensors
+// report it in, so the allow-list can be written either way.
+func normalizeMAC(mac string) string {
+	if hw, err := net.ParseMAC(mac); err == nil {
+		return hw.String()
+	}
+	return strings.ToLower(mac)
+}
+
+// authorized checks the shared secret, which sensors can only send in the
+// URL; a bearer token is accepted too, for pushes relayed by a proxy.
+func (r *pushReceiver) authorized(req *http.Request) bool {
+	if r.cfg.Secret == "" {
+		return true
+	}
+	given := req.URL.Query().Get("key")
+	if token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer "); ok {
+		given = token
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(r.cfg.Secret)) == 1
+}
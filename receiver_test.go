@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPushReceiver(t *testing.T) {
+	cfg := tomlConfigReceiver{Secret: "s3cret", Sensors: []string{"84-F3-EB-00-00-01"}}
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		header         string // Authorization header
+		body           string
+		expectedStatus int
+		expectedCount  int
+	}{
+		{"Secret in URL", http.MethodPost, "/purpleair?key=s3cret", "", readFixture, http.StatusNoContent, 1},
+		{"Bearer token", http.MethodPost, "/purpleair", "Bearer s3cret", readFixture, http.StatusNoContent, 1},
+		{"No secret", http.MethodPost, "/purpleair", "", readFixture, http.StatusUnauthorized, 0},
+		{"Wrong secret", http.MethodPost, "/purpleair?key=guess", "", readFixture, http.StatusUnauthorized, 0},
+		{"Sensor not allowed", http.MethodPost, "/purpleair?key=s3cret", "", flexFixture, http.StatusForbidden, 0},
+		{"Not JSON", http.MethodPost, "/purpleair?key=s3cret", "", "SensorId=84:f3:eb:00:00:01", http.StatusBadRequest, 0},
+		{"GET", http.MethodGet, "/purpleair?key=s3cret", "", "", http.StatusMethodNotAllowed, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &fakeSink{name: "fake"}
+			outputs := newDispatcher([]sink{s}, tomlConfigOutput{})
+			r := newPushReceiver(cfg, outputs, nil)
+
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			outputs.Close(context.Background())
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.expectedStatus, w.Body.String())
+			}
+			if s.count() != tt.expectedCount {
+				t.Fatalf("published %d readings, want %d", s.count(), tt.expectedCount)
+			}
+			if tt.expectedCount > 0 {
+				status := s.published[0]
+				if status.A.PM25Atm != 12 || status.B.PM25Atm != 14 || status.EPAAQI == 0 {
+					t.Errorf("reading wasn't normalized: %+v", status)
+				}
+			}
+		})
+	}
+}
+
+func TestPushReceiverServes(t *testing.T) {
+	s := &fakeSink{name: "fake"}
+	outputs := newDispatcher([]sink{s}, tomlConfigOutput{})
+	defer outputs.Close(context.Background())
+	r := newPushReceiver(tomlConfigReceiver{Listen: "127.0.0.1:0"}, outputs, nil)
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	resp, err := http.Post("http://"+r.listener.Addr().String()+"/purpleair", "application/json", strings.NewReader(readFixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+	waitFor(t, func() bool { return s.count() == 1 })
+
+	if err := r.Close(context.Background()); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
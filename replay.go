@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// deviceTimeLayout is how sensors format DateTime, e.g. "2024/01/01T12:00:00z",
+// once upper-cased.
+const deviceTimeLayout = "2006/01/02T15:04:05Z"
+
+func parseDeviceTime(s string) (time.Time, error) {
+	return time.Parse(deviceTimeLayout, strings.ToUpper(strings.TrimSpace(s)))
+}
+
+// runReplay implements `purpleair2mqtt replay`: read recorded readings from
+// files and write them to InfluxDB with their original timestamps, and
+// optionally to MQTT. It returns the process exit code.
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s replay [-config FILENAME] [-mqtt [-speed N]] FILE...\n\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "FILEs are /json responses (one or more JSON objects per file) or SD card .csv logs.")
+		fmt.Fprintln(fs.Output())
+		fs.PrintDefaults()
+	}
+	configFile := fs.String("config", "", "Filename with configuration; only the output sections are used")
+	toMQTT := fs.Bool("mqtt", false, "Also publish each reading to MQTT, paced by -speed")
+	speed := fs.Float64("speed", 60, "With -mqtt, replay this many times faster than the readings were taken; 0 publishes as fast as possible")
+	batchSize := fs.Int("batch-size", 500, "Readings per InfluxDB write")
+	var overrides configFlags
+	overrides.registerOutputs(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "replay: no files given")
+		fs.Usage()
+		return 2
+	}
+
+	cfg, err := loadReplayConfig(*configFile, overrides)
+	if err != nil {
+		logger.Error(err)
+		return 2
+	}
+	if *toMQTT && len(cfg.Mqtt) == 0 {
+		logger.Error("replay: -mqtt given but no MQTT configuration found")
+		return 2
+	}
+	if !*toMQTT && cfg.Influx == (tomlConfigInflux{}) {
+		logger.Error("replay: no InfluxDB configuration found, and -mqtt not given; nothing to replay to")
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	r := &replayer{speed: *speed, batchSize: max(*batchSize, 1)}
+	if cfg.Influx != (tomlConfigInflux{}) {
+		if r.influx, err = newInfluxSink(cfg.Influx); err != nil {
+			logger.Error(err)
+			return 1
+		}
+		defer func() { _ = r.influx.Close(context.Background()) }()
+	}
+	if *toMQTT {
+		if r.mqtt, err = newMQTTFanout(cfg.Mqtt, cfg.Hass); err != nil {
+			logger.Error(err)
+			return 1
+		}
+		defer func() { _ = r.mqtt.Close(context.Background()) }()
+		connectCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		err = r.mqtt.waitConnected(connectCtx)
+		cancel()
+		if err != nil {
+			logger.Error(err)
+			return 1
+		}
+	}
+
+	failed := false
+	for _, path := range fs.Args() {
+		readings, skipped, err := readReplayFile(path)
+		if err != nil {
+			logger.Errorf("%s: %s", path, err)
+			failed = true
+			continue
+		}
+		if skipped > 0 {
+			failed = true
+		}
+		if err := r.replay(ctx, readings); err != nil {
+			logger.Errorf("%s: %s", path, err)
+			return 1
+		}
+		logger.Infof("%s: replayed %d reading(s), skipped %d", path, len(readings), skipped)
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// loadReplayConfig loads the configuration like loadConfig, except that
+// sensors aren't required since replay doesn't poll any.
+func loadReplayConfig(path string, flags configFlags) (tomlConfig, error) {
+	data, err := readConfigFile(path)
+	if err != nil {
+		return tomlConfig{}, err
+	}
+	cfg, report := parseConfig(data, os.Environ(), flags)
+	ignored := map[string]bool{"purpleair": true, "cloud": true, "receiver": true}
+	report.Errors = withoutSections(report.Errors, ignored)
+	report.Warnings = withoutSections(report.Warnings, ignored)
+	for _, w := range report.Warnings {
+		logger.Warnf("%s: %s", configName(path), w)
+	}
+	if err := report.Err(); err != nil {
+		return cfg, fmt.Errorf("invalid configuration in %s: %w", configName(path), err)
+	}
+	return cfg, nil
+}
+
+// replayer writes recorded readings to the outputs.
+type replayer struct {
+	influx    *influxSink
+	mqtt      *mqttFanout
+	speed     float64
+	batchSize int
+
+	last time.Time // when the last reading published to MQTT was taken
+}
+
+func (r *replayer) replay(ctx context.Context, readings []timedReading) error {
+	var batch []timedReading
+	flush := func() error {
+		if r.influx == nil || len(batch) == 0 {
+			return nil
+		}
+		err := r.influx.WriteReadings(ctx, batch)
+		batch = batch[:0]
+		return err
+	}
+
+	for _, reading := range readings {
+		if r.mqtt != nil {
+			if r.speed > 0 && !r.last.IsZero() && reading.At.After(r.last) {
+				delay := time.Duration(float64(reading.At.Sub(r.last)) / r.speed)
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(delay):
+				}
+			}
+			r.last = reading.At
+			if err := r.mqtt.Publish(ctx, reading.Status); err != nil {
+				return err
+			}
+		}
+		batch = append(batch, reading)
+		if len(batch) >= r.batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// readReplayFile returns the readings recorded in path, oldest first, along
+// with the number of records that had to be skipped. Files ending in .csv are
+// read as SD card logs; anything else as /json responses or a capture file.
+func readReplayFile(path string) ([]timedReading, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var records []timedReading
+	skipped := 0
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		var statuses []*purpleAirStatus
+		statuses, skipped, err = readSDCardCSV(f)
+		for _, status := range statuses {
+			records = append(records, timedReading{Status: status})
+		}
+	} else {
+		records, err = readJSONReadings(f)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	readings := make([]timedReading, 0, len(records))
+	for _, r := range records {
+		// an archived reading has no time it was received, unlike a live one,
+		// so it's stamped with the device's DateTime, or failing that the
+		// time the file records for it
+		if at, err := parseDeviceTime(r.Status.DateTime); err == nil {
+			r.At = at
+		} else if r.At.IsZero() {
+			logger.Warnf("%s: skipping reading with unreadable DateTime %q", path, r.Status.DateTime)
+			skipped++
+			continue
+		}
+		normalizePaStatus(r.Status)
+		calculateEPAAQI(r.Status)
+		readings = append(readings, r)
+	}
+	sort.SliceStable(readings, func(i, j int) bool { return readings[i].At.Before(readings[j].At) })
+	return readings, skipped, nil
+}
+
+// readJSONReadings decodes a stream of /json responses, whether a single
+// object, one per line, or simply concatenated. Capture files are read too:
+// each captured response is timestamped with when it was fetched, and
+// captured failures are passed over.
+func readJSONReadings(r io.Reader) ([]timedReading, error) {
+	var readings []timedReading
+	dec := json.NewDecoder(r)
+	for n := 1; ; n++ {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); errors.Is(err, io.EOF) {
+			return readings, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("reading record %d: %w", n, err)
+		}
+
+		var rec struct {
+			FetchedAt time.Time       `json:"fetched_at"`
+			Body      json.RawMessage `json:"body"`
+		}
+		if err := json.Unmarshal(raw, &rec); err != nil {
+			return nil, fmt.Errorf("reading record %d: %w", n, err)
+		}
+		reading := timedReading{Status: new(purpleAirStatus)}
+		if !rec.FetchedAt.IsZero() {
+			if len(rec.Body) == 0 {
+				continue
+			}
+			raw, reading.At = rec.Body, rec.FetchedAt
+		}
+		if err := json.Unmarshal(raw, reading.Status); err != nil {
+			return nil, fmt.Errorf("reading record %d: %w", n, err)
+		}
+		readings = append(readings, reading)
+	}
+}
+
+// sdCardColumns maps SD card log columns to the /json keys of the same
+// values, where they differ.
+var sdCardColumns = map[string]string{
+	"UTCDateTime":     "DateTime",
+	"mac_address":     "SensorId",
+	"firmware_ver":    "version",
+	"hardware":        "hardwarediscovered",
+	"adc":             "Adc",
+	"mem":             "Mem",
+	"pm2.5_aqi_atm":   "pm2.5_aqi",
+	"pm2.5_aqi_atm_b": "pm2.5_aqi_b",
+}
+
+// readSDCardCSV reads a log written to a sensor's SD card. Columns are matched
+// to reading fields by their /json key; columns with no matching field are
+// kept as extras. Rows that can't be read are skipped and counted.
+func readSDCardCSV(r io.Reader) ([]*purpleAirStatus, int, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, 0, fmt.Errorf("reading CSV header: %w", err)
+	}
+
+	fields := statusFieldsByJSONKey()
+	columns := make([]int, len(header)) // index of the status field for each column, or -1
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if key, ok := sdCardColumns[name]; ok {
+			name = key
+		}
+		columns[i] = -1
+		if index, ok := fields[name]; ok {
+			columns[i] = index
+		}
+	}
+
+	var statuses []*purpleAirStatus
+	skipped := 0
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return statuses, skipped, nil
+		}
+		if err != nil {
+			// csv.ParseError already names the line
+			logger.Warnf("skipping CSV record: %s", err)
+			skipped++
+			continue
+		}
+		line, _ := cr.FieldPos(0)
+
+		status := new(purpleAirStatus)
+		v := reflect.ValueOf(status).Elem()
+		var rowErr error
+		for i, value := range row {
+			if i >= len(columns) || strings.TrimSpace(value) == "" {
+				continue
+			}
+			if columns[i] < 0 {
+				if status.Extras == nil {
+					status.Extras = map[string]interface{}{}
+				}
+				status.Extras[strings.TrimSpace(header[i])] = csvExtraValue(value)
+				continue
+			}
+			if err := setCSVField(v.Field(columns[i]), value); err != nil {
+				rowErr = fmt.Errorf("column %s: %w", header[i], err)
+				break
+			}
+		}
+		if rowErr != nil {
+			logger.Warnf("skipping CSV line %d: %s", line, rowErr)
+			skipped++
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+}
+
+// statusFieldsByJSONKey maps each /json key to the index of its field in
+// purpleAirStatus.
+func statusFieldsByJSONKey() map[string]int {
+	fields := map[string]int{}
+	t := reflect.TypeOf(purpleAirStatus{})
+	for i := 0; i < t.NumField(); i++ {
+		key, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if key != "" && t.Field(i).Type.Kind() != reflect.Struct {
+			fields[key] = i
+		}
+	}
+	return fields
+}
+
+// csvExtraValue returns value as a number if it is one, like a JSON extra.
+func csvExtraValue(value string) interface{} {
+	value = strings.TrimSpace(value)
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f
+	}
+	return value
+}
+
+func setCSVField(field reflect.Value, value string) error {
+	value = strings.TrimSpace(value)
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		// some firmware writes whole-number columns with a decimal point
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		field.SetInt(int64(math.Round(f)))
+	case reflect.Float32:
+		f, err := strconv.ParseFloat(value, 32)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		field.SetFloat(f)
+	case reflect.Ptr:
+		// optional readings, such as the BME68x ones
+		elem := reflect.New(field.Type().Elem())
+		if err := setCSVField(elem.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(elem)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadReplayFile(t *testing.T) {
+	tests := []struct {
+		name            string
+		filename        string
+		contents        string
+		expectedTimes   []string
+		expectedPM25    []float32
+		expectedSkipped int
+	}{
+		{"Single JSON response", "reading.json", readFixture,
+			[]string{"2024-01-01T12:00:00Z"}, []float32{12}, 0},
+		{"JSON lines, out of order", "readings.jsonl", `
+{"SensorId": "84:f3:eb:00:00:01", "DateTime": "2024/01/01T12:02:00z", "pm2_5_atm": 20.0, "pm2_5_cf_1": 20.0}
+{"SensorId": "84:f3:eb:00:00:01", "DateTime": "2024/01/01T12:00:00z", "pm2_5_atm": 10.0, "pm2_5_cf_1": 10.0}
+{"SensorId": "84:f3:eb:00:00:01", "DateTime": "yesterday", "pm2_5_atm": 15.0, "pm2_5_cf_1": 15.0}
+`, []string{"2024-01-01T12:00:00Z", "2024-01-01T12:02:00Z"}, []float32{10, 20}, 1},
+		{"SD card CSV", "20240101.csv", `UTCDateTime,mac_address,firmware_ver,hardware,current_temp_f,current_humidity,pm2_5_atm,pm2_5_cf_1,pm2_5_atm_b,pm2.5_aqi_atm,unknown_column
+2024/01/01T12:00:00z,84:f3:eb:00:00:01,7.02,3.0+BME280,71,40,12.0,12.0,14.0,50.00,x
+2024/01/01T12:02:00z,84:f3:eb:00:00:01,7.02,3.0+BME280,not-a-number,40,12.0,12.0,14.0,50,x
+2024/01/01T12:04:00z,84:f3:eb:00:00:01,7.02,3.0+BME280,72,41,13.0,13.0,15.0,52,x
+`, []string{"2024-01-01T12:00:00Z", "2024-01-01T12:04:00Z"}, []float32{12, 13}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.filename)
+			if err := os.WriteFile(path, []byte(tt.contents), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			readings, skipped, err := readReplayFile(path)
+			if err != nil {
+				t.Fatalf("readReplayFile() error = %v", err)
+			}
+			if skipped != tt.expectedSkipped {
+				t.Errorf("skipped = %d, want %d", skipped, tt.expectedSkipped)
+			}
+			if len(readings) != len(tt.expectedTimes) {
+				t.Fatalf("got %d readings, want %d", len(readings), len(tt.expectedTimes))
+			}
+			for i, r := range readings {
+				if got := r.At.Format(time.RFC3339); got != tt.expectedTimes[i] {
+					t.Errorf("readings[%d].At = %s, want %s", i, got, tt.expectedTimes[i])
+				}
+				if r.Status.A.PM25Atm != tt.expectedPM25[i] {
+					t.Errorf("readings[%d] channel A pm2_5_atm = %v, want %v", i, r.Status.A.PM25Atm, tt.expectedPM25[i])
+				}
+				if r.Status.SensorId != "84:f3:eb:00:00:01" || r.Status.EPAAQI == 0 {
+					t.Errorf("readings[%d] wasn't normalized: %+v", i, r.Status)
+				}
+			}
+		})
+	}
+}
+
+func TestReplayToInflux(t *testing.T) {
+	var mu sync.Mutex
+	var writes []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		writes = append(writes, string(b))
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+	s, err := newInfluxSink(tomlConfigInflux{Hostname: u.Hostname(), Port: port, Database: "purpleair"})
+	if err != nil {
+		t.Fatalf("newInfluxSink() error = %v", err)
+	}
+
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var readings []timedReading
+	for i := 0; i < 3; i++ {
+		status := &purpleAirStatus{SensorId: "84:f3:eb:00:00:01"}
+		normalizePaStatus(status)
+		readings = append(readings, timedReading{Status: status, At: start.Add(time.Duration(i) * 2 * time.Minute)})
+	}
+
+	r := &replayer{influx: s, batchSize: 2}
+	if err := r.replay(context.Background(), readings); err != nil {
+		t.Fatalf("replay() error = %v", err)
+	}
+
+	if len(writes) != 2 {
+		t.Fatalf("made %d writes, want 2", len(writes))
+	}
+	body := strings.Join(writes, "")
+	for _, ts := range []string{" 1704110400\n", " 1704110520\n", " 1704110640\n"} {
+		if strings.Count(body, ts) != 3 {
+			t.Errorf("want 3 points at timestamp%q, got:\n%s", ts, body)
+		}
+	}
+}
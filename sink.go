@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	overflowDropOldest = "drop_oldest"
+	overflowDropNewest = "drop_newest"
+	overflowBlock      = "block"
+)
+
+// output settings shared by every sink
+type tomlConfigOutput struct {
+	QueueSize       int    // Number of readings buffered per sink (default: 10)
+	PublishTimeout  int    // Timeout in seconds for a single publish to a sink (default: 30)
+	Overflow        string // What to do when a sink's queue is full: drop_oldest (default), drop_newest or block
+	ShutdownTimeout int    // Seconds to wait for queued readings to be published on shutdown (default: 10)
+}
+
+// sink is an output that normalized readings are published to. A sink reports
+// failures through the error returned from Publish; it's up to the caller to
+// decide what to do about them, and the poll loop just logs them and carries on.
+type sink interface {
+	Name() string
+	Publish(ctx context.Context, status *purpleAirStatus) error
+	// Close flushes anything the sink has buffered and releases its
+	// resources, giving up when ctx is done.
+	Close(ctx context.Context) error
+}
+
+// sinkKinds lists every kind of sink the bridge can publish to. section
+// returns the parts of the configuration a sink depends on, so that a reload
+// only rebuilds the sinks whose configuration actually changed, and restore
+// copies them from old into cfg, for when a reload can't apply them.
+var sinkKinds = []struct {
+	name    string
+	enabled func(cfg tomlConfig) bool
+	section func(cfg tomlConfig) interface{}
+	restore func(cfg *tomlConfig, old tomlConfig)
+	build   func(cfg tomlConfig) (sink, error)
+}{
+	{
+		name:    "MQTT",
+		enabled: func(cfg tomlConfig) bool { return len(cfg.Mqtt) > 0 },
+		section: func(cfg tomlConfig) interface{} { return []interface{}{cfg.Mqtt, cfg.Hass} },
+		restore: func(cfg *tomlConfig, old tomlConfig) { cfg.Mqtt, cfg.Hass = old.Mqtt, old.Hass },
+		build: func(cfg tomlConfig) (sink, error) {
+			s, err := newMQTTFanout(cfg.Mqtt, cfg.Hass)
+			if err != nil {
+				return nil, err
+			}
+			return s, nil
+		},
+	},
+	{
+		name:    "InfluxDB",
+		enabled: func(cfg tomlConfig) bool { return cfg.Influx != (tomlConfigInflux{}) },
+		section: func(cfg tomlConfig) interface{} { return cfg.Influx },
+		restore: func(cfg *tomlConfig, old tomlConfig) { cfg.Influx = old.Influx },
+		build: func(cfg tomlConfig) (sink, error) {
+			s, err := newInfluxSink(cfg.Influx)
+			if err != nil {
+				return nil, err
+			}
+			return s, nil
+		},
+	},
+	{
+		name:    "webhook",
+		enabled: func(cfg tomlConfig) bool { return len(cfg.Webhook.Urls) > 0 },
+		section: func(cfg tomlConfig) interface{} { return cfg.Webhook },
+		restore: func(cfg *tomlConfig, old tomlConfig) { cfg.Webhook = old.Webhook },
+		build: func(cfg tomlConfig) (sink, error) {
+			s, err := newWebhookSink(cfg.Webhook)
+			if err != nil {
+				return nil, err
+			}
+			return s, nil
+		},
+	},
+	{
+		name:    "Modbus",
+		enabled: func(cfg tomlConfig) bool { return modbusConfigured(cfg.Modbus) },
+		section: func(cfg tomlConfig) interface{} { return cfg.Modbus },
+		restore: func(cfg *tomlConfig, old tomlConfig) { cfg.Modbus = old.Modbus },
+		build: func(cfg tomlConfig) (sink, error) {
+			s, err := newModbusServer(cfg.Modbus)
+			if err != nil {
+				return nil, err
+			}
+			return s, nil
+		},
+	},
+	{
+		name:    "SNMP",
+		enabled: func(cfg tomlConfig) bool { return snmpConfigured(cfg.Snmp) },
+		section: func(cfg tomlConfig) interface{} { return cfg.Snmp },
+		restore: func(cfg *tomlConfig, old tomlConfig) { cfg.Snmp = old.Snmp },
+		build: func(cfg tomlConfig) (sink, error) {
+			s, err := startSNMPAgent(cfg.Snmp)
+			if err != nil {
+				return nil, err
+			}
+			return s, nil
+		},
+	},
+	{
+		name:    "gRPC",
+		enabled: func(cfg tomlConfig) bool { return grpcConfigured(cfg.Grpc) },
+		section: func(cfg tomlConfig) interface{} { return cfg.Grpc },
+		restore: func(cfg *tomlConfig, old tomlConfig) { cfg.Grpc = old.Grpc },
+		build: func(cfg tomlConfig) (sink, error) {
+			s, err := newGRPCServer(cfg.Grpc)
+			if err != nil {
+				return nil, err
+			}
+			return s, nil
+		},
+	},
+}
+
+// buildSinks creates every sink enabled in the given configuration.
+func buildSinks(cfg tomlConfig) ([]sink, error) {
+	var sinks []sink
+	for _, kind := range sinkKinds {
+		if !kind.enabled(cfg) {
+			continue
+		}
+		s, err := kind.build(cfg)
+		if err != nil {
+			closeSinks(context.Background(), sinks)
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if len(cfg.Mqtt) == 0 {
+		logger.Info("No MQTT configuration found - not publishing to MQTT broker")
+	}
+	return sinks, nil
+}
+
+func closeSinks(ctx context.Context, sinks []sink) {
+	for _, s := range sinks {
+		if err := s.Close(ctx); err != nil {
+			logger.Errorf("error closing %s: %s", s.Name(), err)
+		}
+	}
+}
+
+// dispatcher fans readings out to every sink concurrently. Each sink gets its
+// own worker goroutine and bounded queue, so a slow or hung sink only delays
+// itself.
+type dispatcher struct {
+	cfg tomlConfigOutput
+
+	mu      sync.RWMutex
+	workers []*sinkWorker
+}
+
+func newDispatcher(sinks []sink, cfg tomlConfigOutput) *dispatcher {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 10
+	}
+	if cfg.PublishTimeout <= 0 {
+		cfg.PublishTimeout = 30
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		cfg.ShutdownTimeout = 10
+	}
+	switch cfg.Overflow {
+	case overflowDropOldest, overflowDropNewest, overflowBlock:
+	case "":
+		cfg.Overflow = overflowDropOldest
+	default:
+		logger.Warnf("Unknown output overflow policy %q; using %s", cfg.Overflow, overflowDropOldest)
+		cfg.Overflow = overflowDropOldest
+	}
+
+	d := &dispatcher{cfg: cfg}
+	for _, s := range sinks {
+		d.workers = append(d.workers, d.startWorker(s))
+	}
+	return d
+}
+
+func (d *dispatcher) startWorker(s sink) *sinkWorker {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &sinkWorker{
+		ctx:      ctx,
+		cancel:   cancel,
+		sink:     s,
+		queue:    make(chan *purpleAirStatus, d.cfg.QueueSize),
+		timeout:  time.Duration(d.cfg.PublishTimeout) * time.Second,
+		overflow: d.cfg.Overflow,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Publish queues the reading for every sink. It only blocks if a sink's queue
+// is full and the overflow policy is "block", and then only until ctx is done
+// or the sink is removed; the lock isn't held meanwhile, so sinks can still be
+// added and removed.
+func (d *dispatcher) Publish(ctx context.Context, status *purpleAirStatus) {
+	d.mu.RLock()
+	workers := d.workers
+	d.mu.RUnlock()
+	for _, w := range workers {
+		w.enqueue(ctx, status)
+	}
+}
+
+// Add starts publishing to another sink.
+func (d *dispatcher) Add(s sink) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.workers = append(d.workers, d.startWorker(s))
+}
+
+// Remove stops publishing to the named sink, drains its queue (within the
+// shutdown timeout) and closes it.
+func (d *dispatcher) Remove(name string) {
+	d.mu.Lock()
+	// a new slice, since Publish may still be ranging over the old one
+	var removed, kept []*sinkWorker
+	for _, w := range d.workers {
+		if w.sink.Name() == name {
+			removed = append(removed, w)
+		} else {
+			kept = append(kept, w)
+		}
+	}
+	d.workers = kept
+	d.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(d.cfg.ShutdownTimeout)*time.Second)
+	defer cancel()
+	stopWorkers(ctx, removed, false)
+}
+
+// Close stops accepting readings, waits for the workers to drain their queues
+// until ctx expires, cutting off any publish still in flight then, and closes
+// the sinks.
+func (d *dispatcher) Close(ctx context.Context) {
+	d.mu.Lock()
+	workers := d.workers
+	d.workers = nil
+	d.mu.Unlock()
+	stopWorkers(ctx, workers, false)
+}
+
+// Detach is Close without closing the sinks, which it returns for another
+// dispatcher to take over.
+func (d *dispatcher) Detach(ctx context.Context) []sink {
+	d.mu.Lock()
+	workers := d.workers
+	d.workers = nil
+	d.mu.Unlock()
+	stopWorkers(ctx, workers, true)
+	sinks := make([]sink, len(workers))
+	for i, w := range workers {
+		sinks[i] = w.sink
+	}
+	return sinks
+}
+
+// stopWorkers waits for the workers to drain their queues until ctx is done,
+// and then closes their sinks unless keepSinks is set.
+func stopWorkers(ctx context.Context, workers []*sinkWorker, keepSinks bool) {
+	var wg sync.WaitGroup
+	for _, w := range workers {
+		close(w.stop)
+		wg.Add(1)
+		go func(w *sinkWorker) {
+			defer wg.Done()
+			select {
+			case <-w.done:
+			case <-ctx.Done():
+				logger.Warnf("%s: gave up waiting for %d queued readings", w.sink.Name(), len(w.queue))
+				// sinks aren't safe to close mid-publish, so wait for it to give up
+				w.cancel()
+				<-w.done
+			}
+			w.cancel()
+			if keepSinks {
+				return
+			}
+			if err := w.sink.Close(ctx); err != nil {
+				logger.Errorf("error closing %s: %s", w.sink.Name(), err)
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+type sinkWorker struct {
+	sink     sink
+	ctx      context.Context // cancelled at the shutdown deadline
+	cancel   context.CancelFunc
+	queue    chan *purpleAirStatus
+	timeout  time.Duration
+	overflow string
+	stop     chan struct{} // closed when the worker is to drain its queue and exit
+	done     chan struct{}
+
+	mu      sync.Mutex
+	dropped int
+}
+
+// run publishes queued readings until stopped, and then whatever is still
+// queued, unless the shutdown deadline passes first. The queue is never
+// closed, since Publish may still be sending to it.
+func (w *sinkWorker) run() {
+	defer close(w.done)
+	for w.ctx.Err() == nil {
+		select {
+		case status := <-w.queue:
+			w.publish(status)
+		case <-w.stop:
+			for w.ctx.Err() == nil {
+				select {
+				case status := <-w.queue:
+					w.publish(status)
+				default:
+					return
+				}
+			}
+			return
+		}
+	}
+}
+
+func (w *sinkWorker) publish(status *purpleAirStatus) {
+	ctx, cancel := context.WithTimeout(w.ctx, w.timeout)
+	defer cancel()
+	if err := w.sink.Publish(ctx, status); err != nil {
+		logger.Errorf("error publishing to %s: %s", w.sink.Name(), err)
+	}
+}
+
+func (w *sinkWorker) enqueue(ctx context.Context, status *purpleAirStatus) {
+	if w.overflow == overflowBlock {
+		select {
+		case w.queue <- status:
+		case <-w.stop:
+			logger.Warnf("%s was stopped; dropped a reading", w.sink.Name())
+		case <-ctx.Done():
+		}
+		return
+	}
+
+	select {
+	case w.queue <- status:
+		return
+	default:
+	}
+
+	if w.overflow == overflowDropOldest {
+		select {
+		case <-w.queue:
+		default:
+		}
+		select {
+		case w.queue <- status:
+		default:
+		}
+	}
+
+	w.mu.Lock()
+	w.dropped++
+	dropped := w.dropped
+	w.mu.Unlock()
+	logger.Warnf("%s is falling behind; dropped a reading (%d dropped so far)", w.sink.Name(), dropped)
+}
+
+func (w *sinkWorker) droppedCount() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.dropped
+}
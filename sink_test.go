@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/withmandala/go-log"
+)
+
+func TestMain(m *testing.M) {
+	logger = log.New(os.Stderr).Quiet()
+	os.Exit(m.Run())
+}
+
+// fakeSink records the readings it receives and optionally fails every publish.
+type fakeSink struct {
+	name string
+	err  error
+
+	mu        sync.Mutex
+	published []*purpleAirStatus
+	closed    bool
+}
+
+func (s *fakeSink) Name() string {
+	return s.name
+}
+
+func (s *fakeSink) Publish(ctx context.Context, status *purpleAirStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.published = append(s.published, status)
+	return s.err
+}
+
+func (s *fakeSink) Close(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *fakeSink) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.published)
+}
+
+// stalledSink simulates a hung output: Publish blocks until the sink is
+// released or the publish context expires.
+type stalledSink struct {
+	fakeSink
+	started chan struct{}
+	release chan struct{}
+}
+
+func newStalledSink() *stalledSink {
+	return &stalledSink{
+		fakeSink: fakeSink{name: "stalled"},
+		started:  make(chan struct{}, 100),
+		release:  make(chan struct{}),
+	}
+}
+
+func (s *stalledSink) Publish(ctx context.Context, status *purpleAirStatus) error {
+	s.started <- struct{}{}
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return s.fakeSink.Publish(ctx, status)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDispatcherContinuesAfterError(t *testing.T) {
+	failing := &fakeSink{name: "failing", err: errors.New("broker unavailable")}
+	working := &fakeSink{name: "working"}
+	d := newDispatcher([]sink{failing, working}, tomlConfigOutput{})
+
+	d.Publish(context.Background(), &purpleAirStatus{SensorId: "84:f3:eb:00:00:01"})
+	d.Publish(context.Background(), &purpleAirStatus{SensorId: "84:f3:eb:00:00:01"})
+	d.Close(context.Background())
+
+	if failing.count() != 2 {
+		t.Errorf("failing sink received %d readings, want 2", failing.count())
+	}
+	if working.count() != 2 {
+		t.Errorf("working sink received %d readings, want 2", working.count())
+	}
+	if !failing.closed || !working.closed {
+		t.Errorf("Close() did not close every sink")
+	}
+}
+
+func TestDispatcherStalledSinkDoesNotDelayOthers(t *testing.T) {
+	stalled := newStalledSink()
+	working := &fakeSink{name: "working"}
+	d := newDispatcher([]sink{stalled, working}, tomlConfigOutput{QueueSize: 1})
+
+	// far more readings than the stalled sink can buffer; none of these
+	// calls may block, and the working sink must see every one
+	for i := 1; i <= 5; i++ {
+		published := make(chan struct{})
+		go func() {
+			d.Publish(context.Background(), &purpleAirStatus{})
+			close(published)
+		}()
+		select {
+		case <-published:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Publish blocked on a stalled sink")
+		}
+		waitFor(t, func() bool { return working.count() == i })
+	}
+
+	close(stalled.release)
+	d.Close(context.Background())
+}
+
+func TestDispatcherOverflow(t *testing.T) {
+	tests := []struct {
+		name            string
+		overflow        string
+		expectedSensors []string
+	}{
+		{"Drop oldest", overflowDropOldest, []string{"1", "4", "5"}},
+		{"Drop newest", overflowDropNewest, []string{"1", "2", "3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stalled := newStalledSink()
+			d := newDispatcher([]sink{stalled}, tomlConfigOutput{QueueSize: 2, Overflow: tt.overflow})
+
+			d.Publish(context.Background(), &purpleAirStatus{SensorId: "1"})
+			<-stalled.started // reading 1 is now in flight, not queued
+			for _, id := range []string{"2", "3", "4", "5"} {
+				d.Publish(context.Background(), &purpleAirStatus{SensorId: id})
+			}
+
+			if dropped := d.workers[0].droppedCount(); dropped != 2 {
+				t.Errorf("dropped %d readings, want 2", dropped)
+			}
+
+			close(stalled.release)
+			d.Close(context.Background())
+
+			var got []string
+			for _, s := range stalled.published {
+				got = append(got, s.SensorId)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.expectedSensors, ",") {
+				t.Errorf("published %v, want %v", got, tt.expectedSensors)
+			}
+		})
+	}
+}
+
+func TestDispatcherBlockOverflow(t *testing.T) {
+	stalled := newStalledSink()
+	d := newDispatcher([]sink{stalled}, tomlConfigOutput{QueueSize: 1, Overflow: overflowBlock})
+
+	d.Publish(context.Background(), &purpleAirStatus{})
+	<-stalled.started
+	d.Publish(context.Background(), &purpleAirStatus{})
+
+	blocked := make(chan struct{})
+	go func() {
+		d.Publish(context.Background(), &purpleAirStatus{})
+		close(blocked)
+	}()
+	select {
+	case <-blocked:
+		t.Fatal("Publish returned while the queue was full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(stalled.release)
+	<-blocked
+	d.Close(context.Background())
+	if stalled.count() != 3 {
+		t.Errorf("published %d readings, want 3", stalled.count())
+	}
+}
+
+func TestDispatcherCloseDeadline(t *testing.T) {
+	stalled := newStalledSink()
+	d := newDispatcher([]sink{stalled}, tomlConfigOutput{})
+	d.Publish(context.Background(), &purpleAirStatus{})
+	<-stalled.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	d.Close(ctx)
+	if time.Since(start) > time.Second {
+		t.Errorf("Close() waited %s for a stalled sink, want it to honor the deadline", time.Since(start))
+	}
+	if !stalled.closed {
+		t.Errorf("Close() did not close the stalled sink")
+	}
+	close(stalled.release)
+}
+
+func TestBuildSinks(t *testing.T) {
+	tests := []struct {
+		name          string
+		cfg           tomlConfig
+		expectedSinks []string
+	}{
+		{"Nothing configured", tomlConfig{}, nil},
+		{"Influx and webhook", tomlConfig{
+			Influx:  tomlConfigInflux{Hostname: "localhost", Port: 8086, Database: "purpleair"},
+			Webhook: tomlConfigWebhook{Urls: []string{"http://localhost/hook"}},
+		}, []string{"InfluxDB", "webhook"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sinks, err := buildSinks(tt.cfg)
+			if err != nil {
+				t.Fatalf("buildSinks() error = %v", err)
+			}
+			defer closeSinks(context.Background(), sinks)
+			if len(sinks) != len(tt.expectedSinks) {
+				t.Fatalf("buildSinks() returned %d sinks, want %d", len(sinks), len(tt.expectedSinks))
+			}
+			for i, s := range sinks {
+				if s.Name() != tt.expectedSinks[i] {
+					t.Errorf("sink %d = %s, want %s", i, s.Name(), tt.expectedSinks[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDispatcherBlockOverflowGivesUp(t *testing.T) {
+	stalled := newStalledSink()
+	d := newDispatcher([]sink{stalled}, tomlConfigOutput{QueueSize: 1, Overflow: overflowBlock, ShutdownTimeout: 1})
+
+	d.Publish(context.Background(), &purpleAirStatus{})
+	<-stalled.started
+	d.Publish(context.Background(), &purpleAirStatus{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	blocked := make(chan struct{})
+	go func() {
+		d.Publish(ctx, &purpleAirStatus{})
+		close(blocked)
+	}()
+	// sinks can still be added while Publish waits for room
+	added := make(chan struct{})
+	go func() {
+		d.Add(&fakeSink{name: "working"})
+		close(added)
+	}()
+	select {
+	case <-added:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Add blocked behind a blocked Publish")
+	}
+	cancel()
+	select {
+	case <-blocked:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Publish kept blocking after its context was cancelled")
+	}
+
+	blocked = make(chan struct{})
+	go func() {
+		d.Publish(context.Background(), &purpleAirStatus{})
+		close(blocked)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(stalled.release)
+	d.Remove("stalled")
+	select {
+	case <-blocked:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Publish kept blocking after the sink was removed")
+	}
+	d.Close(context.Background())
+}
+
+// publishingSink records whether it was closed while a publish was still in
+// flight.
+type publishingSink struct {
+	*stalledSink
+	publishing       sync.Mutex
+	closedMidPublish bool
+}
+
+func (s *publishingSink) Publish(ctx context.Context, status *purpleAirStatus) error {
+	s.publishing.Lock()
+	defer s.publishing.Unlock()
+	return s.stalledSink.Publish(ctx, status)
+}
+
+func (s *publishingSink) Close(ctx context.Context) error {
+	if !s.publishing.TryLock() {
+		s.closedMidPublish = true
+	} else {
+		s.publishing.Unlock()
+	}
+	return s.stalledSink.Close(ctx)
+}
+
+func TestDispatcherCloseCutsOffPublish(t *testing.T) {
+	s := &publishingSink{stalledSink: newStalledSink()}
+	d := newDispatcher([]sink{s}, tomlConfigOutput{})
+	d.Publish(context.Background(), &purpleAirStatus{})
+	d.Publish(context.Background(), &purpleAirStatus{})
+	<-s.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	closed := make(chan struct{})
+	go func() {
+		d.Close(ctx)
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close outlasted its deadline")
+	}
+	if s.closedMidPublish {
+		t.Error("the sink was closed while a publish was in flight")
+	}
+	if !s.closed {
+		t.Error("the sink wasn't closed")
+	}
+	if len(s.started) != 0 {
+		t.Error("queued readings were published after the deadline")
+	}
+}
@@ -0,0 +1,594 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+type tomlConfigSNMP struct {
+	Listen     string               // UDP address to answer SNMP on, e.g. ":161"
+	Community  string               // SNMPv2c read-only community; v2c is off if empty
+	Users      []tomlConfigSNMPUser // SNMPv3 users; v3 is off if empty
+	EngineId   string               // SNMPv3 engine ID in hex (default: derived from the hostname)
+	Location   string               // sysLocation
+	Contact    string               // sysContact
+	StaleAfter int                  // Seconds without a reading before a sensor's poll status is stale (default: 900)
+}
+
+type tomlConfigSNMPUser struct {
+	Name         string
+	AuthProtocol string // SHA or SHA-256; no authentication if empty
+	AuthPassword string
+	PrivProtocol string // AES; no privacy if empty
+	PrivPassword string
+}
+
+// snmpConfigured reports whether the [snmp] section is in use.
+func snmpConfigured(cfg tomlConfigSNMP) bool {
+	return !reflect.DeepEqual(cfg, tomlConfigSNMP{})
+}
+
+// OIDs the agent serves: the MIB-II system group, the USM statistics and
+// PURPLEAIR2MQTT-MIB, which sits in Net-SNMP's playpen until the project has
+// an enterprise number of its own.
+var (
+	snmpSystem    = mustParseSNMPOID("1.3.6.1.2.1.1")
+	snmpUSMStats  = mustParseSNMPOID("1.3.6.1.6.3.15.1.1")
+	purpleAirMIB  = mustParseSNMPOID("1.3.6.1.4.1.8072.9999.9999.1")
+	paSensorCount = purpleAirMIB.append(1, 1)
+	paSensorEntry = purpleAirMIB.append(1, 2, 1)
+)
+
+// usmStats counters, by their last arc.
+const (
+	usmStatsUnsupportedSecLevels = 1
+	usmStatsNotInTimeWindows     = 2
+	usmStatsUnknownUserNames     = 3
+	usmStatsUnknownEngineIDs     = 4
+	usmStatsWrongDigests         = 5
+	usmStatsDecryptionErrors     = 6
+)
+
+// Values of paSensorPollStatus.
+const (
+	paPollOK    = 1
+	paPollStale = 2
+)
+
+// snmpMaxMessage is the largest response sent to a v2c request, which
+// keeps it in a single Ethernet frame; v3 managers state their own maximum.
+const snmpMaxMessage = 1472
+
+// snmpMaxUDP is the largest message UDP can carry.
+const snmpMaxUDP = 65507
+
+// snmpAgent answers SNMP requests for the latest reading of each sensor.
+// Sensors get a row in paSensorTable, indexed in the order they're first
+// read.
+type snmpAgent struct {
+	cfg      tomlConfigSNMP
+	engineId []byte
+	boots    int64
+	started  time.Time
+	now      func() time.Time
+	users    map[string]snmpUser
+
+	mu      sync.Mutex
+	sensors []*snmpSensor
+	byKey   map[string]*snmpSensor
+	stats   [usmStatsDecryptionErrors + 1]uint32
+	salt    uint64
+
+	conn net.PacketConn
+	done chan struct{}
+}
+
+type snmpSensor struct {
+	index      int
+	status     *purpleAirStatus
+	receivedAt time.Time
+	readings   uint32
+}
+
+// snmpEngineId returns the configured engine ID, or one in the RFC 3411
+// text format made from the hostname, so that it survives restarts and keys
+// localized to it stay valid.
+func snmpEngineId(cfg tomlConfigSNMP) ([]byte, error) {
+	if cfg.EngineId != "" {
+		return hex.DecodeString(cfg.EngineId)
+	}
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		name = "purpleair2mqtt"
+	}
+	return append([]byte{0x80, 0x00, 0x1f, 0x88, 0x04}, name[:min(len(name), 27)]...), nil
+}
+
+// newSNMPAgent builds an agent for cfg without listening for requests.
+func newSNMPAgent(cfg tomlConfigSNMP) (*snmpAgent, error) {
+	if cfg.StaleAfter <= 0 {
+		cfg.StaleAfter = 900
+	}
+	engineId, err := snmpEngineId(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("snmp.engine_id: %w", err)
+	}
+	started := time.Now()
+	a := &snmpAgent{
+		cfg:      cfg,
+		engineId: engineId,
+		// engineBoots has to grow with every restart; without a state file to
+		// count them in, the start time does that
+		boots:   min(started.Unix(), math.MaxInt32-1),
+		started: started,
+		now:     time.Now,
+		users:   map[string]snmpUser{},
+		byKey:   map[string]*snmpSensor{},
+	}
+	for _, u := range cfg.Users {
+		a.users[u.Name] = newSNMPUser(u, engineId)
+	}
+	var salt [8]byte
+	_, _ = rand.Read(salt[:])
+	a.salt = binary.BigEndian.Uint64(salt[:])
+	return a, nil
+}
+
+// startSNMPAgent builds an agent and answers requests in the background
+// until Close is called.
+func startSNMPAgent(cfg tomlConfigSNMP) (*snmpAgent, error) {
+	a, err := newSNMPAgent(cfg)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenPacket("udp", cfg.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("starting SNMP agent: %w", err)
+	}
+	a.conn = conn
+	a.done = make(chan struct{})
+	logger.Infof("Answering SNMP on %s (engine ID %x)", conn.LocalAddr(), a.engineId)
+	go a.serve()
+	return a, nil
+}
+
+func (a *snmpAgent) Name() string {
+	return "SNMP"
+}
+
+// Publish keeps the reading for the sensor's row, adding one for a sensor
+// seen for the first time.
+func (a *snmpAgent) Publish(ctx context.Context, status *purpleAirStatus) error {
+	key := status.Geo
+	if status.SensorId != "" {
+		key = normalizeMAC(status.SensorId)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	sensor, ok := a.byKey[key]
+	if !ok {
+		sensor = &snmpSensor{index: len(a.sensors) + 1}
+		a.sensors = append(a.sensors, sensor)
+		a.byKey[key] = sensor
+	}
+	sensor.status = status
+	sensor.receivedAt = a.now()
+	sensor.readings++
+	return nil
+}
+
+// Close stops answering requests.
+func (a *snmpAgent) Close(ctx context.Context) error {
+	if a.conn == nil {
+		return nil
+	}
+	err := a.conn.Close()
+	select {
+	case <-a.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return err
+}
+
+func (a *snmpAgent) serve() {
+	defer close(a.done)
+	buf := make([]byte, snmpMaxUDP)
+	for {
+		n, addr, err := a.conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Errorf("SNMP agent stopped: %s", err)
+			}
+			return
+		}
+		if reply := a.handle(buf[:n]); reply != nil {
+			if _, err := a.conn.WriteTo(reply, addr); err != nil {
+				logger.Warnf("SNMP: replying to %s: %s", addr, err)
+			}
+		}
+	}
+}
+
+// handle answers a single request; it returns nil for anything that
+// shouldn't be answered, such as a wrong community or a malformed packet.
+func (a *snmpAgent) handle(packet []byte) []byte {
+	content, _, err := berExpect(packet, berSequence)
+	if err != nil {
+		return nil
+	}
+	version, rest, err := berExpectInteger(content)
+	if err != nil {
+		return nil
+	}
+	switch {
+	case version == 1 && a.cfg.Community != "":
+		return a.handleV2c(rest)
+	case version == 3 && len(a.users) > 0:
+		return a.handleV3(packet)
+	}
+	return nil
+}
+
+func (a *snmpAgent) handleV2c(b []byte) []byte {
+	community, b, err := berExpect(b, berOctetString)
+	if err != nil {
+		return nil
+	}
+	if subtle.ConstantTimeCompare(community, []byte(a.cfg.Community)) != 1 {
+		logger.Debugf("SNMP: ignoring a v2c request with the wrong community")
+		return nil
+	}
+	request, err := unmarshalSNMPPDU(b)
+	if err != nil {
+		logger.Debugf("SNMP: ignoring a malformed v2c request: %s", err)
+		return nil
+	}
+	response, ok := a.respond(request)
+	if !ok {
+		return nil
+	}
+	return fitSNMPResponse(request, response, snmpMaxMessage, func(pdu snmpPDU) []byte {
+		var msg []byte
+		msg = berAppend(msg, berInteger, berSigned(1))
+		msg = berAppend(msg, berOctetString, community)
+		msg = append(msg, pdu.marshal()...)
+		return berAppend(nil, berSequence, msg)
+	})
+}
+
+func (a *snmpAgent) handleV3(packet []byte) []byte {
+	m, err := unmarshalSNMPv3(packet)
+	if err != nil || m.header.securityModel != snmpUSM {
+		logger.Debugf("SNMP: ignoring a malformed or non-USM v3 request")
+		return nil
+	}
+	level := m.header.flags & (snmpFlagAuth | snmpFlagPriv)
+	if level == snmpFlagPriv {
+		return nil
+	}
+
+	// A report's request ID is the request's, but only once it can be
+	// trusted: straight away for a noAuthNoPriv request, such as discovery,
+	// and otherwise once its digest checks out. Until then it's zero, so a
+	// forged request can't have a report carry an ID of its choosing.
+	var requestId int64
+	readRequestId := func() {
+		if _, pdu, err := unmarshalScopedPDU(m.data); err == nil {
+			requestId = pdu.requestId
+		}
+	}
+	if level == 0 {
+		readRequestId()
+	}
+	report := func(counter int, user *snmpUser) []byte {
+		a.mu.Lock()
+		a.stats[counter]++
+		count := a.stats[counter]
+		a.mu.Unlock()
+		if m.header.flags&snmpFlagReportable == 0 {
+			return nil
+		}
+		pdu := snmpPDU{typ: snmpReport, requestId: requestId, varbinds: []snmpVarbind{
+			{oid: snmpUSMStats.append(uint32(counter), 0), tag: snmpCounter32, value: uint64(count)},
+		}}
+		var flags byte
+		if user != nil {
+			flags = snmpFlagAuth
+		}
+		return a.marshalV3(m, user, flags, pdu)
+	}
+
+	if !bytes.Equal(m.params.engineId, a.engineId) {
+		// how managers discover the engine ID, boots and time
+		return report(usmStatsUnknownEngineIDs, nil)
+	}
+	user, ok := a.users[string(m.params.user)]
+	if !ok {
+		return report(usmStatsUnknownUserNames, nil)
+	}
+	// a user is answered at any level it has the keys for, as RFC 3414 has
+	// it, so an authPriv user can walk at authNoPriv; but with no access
+	// control beyond USM, one with auth is never answered without it
+	if level > user.securityLevel() || (user.auth != nil && level&snmpFlagAuth == 0) {
+		return report(usmStatsUnsupportedSecLevels, nil)
+	}
+	if level&snmpFlagAuth != 0 {
+		if !m.authentic(user, packet) {
+			return report(usmStatsWrongDigests, nil)
+		}
+		if level&snmpFlagPriv == 0 {
+			readRequestId()
+		}
+		if !a.inTimeWindow(m.params) {
+			return report(usmStatsNotInTimeWindows, &user)
+		}
+	}
+	scoped := m.data
+	if level&snmpFlagPriv != 0 {
+		if scoped, err = m.decryptScopedPDU(user); err != nil {
+			return report(usmStatsDecryptionErrors, nil)
+		}
+	}
+	_, request, err := unmarshalScopedPDU(scoped)
+	if err != nil {
+		logger.Debugf("SNMP: ignoring a malformed v3 request: %s", err)
+		return nil
+	}
+	response, ok := a.respond(request)
+	if !ok {
+		return nil
+	}
+	maxSize := int(min(max(m.header.maxSize, 484), snmpMaxUDP))
+	return fitSNMPResponse(request, response, maxSize, func(pdu snmpPDU) []byte {
+		return a.marshalV3(m, &user, level, pdu)
+	})
+}
+
+// marshalV3 encodes a response or report to a v3 request, signed and
+// encrypted as flags say.
+func (a *snmpAgent) marshalV3(request snmpV3Message, user *snmpUser, flags byte, pdu snmpPDU) []byte {
+	boots, engineTime := a.boots, a.engineTime()
+	m := snmpV3Message{
+		header: snmpV3Header{msgId: request.header.msgId, maxSize: snmpMaxUDP, flags: flags, securityModel: snmpUSM},
+		params: usmParams{engineId: a.engineId, boots: boots, time: engineTime, user: request.params.user},
+		data:   marshalScopedPDU(a.engineId, pdu),
+	}
+	if flags&snmpFlagAuth != 0 {
+		m.params.auth = make([]byte, user.auth.macLen)
+	}
+	if flags&snmpFlagPriv != 0 {
+		a.mu.Lock()
+		a.salt++
+		salt := binary.BigEndian.AppendUint64(nil, a.salt)
+		a.mu.Unlock()
+		data, err := encryptScopedPDU(*user, boots, engineTime, salt, m.data)
+		if err != nil {
+			logger.Errorf("SNMP: encrypting a response: %s", err)
+			return nil
+		}
+		m.params.priv, m.data = salt, data
+	}
+	return m.marshal(user)
+}
+
+func (a *snmpAgent) engineTime() int64 {
+	return min(int64(a.now().Sub(a.started).Seconds()), math.MaxInt32)
+}
+
+// inTimeWindow checks an authenticated message's engine boots and time
+// against the agent's, which stops old messages being replayed.
+func (a *snmpAgent) inTimeWindow(p usmParams) bool {
+	if p.boots != a.boots || a.boots == math.MaxInt32 {
+		return false
+	}
+	diff := p.time - a.engineTime()
+	return diff >= -snmpTimeWindow && diff <= snmpTimeWindow
+}
+
+// fitSNMPResponse encodes a response, making it fit maxSize: a GetBulk
+// response loses varbinds from the end, and any other becomes tooBig.
+func fitSNMPResponse(request, response snmpPDU, maxSize int, encode func(snmpPDU) []byte) []byte {
+	msg := encode(response)
+	for len(msg) > maxSize {
+		if request.typ != snmpGetBulkRequest || len(response.varbinds) <= 1 {
+			return encode(snmpPDU{typ: snmpResponse, requestId: request.requestId, errorStatus: snmpTooBig})
+		}
+		response.varbinds = response.varbinds[:len(response.varbinds)-1]
+		msg = encode(response)
+	}
+	return msg
+}
+
+// respond answers a Get, GetNext or GetBulk. Sets are refused, since
+// nothing is writable; ok is false for PDUs that get no response at all.
+func (a *snmpAgent) respond(request snmpPDU) (response snmpPDU, ok bool) {
+	response = snmpPDU{typ: snmpResponse, requestId: request.requestId}
+	mib := a.mib()
+	switch request.typ {
+	case snmpGetRequest:
+		for _, v := range request.varbinds {
+			response.varbinds = append(response.varbinds, mib.get(v.oid))
+		}
+	case snmpGetNextRequest:
+		for _, v := range request.varbinds {
+			response.varbinds = append(response.varbinds, mib.next(v.oid))
+		}
+	case snmpGetBulkRequest:
+		nonRepeaters := int(min(max(request.errorStatus, 0), int64(len(request.varbinds))))
+		maxRepetitions := int(min(max(request.errorIndex, 0), 1000))
+		for _, v := range request.varbinds[:nonRepeaters] {
+			response.varbinds = append(response.varbinds, mib.next(v.oid))
+		}
+		repeaters := request.varbinds[nonRepeaters:]
+		last := make([]snmpOID, len(repeaters))
+		for i, v := range repeaters {
+			last[i] = v.oid
+		}
+		for r := 0; r < maxRepetitions && len(repeaters) > 0; r++ {
+			done := true
+			for i := range last {
+				next := mib.next(last[i])
+				response.varbinds = append(response.varbinds, next)
+				last[i] = next.oid
+				done = done && next.tag == snmpEndOfMibView
+			}
+			if done {
+				break
+			}
+		}
+	case snmpSetRequest:
+		response.varbinds = request.varbinds
+		for i := range response.varbinds {
+			response.varbinds[i].tag = berNull
+		}
+		response.errorStatus, response.errorIndex = snmpNotWritable, 1
+	default:
+		return response, false
+	}
+	return response, true
+}
+
+// snmpMIBValue is an instance the agent serves, with the OID of the object
+// it's an instance of.
+type snmpMIBValue struct {
+	object snmpOID
+	snmpVarbind
+}
+
+// snmpMIB is every instance the agent serves, in OID order.
+type snmpMIB []snmpMIBValue
+
+func (mib snmpMIB) get(oid snmpOID) snmpVarbind {
+	tag := snmpNoSuchObject
+	for _, v := range mib {
+		if v.oid.compare(oid) == 0 {
+			return v.snmpVarbind
+		}
+		if oid.hasPrefix(v.object) {
+			tag = snmpNoSuchInstance
+		}
+	}
+	return snmpVarbind{oid: oid, tag: tag}
+}
+
+func (mib snmpMIB) next(oid snmpOID) snmpVarbind {
+	for _, v := range mib {
+		if v.oid.compare(oid) > 0 {
+			return v.snmpVarbind
+		}
+	}
+	return snmpVarbind{oid: oid, tag: snmpEndOfMibView}
+}
+
+// paSensorRow is what a row of paSensorTable is made from.
+type paSensorRow struct {
+	status   *purpleAirStatus
+	age      time.Duration
+	stale    bool
+	readings uint32
+}
+
+// paSensorColumns are the columns of paSensorTable after its index, in
+// order from 2. Numbers are float64s, which snmpValue rounds and clamps.
+var paSensorColumns = []struct {
+	tag   byte
+	value func(r paSensorRow) interface{}
+}{
+	{berOctetString, func(r paSensorRow) interface{} { return []byte(r.status.Geo) }},
+	{berOctetString, func(r paSensorRow) interface{} { return []byte(r.status.SensorId) }},
+	{snmpGauge32, func(r paSensorRow) interface{} { return float64(r.status.EPAAQI) }},
+	{berInteger, func(r paSensorRow) interface{} {
+		for i, c := range aqiCategories {
+			if c.Category == r.status.EPAAQICategory {
+				return float64(i + 1)
+			}
+		}
+		return float64(0)
+	}},
+	{snmpGauge32, func(r paSensorRow) interface{} { return float64(r.status.PM10Atm) * 10 }},
+	{snmpGauge32, func(r paSensorRow) interface{} { return float64(r.status.PM25Atm) * 10 }},
+	{snmpGauge32, func(r paSensorRow) interface{} { return float64(r.status.PM100Atm) * 10 }},
+	{snmpGauge32, func(r paSensorRow) interface{} { return float64(r.status.A.PM25Atm) * 10 }},
+	{snmpGauge32, func(r paSensorRow) interface{} { return float64(r.status.B.PM25Atm) * 10 }},
+	{berInteger, func(r paSensorRow) interface{} { return float64(r.status.Temperature) * 10 }},
+	{snmpGauge32, func(r paSensorRow) interface{} { return float64(r.status.Humidity) * 10 }},
+	{snmpGauge32, func(r paSensorRow) interface{} { return float64(r.status.Pressure) * 10 }},
+	{berInteger, func(r paSensorRow) interface{} { return float64(r.status.RSSI) }},
+	{snmpTimeTicks, func(r paSensorRow) interface{} { return float64(r.status.Uptime) * 100 }},
+	{snmpGauge32, func(r paSensorRow) interface{} { return r.age.Seconds() }},
+	{berInteger, func(r paSensorRow) interface{} {
+		if r.stale {
+			return float64(paPollStale)
+		}
+		return float64(paPollOK)
+	}},
+	{snmpCounter32, func(r paSensorRow) interface{} { return uint64(r.readings) }},
+}
+
+// snmpValue turns a column's value into a varbind value for its tag,
+// rounding and clamping floats to the tag's range.
+func snmpValue(tag byte, value interface{}) interface{} {
+	f, ok := value.(float64)
+	if !ok {
+		return value
+	}
+	f = math.Round(f)
+	if tag == berInteger {
+		return int64(min(max(f, math.MinInt32), math.MaxInt32))
+	}
+	return uint64(min(max(f, 0), math.MaxUint32))
+}
+
+// mib snapshots every instance the agent serves.
+func (a *snmpAgent) mib() snmpMIB {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+
+	var mib snmpMIB
+	add := func(object snmpOID, instance snmpOID, tag byte, value interface{}) {
+		mib = append(mib, snmpMIBValue{object: object, snmpVarbind: snmpVarbind{
+			oid: append(object.append(), instance...), tag: tag, value: snmpValue(tag, value),
+		}})
+	}
+	scalar := func(object snmpOID, tag byte, value interface{}) { add(object, snmpOID{0}, tag, value) }
+
+	hostname, _ := os.Hostname()
+	scalar(snmpSystem.append(1), berOctetString, []byte("purpleair2mqtt "+version))
+	scalar(snmpSystem.append(2), berOID, purpleAirMIB)
+	scalar(snmpSystem.append(3), snmpTimeTicks, uint64(now.Sub(a.started)/(10*time.Millisecond))%(1<<32))
+	scalar(snmpSystem.append(4), berOctetString, []byte(a.cfg.Contact))
+	scalar(snmpSystem.append(5), berOctetString, []byte(hostname))
+	scalar(snmpSystem.append(6), berOctetString, []byte(a.cfg.Location))
+	scalar(snmpSystem.append(7), berInteger, int64(72)) // applications and end-to-end
+
+	scalar(paSensorCount, snmpGauge32, uint64(len(a.sensors)))
+	staleAfter := time.Duration(a.cfg.StaleAfter) * time.Second
+	for i, column := range paSensorColumns {
+		for _, s := range a.sensors {
+			age := now.Sub(s.receivedAt)
+			row := paSensorRow{status: s.status, age: age, stale: age >= staleAfter, readings: s.readings}
+			add(paSensorEntry.append(uint32(i+2)), snmpOID{uint32(s.index)}, column.tag, column.value(row))
+		}
+	}
+
+	if len(a.users) > 0 {
+		for counter := usmStatsUnsupportedSecLevels; counter <= usmStatsDecryptionErrors; counter++ {
+			scalar(snmpUSMStats.append(uint32(counter)), snmpCounter32, uint64(a.stats[counter]))
+		}
+	}
+	return mib
+}
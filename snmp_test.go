@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBEREncoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		got      []byte
+		expected string
+	}{
+		{"Zero", berSigned(0), "00"},
+		{"Negative", berSigned(-1), "ff"},
+		{"Needs a sign octet", berSigned(128), "0080"},
+		{"Large negative", berSigned(-129), "ff7f"},
+		{"Unsigned with high bit", berUnsigned(0xffffffff), "00ffffffff"},
+		{"Unsigned", berUnsigned(300), "012c"},
+		{"OID", berEncodeOID(mustParseSNMPOID("1.3.6.1.4.1.8072.9999")), "2b06010401bf08ce0f"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hex.EncodeToString(tt.got); got != tt.expected {
+				t.Errorf("encoded = %s, want %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSNMPPDURoundTrip(t *testing.T) {
+	pdu := snmpPDU{typ: snmpResponse, requestId: -5, varbinds: []snmpVarbind{
+		{oid: mustParseSNMPOID("1.3.6.1.2.1.1.1.0"), tag: berOctetString, value: []byte("purpleair2mqtt")},
+		{oid: mustParseSNMPOID("1.3.6.1.2.1.1.2.0"), tag: berOID, value: purpleAirMIB},
+		{oid: mustParseSNMPOID("1.3.6.1.2.1.1.3.0"), tag: snmpTimeTicks, value: uint64(4294967295)},
+		{oid: mustParseSNMPOID("1.3.6.1.2.1.1.7.0"), tag: berInteger, value: int64(-72)},
+		{oid: mustParseSNMPOID("1.3.6.1.2.1.1.8.0"), tag: snmpNoSuchObject},
+	}}
+	got, err := unmarshalSNMPPDU(pdu.marshal())
+	if err != nil {
+		t.Fatalf("unmarshalSNMPPDU() error = %v", err)
+	}
+	if !bytes.Equal(got.marshal(), pdu.marshal()) {
+		t.Errorf("round trip = %+v, want %+v", got, pdu)
+	}
+}
+
+func TestSNMPLocalizedKey(t *testing.T) {
+	// RFC 3414 appendix A.3.2
+	engineId, _ := hex.DecodeString("000000000000000000000002")
+	key := snmpLocalizedKey(snmpAuthProtocols["SHA"].hash, "maplesyrup", engineId)
+	if got := hex.EncodeToString(key); got != "6695febc9288e36282235fc7151f128497b38f3f" {
+		t.Errorf("localized key = %s", got)
+	}
+}
+
+func newTestSNMPAgent(t *testing.T, cfg tomlConfigSNMP) *snmpAgent {
+	t.Helper()
+	cfg.EngineId = "80001f880474657374"
+	a, err := newSNMPAgent(cfg)
+	if err != nil {
+		t.Fatalf("newSNMPAgent() error = %v", err)
+	}
+	status := &purpleAirStatus{Geo: "Back yard", SensorId: "84:f3:eb:00:00:01", EPAAQI: 57, EPAAQICategory: "Moderate", PM25Atm: 13.52, RSSI: -61, Uptime: 3600}
+	status.Temperature = -4
+	if err := a.Publish(context.Background(), status); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	return a
+}
+
+func v2cRequest(community string, pdu snmpPDU) []byte {
+	var msg []byte
+	msg = berAppend(msg, berInteger, berSigned(1))
+	msg = berAppend(msg, berOctetString, []byte(community))
+	msg = append(msg, pdu.marshal()...)
+	return berAppend(nil, berSequence, msg)
+}
+
+func v2cResponse(t *testing.T, msg []byte) snmpPDU {
+	t.Helper()
+	content, _, err := berExpect(msg, berSequence)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, content, err = berExpectInteger(content); err != nil {
+		t.Fatal(err)
+	}
+	if _, content, err = berExpect(content, berOctetString); err != nil {
+		t.Fatal(err)
+	}
+	pdu, err := unmarshalSNMPPDU(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pdu
+}
+
+func snmpOIDs(oids ...string) []snmpVarbind {
+	var varbinds []snmpVarbind
+	for _, oid := range oids {
+		varbinds = append(varbinds, snmpVarbind{oid: mustParseSNMPOID(oid), tag: berNull})
+	}
+	return varbinds
+}
+
+func TestSNMPAgentV2c(t *testing.T) {
+	a := newTestSNMPAgent(t, tomlConfigSNMP{Community: "public"})
+	entry := paSensorEntry.String()
+
+	tests := []struct {
+		name     string
+		pdu      snmpPDU
+		expected []snmpVarbind
+		status   int64
+	}{
+		{"Get", snmpPDU{typ: snmpGetRequest, varbinds: snmpOIDs(
+			entry+".2.1", entry+".4.1", entry+".5.1", entry+".7.1", entry+".11.1", entry+".14.1", entry+".15.1",
+			entry+".17.1", entry+".18.1", paSensorCount.String()+".0",
+		)}, []snmpVarbind{
+			{tag: berOctetString, value: []byte("Back yard")},
+			{tag: snmpGauge32, value: uint64(57)},
+			{tag: berInteger, value: int64(2)},
+			{tag: snmpGauge32, value: uint64(135)},
+			{tag: berInteger, value: int64(-40)},
+			{tag: berInteger, value: int64(-61)},
+			{tag: snmpTimeTicks, value: uint64(360000)},
+			{tag: berInteger, value: int64(paPollOK)},
+			{tag: snmpCounter32, value: uint64(1)},
+			{tag: snmpGauge32, value: uint64(1)},
+		}, snmpNoError},
+		{"Missing instances", snmpPDU{typ: snmpGetRequest, varbinds: snmpOIDs(entry+".4.2", "1.3.6.1.2.1.2.1.0")},
+			[]snmpVarbind{{tag: snmpNoSuchInstance}, {tag: snmpNoSuchObject}}, snmpNoError},
+		{"GetNext", snmpPDU{typ: snmpGetNextRequest, varbinds: snmpOIDs(entry+".2", entry+".18.1")},
+			[]snmpVarbind{{tag: berOctetString, value: []byte("Back yard")}, {tag: snmpEndOfMibView}}, snmpNoError},
+		{"GetBulk", snmpPDU{typ: snmpGetBulkRequest, errorStatus: 1, errorIndex: 2, varbinds: snmpOIDs("1.3.6.1.2.1.1", entry+".3")},
+			[]snmpVarbind{
+				{tag: berOctetString, value: []byte("purpleair2mqtt " + version)},
+				{tag: berOctetString, value: []byte("84:f3:eb:00:00:01")},
+				{tag: snmpGauge32, value: uint64(57)},
+			}, snmpNoError},
+		{"Set", snmpPDU{typ: snmpSetRequest, varbinds: snmpOIDs(entry + ".2.1")},
+			[]snmpVarbind{{tag: berNull}}, snmpNotWritable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.pdu.requestId = 42
+			response := v2cResponse(t, a.handle(v2cRequest("public", tt.pdu)))
+			if response.typ != snmpResponse || response.requestId != 42 || response.errorStatus != tt.status {
+				t.Errorf("response = %+v", response)
+			}
+			if len(response.varbinds) != len(tt.expected) {
+				t.Fatalf("varbinds = %+v, want %d", response.varbinds, len(tt.expected))
+			}
+			for i, v := range response.varbinds {
+				if v.tag != tt.expected[i].tag || !bytes.Equal(snmpVarbind{tag: v.tag, value: v.value}.marshal(), snmpVarbind{tag: v.tag, value: tt.expected[i].value}.marshal()) {
+					t.Errorf("varbind %d (%s) = %#x %v, want %#x %v", i, v.oid, v.tag, v.value, tt.expected[i].tag, tt.expected[i].value)
+				}
+			}
+		})
+	}
+
+	if reply := a.handle(v2cRequest("private", snmpPDU{typ: snmpGetRequest, varbinds: snmpOIDs("1.3.6.1.2.1.1.1.0")})); reply != nil {
+		t.Errorf("answered a request with the wrong community")
+	}
+}
+
+func TestSNMPAgentStale(t *testing.T) {
+	a := newTestSNMPAgent(t, tomlConfigSNMP{Community: "public", StaleAfter: 60})
+	a.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	oid := paSensorEntry.append(17, 1)
+	if v := a.mib().get(oid); v.value != int64(paPollStale) {
+		t.Errorf("paSensorPollStatus = %v, want stale", v.value)
+	}
+}
+
+// v3Client builds SNMPv3 requests as a manager would.
+type v3Client struct {
+	user             snmpUser
+	engineId         []byte
+	boots, time      int64
+	flags            byte
+	msgId, requestId int64
+}
+
+func (c *v3Client) request(t *testing.T, oids ...string) []byte {
+	t.Helper()
+	c.msgId++
+	c.requestId++
+	m := snmpV3Message{
+		header: snmpV3Header{msgId: c.msgId, maxSize: 65507, flags: c.flags | snmpFlagReportable, securityModel: snmpUSM},
+		params: usmParams{engineId: c.engineId, boots: c.boots, time: c.time, user: []byte(c.user.name)},
+		data:   marshalScopedPDU(c.engineId, snmpPDU{typ: snmpGetRequest, requestId: c.requestId, varbinds: snmpOIDs(oids...)}),
+	}
+	if c.flags&snmpFlagAuth != 0 {
+		m.params.auth = make([]byte, c.user.auth.macLen)
+	}
+	if c.flags&snmpFlagPriv != 0 {
+		m.params.priv = []byte{1, 2, 3, 4, 5, 6, 7, byte(c.msgId)}
+		data, err := encryptScopedPDU(c.user, c.boots, c.time, m.params.priv, m.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.data = data
+	}
+	return m.marshal(&c.user)
+}
+
+// response decodes a response or report, checking its MAC and decrypting it.
+func (c *v3Client) response(t *testing.T, msg []byte) (snmpV3Message, snmpPDU) {
+	t.Helper()
+	if msg == nil {
+		t.Fatal("no response")
+	}
+	m, err := unmarshalSNMPv3(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.header.msgId != c.msgId {
+		t.Errorf("msgID = %d, want %d", m.header.msgId, c.msgId)
+	}
+	if m.header.flags&snmpFlagAuth != 0 && !m.authentic(c.user, msg) {
+		t.Errorf("response isn't authentic")
+	}
+	scoped := m.data
+	if m.header.flags&snmpFlagPriv != 0 {
+		if scoped, err = m.decryptScopedPDU(c.user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, pdu, err := unmarshalScopedPDU(scoped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, pdu
+}
+
+func checkSNMPReport(t *testing.T, pdu snmpPDU, counter int) {
+	t.Helper()
+	if pdu.typ != snmpReport || len(pdu.varbinds) != 1 || pdu.varbinds[0].oid.compare(snmpUSMStats.append(uint32(counter), 0)) != 0 {
+		t.Errorf("response = %+v, want a report of usmStats %d", pdu, counter)
+	}
+}
+
+func TestSNMPAgentV3(t *testing.T) {
+	a := newTestSNMPAgent(t, tomlConfigSNMP{Users: []tomlConfigSNMPUser{
+		{Name: "noc", AuthProtocol: "SHA-256", AuthPassword: "authpass1", PrivProtocol: "AES", PrivPassword: "privpass1"},
+		{Name: "librenms", AuthProtocol: "SHA", AuthPassword: "authpass2"},
+	}})
+	aqi := paSensorEntry.append(4, 1).String()
+
+	// discovery
+	c := &v3Client{}
+	m, pdu := c.response(t, a.handle(c.request(t)))
+	checkSNMPReport(t, pdu, usmStatsUnknownEngineIDs)
+	if !bytes.Equal(m.params.engineId, a.engineId) || m.params.boots != a.boots || pdu.requestId != c.requestId {
+		t.Errorf("discovery report = %+v with request ID %d", m.params, pdu.requestId)
+	}
+	engineId, boots, engineTime := m.params.engineId, m.params.boots, m.params.time
+
+	for _, cfg := range a.cfg.Users {
+		t.Run(cfg.Name, func(t *testing.T) {
+			user := newSNMPUser(cfg, engineId)
+			c := &v3Client{user: user, engineId: engineId, boots: boots, time: engineTime, flags: user.securityLevel()}
+			m, pdu := c.response(t, a.handle(c.request(t, aqi)))
+			if pdu.typ != snmpResponse || pdu.requestId != c.requestId || len(pdu.varbinds) != 1 || pdu.varbinds[0].value != uint64(57) {
+				t.Errorf("response = %+v", pdu)
+			}
+			if m.header.flags != user.securityLevel() || string(m.params.user) != cfg.Name {
+				t.Errorf("response flags = %#x, user = %s", m.header.flags, m.params.user)
+			}
+		})
+	}
+
+	t.Run("Wrong password", func(t *testing.T) {
+		user := newSNMPUser(tomlConfigSNMPUser{Name: "librenms", AuthProtocol: "SHA", AuthPassword: "guessing"}, engineId)
+		c := &v3Client{user: user, engineId: engineId, boots: boots, time: engineTime, flags: snmpFlagAuth}
+		m, pdu := c.response(t, a.handle(c.request(t, aqi)))
+		checkSNMPReport(t, pdu, usmStatsWrongDigests)
+		if m.header.flags&snmpFlagAuth != 0 {
+			t.Errorf("wrongDigests report is authenticated")
+		}
+		if pdu.requestId != 0 {
+			t.Errorf("wrongDigests report echoes the unauthenticated request ID %d", pdu.requestId)
+		}
+	})
+
+	t.Run("Not in time window", func(t *testing.T) {
+		user := newSNMPUser(a.cfg.Users[1], engineId)
+		c := &v3Client{user: user, engineId: engineId, boots: boots, time: engineTime + 300, flags: snmpFlagAuth}
+		m, pdu := c.response(t, a.handle(c.request(t, aqi)))
+		checkSNMPReport(t, pdu, usmStatsNotInTimeWindows)
+		// authenticated, so the manager can trust the time in it
+		if m.header.flags != snmpFlagAuth || m.params.time != engineTime || pdu.requestId != c.requestId {
+			t.Errorf("notInTimeWindows report flags = %#x, time = %d, request ID = %d", m.header.flags, m.params.time, pdu.requestId)
+		}
+	})
+
+	t.Run("Below the user's security level", func(t *testing.T) {
+		user := newSNMPUser(a.cfg.Users[0], engineId)
+		c := &v3Client{user: user, engineId: engineId, boots: boots, time: engineTime, flags: snmpFlagAuth}
+		m, pdu := c.response(t, a.handle(c.request(t, aqi)))
+		if pdu.typ != snmpResponse || len(pdu.varbinds) != 1 || pdu.varbinds[0].value != uint64(57) {
+			t.Errorf("authNoPriv response for an authPriv user = %+v", pdu)
+		}
+		if m.header.flags != snmpFlagAuth {
+			t.Errorf("response flags = %#x, want the request's authNoPriv", m.header.flags)
+		}
+	})
+
+	t.Run("Above the user's security level", func(t *testing.T) {
+		user := newSNMPUser(a.cfg.Users[1], engineId)
+		user.privKey = user.authKey[:16]
+		c := &v3Client{user: user, engineId: engineId, boots: boots, time: engineTime, flags: snmpFlagAuth | snmpFlagPriv}
+		_, pdu := c.response(t, a.handle(c.request(t, aqi)))
+		checkSNMPReport(t, pdu, usmStatsUnsupportedSecLevels)
+	})
+
+	t.Run("Security level too low", func(t *testing.T) {
+		c := &v3Client{user: snmpUser{name: "noc"}, engineId: engineId, boots: boots, time: engineTime}
+		_, pdu := c.response(t, a.handle(c.request(t, aqi)))
+		checkSNMPReport(t, pdu, usmStatsUnsupportedSecLevels)
+	})
+
+	t.Run("Unknown user", func(t *testing.T) {
+		c := &v3Client{user: snmpUser{name: "admin"}, engineId: engineId, boots: boots, time: engineTime}
+		_, pdu := c.response(t, a.handle(c.request(t, aqi)))
+		checkSNMPReport(t, pdu, usmStatsUnknownUserNames)
+	})
+
+	if reply := a.handle(v2cRequest("public", snmpPDU{typ: snmpGetRequest, varbinds: snmpOIDs(aqi)})); reply != nil {
+		t.Errorf("answered v2c with no community configured")
+	}
+}
+
+func TestSNMPAgentServes(t *testing.T) {
+	a, err := startSNMPAgent(tomlConfigSNMP{Listen: "127.0.0.1:0", Community: "public"})
+	if err != nil {
+		t.Fatalf("startSNMPAgent() error = %v", err)
+	}
+	conn, err := net.Dial("udp", a.conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(v2cRequest("public", snmpPDU{typ: snmpGetRequest, requestId: 7, varbinds: snmpOIDs("1.3.6.1.2.1.1.2.0")})); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, snmpMaxUDP)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdu := v2cResponse(t, buf[:n])
+	if oid, ok := pdu.varbinds[0].value.(snmpOID); !ok || oid.compare(purpleAirMIB) != 0 {
+		t.Errorf("sysObjectID = %v, want %s", pdu.varbinds[0].value, purpleAirMIB)
+	}
+
+	if err := a.Close(context.Background()); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
+
+func TestSNMPMIBFile(t *testing.T) {
+	mib, err := os.ReadFile("mibs/PURPLEAIR2MQTT-MIB.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for column := 1; column <= len(paSensorColumns)+1; column++ {
+		if !bytes.Contains(mib, []byte(fmt.Sprintf("::= { paSensorEntry %d }", column))) {
+			t.Errorf("PURPLEAIR2MQTT-MIB has no paSensorEntry column %d", column)
+		}
+	}
+	if bytes.Contains(mib, []byte(fmt.Sprintf("::= { paSensorEntry %d }", len(paSensorColumns)+2))) {
+		t.Errorf("PURPLEAIR2MQTT-MIB has columns the agent doesn't serve")
+	}
+}
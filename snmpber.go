@@ -0,0 +1,367 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BER tags of the SNMP types, values and PDUs the agent reads and writes
+// (RFC 3416).
+const (
+	berInteger     byte = 0x02
+	berOctetString byte = 0x04
+	berNull        byte = 0x05
+	berOID         byte = 0x06
+	berSequence    byte = 0x30
+
+	snmpCounter32 byte = 0x41
+	snmpGauge32   byte = 0x42
+	snmpTimeTicks byte = 0x43
+	snmpCounter64 byte = 0x46
+
+	snmpNoSuchObject   byte = 0x80
+	snmpNoSuchInstance byte = 0x81
+	snmpEndOfMibView   byte = 0x82
+
+	snmpGetRequest     byte = 0xa0
+	snmpGetNextRequest byte = 0xa1
+	snmpResponse       byte = 0xa2
+	snmpSetRequest     byte = 0xa3
+	snmpGetBulkRequest byte = 0xa5
+	snmpReport         byte = 0xa8
+)
+
+// SNMP error statuses the agent returns.
+const (
+	snmpNoError     = 0
+	snmpTooBig      = 1
+	snmpNotWritable = 17
+)
+
+var errBERTruncated = errors.New("truncated BER element")
+
+// snmpOID is an object identifier, e.g. 1.3.6.1.2.1.1.1.0.
+type snmpOID []uint32
+
+func parseSNMPOID(s string) (snmpOID, error) {
+	var oid snmpOID
+	for _, arc := range strings.Split(strings.TrimPrefix(s, "."), ".") {
+		n, err := strconv.ParseUint(arc, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an OID", s)
+		}
+		oid = append(oid, uint32(n))
+	}
+	if len(oid) < 2 {
+		return nil, fmt.Errorf("%q is not an OID", s)
+	}
+	return oid, nil
+}
+
+func mustParseSNMPOID(s string) snmpOID {
+	oid, err := parseSNMPOID(s)
+	if err != nil {
+		panic(err)
+	}
+	return oid
+}
+
+func (o snmpOID) String() string {
+	arcs := make([]string, len(o))
+	for i, arc := range o {
+		arcs[i] = strconv.FormatUint(uint64(arc), 10)
+	}
+	return strings.Join(arcs, ".")
+}
+
+// append returns a new OID with arcs added, leaving o as it is.
+func (o snmpOID) append(arcs ...uint32) snmpOID {
+	return append(append(snmpOID{}, o...), arcs...)
+}
+
+// compare orders OIDs lexicographically, as GetNext walks them.
+func (o snmpOID) compare(other snmpOID) int {
+	for i := 0; i < len(o) && i < len(other); i++ {
+		switch {
+		case o[i] < other[i]:
+			return -1
+		case o[i] > other[i]:
+			return 1
+		}
+	}
+	return len(o) - len(other)
+}
+
+func (o snmpOID) hasPrefix(prefix snmpOID) bool {
+	return len(o) >= len(prefix) && o[:len(prefix)].compare(prefix) == 0
+}
+
+// snmpVarbind is a variable binding. value holds an int64 for INTEGER, a
+// uint64 for the counter, gauge and time types, a []byte for OCTET STRING, an
+// snmpOID, or nil for NULL and the exceptions.
+type snmpVarbind struct {
+	oid   snmpOID
+	tag   byte
+	value interface{}
+}
+
+// snmpPDU is any SNMP PDU. For GetBulk, errorStatus and errorIndex hold
+// non-repeaters and max-repetitions.
+type snmpPDU struct {
+	typ         byte
+	requestId   int64
+	errorStatus int64
+	errorIndex  int64
+	varbinds    []snmpVarbind
+}
+
+// berAppend appends a complete element: its tag, its length and content.
+func berAppend(b []byte, tag byte, content []byte) []byte {
+	b = append(b, tag)
+	switch n := len(content); {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n <= 0xff:
+		b = append(b, 0x81, byte(n))
+	case n <= 0xffff:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0x83, byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(b, content...)
+}
+
+// berSigned encodes v in the fewest two's complement octets.
+func berSigned(v int64) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(v)}, b...)
+		if (v >= -0x80 && v < 0x80) || len(b) == 8 {
+			return b
+		}
+		v >>= 8
+	}
+}
+
+// berUnsigned encodes v as the application types do, with a leading zero
+// octet when its high bit would otherwise read as a sign.
+func berUnsigned(v uint64) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(v)}, b...)
+		v >>= 8
+		if v == 0 {
+			break
+		}
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return b
+}
+
+func berEncodeOID(oid snmpOID) []byte {
+	if len(oid) < 2 {
+		return []byte{0}
+	}
+	var b []byte
+	arcs := append(snmpOID{oid[0]*40 + oid[1]}, oid[2:]...)
+	for _, arc := range arcs {
+		var enc []byte
+		enc = append(enc, byte(arc&0x7f))
+		for arc >>= 7; arc > 0; arc >>= 7 {
+			enc = append([]byte{byte(arc&0x7f) | 0x80}, enc...)
+		}
+		b = append(b, enc...)
+	}
+	return b
+}
+
+func (v snmpVarbind) marshal() []byte {
+	var value []byte
+	switch v.tag {
+	case berInteger:
+		value = berAppend(nil, v.tag, berSigned(v.value.(int64)))
+	case snmpCounter32, snmpGauge32, snmpTimeTicks, snmpCounter64:
+		value = berAppend(nil, v.tag, berUnsigned(v.value.(uint64)))
+	case berOctetString:
+		value = berAppend(nil, v.tag, v.value.([]byte))
+	case berOID:
+		value = berAppend(nil, v.tag, berEncodeOID(v.value.(snmpOID)))
+	default:
+		value = berAppend(nil, v.tag, nil)
+	}
+	return berAppend(nil, berSequence, append(berAppend(nil, berOID, berEncodeOID(v.oid)), value...))
+}
+
+func (p snmpPDU) marshal() []byte {
+	var varbinds []byte
+	for _, v := range p.varbinds {
+		varbinds = append(varbinds, v.marshal()...)
+	}
+	var b []byte
+	b = berAppend(b, berInteger, berSigned(p.requestId))
+	b = berAppend(b, berInteger, berSigned(p.errorStatus))
+	b = berAppend(b, berInteger, berSigned(p.errorIndex))
+	b = berAppend(b, berSequence, varbinds)
+	return berAppend(nil, p.typ, b)
+}
+
+// berNext splits the first element off b, returning its tag and content and
+// what follows it. Only the definite lengths SNMP allows are accepted.
+func berNext(b []byte) (tag byte, content, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errBERTruncated
+	}
+	tag, length, b := b[0], int(b[1]), b[2:]
+	if length&0x80 != 0 {
+		octets := length & 0x7f
+		if octets == 0 || octets > 3 || len(b) < octets {
+			return 0, nil, nil, fmt.Errorf("unsupported BER length")
+		}
+		length = 0
+		for _, o := range b[:octets] {
+			length = length<<8 | int(o)
+		}
+		b = b[octets:]
+	}
+	if len(b) < length {
+		return 0, nil, nil, errBERTruncated
+	}
+	return tag, b[:length], b[length:], nil
+}
+
+// berExpect splits off the next element, which must have the given tag.
+func berExpect(b []byte, tag byte) (content, rest []byte, err error) {
+	got, content, rest, err := berNext(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if got != tag {
+		return nil, nil, fmt.Errorf("expected BER tag %#x, found %#x", tag, got)
+	}
+	return content, rest, nil
+}
+
+func berParseInteger(content []byte) (int64, error) {
+	if len(content) == 0 || len(content) > 8 {
+		return 0, fmt.Errorf("invalid BER integer")
+	}
+	v := int64(int8(content[0]))
+	for _, o := range content[1:] {
+		v = v<<8 | int64(o)
+	}
+	return v, nil
+}
+
+// berExpectInteger splits off the next element, which must be an INTEGER.
+func berExpectInteger(b []byte) (int64, []byte, error) {
+	content, rest, err := berExpect(b, berInteger)
+	if err != nil {
+		return 0, nil, err
+	}
+	v, err := berParseInteger(content)
+	return v, rest, err
+}
+
+func berParseOID(content []byte) (snmpOID, error) {
+	var oid snmpOID
+	var arc uint64
+	for i, o := range content {
+		arc = arc<<7 | uint64(o&0x7f)
+		if arc > 0xffffffff {
+			return nil, fmt.Errorf("invalid OID")
+		}
+		if o&0x80 != 0 {
+			if i == len(content)-1 {
+				return nil, fmt.Errorf("invalid OID")
+			}
+			continue
+		}
+		if len(oid) == 0 {
+			first := min(arc/40, 2)
+			oid = append(oid, uint32(first), uint32(arc-first*40))
+		} else {
+			oid = append(oid, uint32(arc))
+		}
+		arc = 0
+	}
+	if len(oid) == 0 {
+		return nil, fmt.Errorf("invalid OID")
+	}
+	return oid, nil
+}
+
+// unmarshalSNMPPDU decodes a PDU. Values of types the agent doesn't serve,
+// like IpAddress, are decoded as nil.
+func unmarshalSNMPPDU(b []byte) (snmpPDU, error) {
+	var p snmpPDU
+	tag, content, _, err := berNext(b)
+	if err != nil {
+		return p, err
+	}
+	if tag&0xe0 != 0xa0 {
+		return p, fmt.Errorf("expected a PDU, found BER tag %#x", tag)
+	}
+	p.typ = tag
+	if p.requestId, content, err = berExpectInteger(content); err != nil {
+		return p, err
+	}
+	if p.errorStatus, content, err = berExpectInteger(content); err != nil {
+		return p, err
+	}
+	if p.errorIndex, content, err = berExpectInteger(content); err != nil {
+		return p, err
+	}
+	varbinds, _, err := berExpect(content, berSequence)
+	if err != nil {
+		return p, err
+	}
+	for len(varbinds) > 0 {
+		var varbind, name []byte
+		if varbind, varbinds, err = berExpect(varbinds, berSequence); err != nil {
+			return p, err
+		}
+		if name, varbind, err = berExpect(varbind, berOID); err != nil {
+			return p, err
+		}
+		oid, err := berParseOID(name)
+		if err != nil {
+			return p, err
+		}
+		tag, content, _, err := berNext(varbind)
+		if err != nil {
+			return p, err
+		}
+		value, err := berParseValue(tag, content)
+		if err != nil {
+			return p, err
+		}
+		p.varbinds = append(p.varbinds, snmpVarbind{oid: oid, tag: tag, value: value})
+	}
+	return p, nil
+}
+
+// berParseValue decodes a varbind value as snmpVarbind holds it.
+func berParseValue(tag byte, content []byte) (interface{}, error) {
+	switch tag {
+	case berInteger:
+		return berParseInteger(content)
+	case snmpCounter32, snmpGauge32, snmpTimeTicks, snmpCounter64:
+		if len(content) == 0 || len(content) > 9 {
+			return nil, fmt.Errorf("invalid BER unsigned integer")
+		}
+		var v uint64
+		for _, o := range content {
+			v = v<<8 | uint64(o)
+		}
+		return v, nil
+	case berOctetString:
+		return content, nil
+	case berOID:
+		return berParseOID(content)
+	}
+	return nil, nil
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+)
+
+// SNMPv3 msgFlags.
+const (
+	snmpFlagAuth       byte = 0x01
+	snmpFlagPriv       byte = 0x02
+	snmpFlagReportable byte = 0x04
+)
+
+// snmpUSM is the User-based Security Model's msgSecurityModel.
+const snmpUSM = 3
+
+// snmpTimeWindow is how far, in seconds, an authenticated message's engine
+// time may be from the agent's (RFC 3414 section 3.2).
+const snmpTimeWindow = 150
+
+// snmpAuthProtocol is a USM authentication protocol: HMAC-SHA-96 (RFC 3414)
+// or HMAC-192-SHA-256 (RFC 7860).
+type snmpAuthProtocol struct {
+	hash   func() hash.Hash
+	macLen int
+}
+
+var snmpAuthProtocols = map[string]snmpAuthProtocol{
+	"SHA":     {sha1.New, 12},
+	"SHA-256": {sha256.New, 24},
+}
+
+// snmpPrivAES is the only privacy protocol supported: AES-128 in CFB mode
+// (RFC 3826).
+const snmpPrivAES = "AES"
+
+// snmpUser is a USM user with its keys localized to the agent's engine ID.
+// A user without auth is noAuthNoPriv, and one with auth but no privKey is
+// authNoPriv.
+type snmpUser struct {
+	name    string
+	auth    *snmpAuthProtocol
+	authKey []byte
+	privKey []byte
+}
+
+// securityLevel returns the msgFlags a user's messages must carry.
+func (u snmpUser) securityLevel() byte {
+	var flags byte
+	if u.auth != nil {
+		flags |= snmpFlagAuth
+	}
+	if u.privKey != nil {
+		flags |= snmpFlagPriv
+	}
+	return flags
+}
+
+func newSNMPUser(cfg tomlConfigSNMPUser, engineId []byte) snmpUser {
+	u := snmpUser{name: cfg.Name}
+	proto, ok := snmpAuthProtocols[cfg.AuthProtocol]
+	if !ok || cfg.AuthPassword == "" {
+		return u
+	}
+	u.auth = &proto
+	u.authKey = snmpLocalizedKey(proto.hash, cfg.AuthPassword, engineId)
+	if cfg.PrivProtocol == snmpPrivAES && cfg.PrivPassword != "" {
+		u.privKey = snmpLocalizedKey(proto.hash, cfg.PrivPassword, engineId)[:16]
+	}
+	return u
+}
+
+// snmpLocalizedKey turns a password into a key for one engine, as RFC 3414
+// appendix A.2 describes: the password is repeated to a megabyte and hashed,
+// and that hash is hashed again around the engine ID.
+func snmpLocalizedKey(newHash func() hash.Hash, password string, engineId []byte) []byte {
+	h := newHash()
+	const expanded = 1 << 20
+	buf := make([]byte, 64)
+	for n := 0; n < expanded; n += len(buf) {
+		for i := range buf {
+			buf[i] = password[(n+i)%len(password)]
+		}
+		h.Write(buf)
+	}
+	key := h.Sum(nil)
+
+	h.Reset()
+	h.Write(key)
+	h.Write(engineId)
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+// snmpV3Header is an SNMPv3 message's HeaderData.
+type snmpV3Header struct {
+	msgId         int64
+	maxSize       int64
+	flags         byte
+	securityModel int64
+}
+
+// usmParams are a message's UsmSecurityParameters.
+type usmParams struct {
+	engineId []byte
+	boots    int64
+	time     int64
+	user     []byte
+	auth     []byte
+	priv     []byte
+}
+
+// snmpV3Message is a decoded SNMPv3 message. data is the msgData element:
+// a plaintext ScopedPDU, or an OCTET STRING holding an encrypted one.
+// authOffset is where the authentication parameters start in the raw
+// message, which is what the HMAC is computed over.
+type snmpV3Message struct {
+	header     snmpV3Header
+	params     usmParams
+	data       []byte
+	authOffset int
+}
+
+func unmarshalSNMPv3(msg []byte) (snmpV3Message, error) {
+	var m snmpV3Message
+	content, _, err := berExpect(msg, berSequence)
+	if err != nil {
+		return m, err
+	}
+	version, content, err := berExpectInteger(content)
+	if err != nil {
+		return m, err
+	}
+	if version != 3 {
+		return m, fmt.Errorf("SNMP version %d is not SNMPv3", version+1)
+	}
+
+	header, content, err := berExpect(content, berSequence)
+	if err != nil {
+		return m, err
+	}
+	if m.header.msgId, header, err = berExpectInteger(header); err != nil {
+		return m, err
+	}
+	if m.header.maxSize, header, err = berExpectInteger(header); err != nil {
+		return m, err
+	}
+	flags, header, err := berExpect(header, berOctetString)
+	if err != nil {
+		return m, err
+	}
+	if len(flags) != 1 {
+		return m, fmt.Errorf("invalid msgFlags")
+	}
+	m.header.flags = flags[0]
+	if m.header.securityModel, _, err = berExpectInteger(header); err != nil {
+		return m, err
+	}
+
+	params, content, err := berExpect(content, berOctetString)
+	if err != nil {
+		return m, err
+	}
+	if m.header.securityModel == snmpUSM {
+		if m.params, err = unmarshalUSMParams(params); err != nil {
+			return m, err
+		}
+		// the auth parameters are a subslice of msg, so their offset in it
+		// is the difference in capacity
+		m.authOffset = cap(msg) - cap(m.params.auth)
+	}
+
+	_, _, rest, err := berNext(content)
+	if err != nil {
+		return m, err
+	}
+	m.data = content[:len(content)-len(rest)]
+	return m, nil
+}
+
+func unmarshalUSMParams(b []byte) (usmParams, error) {
+	var p usmParams
+	content, _, err := berExpect(b, berSequence)
+	if err != nil {
+		return p, err
+	}
+	if p.engineId, content, err = berExpect(content, berOctetString); err != nil {
+		return p, err
+	}
+	if p.boots, content, err = berExpectInteger(content); err != nil {
+		return p, err
+	}
+	if p.time, content, err = berExpectInteger(content); err != nil {
+		return p, err
+	}
+	if p.user, content, err = berExpect(content, berOctetString); err != nil {
+		return p, err
+	}
+	if p.auth, content, err = berExpect(content, berOctetString); err != nil {
+		return p, err
+	}
+	if p.priv, _, err = berExpect(content, berOctetString); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// marshal encodes a message. If the user authenticates, the message is
+// signed, which needs params.auth to be zeros of the MAC's length.
+func (m snmpV3Message) marshal(user *snmpUser) []byte {
+	var params []byte
+	params = berAppend(params, berOctetString, m.params.engineId)
+	params = berAppend(params, berInteger, berSigned(m.params.boots))
+	params = berAppend(params, berInteger, berSigned(m.params.time))
+	params = berAppend(params, berOctetString, m.params.user)
+	params = berAppend(params, berOctetString, m.params.auth)
+	params = berAppend(params, berOctetString, m.params.priv)
+
+	var header []byte
+	header = berAppend(header, berInteger, berSigned(m.header.msgId))
+	header = berAppend(header, berInteger, berSigned(m.header.maxSize))
+	header = berAppend(header, berOctetString, []byte{m.header.flags})
+	header = berAppend(header, berInteger, berSigned(m.header.securityModel))
+
+	var b []byte
+	b = berAppend(b, berInteger, berSigned(3))
+	b = berAppend(b, berSequence, header)
+	b = berAppend(b, berOctetString, berAppend(nil, berSequence, params))
+	b = append(b, m.data...)
+	msg := berAppend(nil, berSequence, b)
+
+	if user != nil && user.auth != nil && m.header.flags&snmpFlagAuth != 0 {
+		signed, err := unmarshalSNMPv3(msg)
+		if err == nil {
+			copy(msg[signed.authOffset:], snmpMAC(*user, msg))
+		}
+	}
+	return msg
+}
+
+// snmpMAC computes a message's MAC; the authentication parameters in it
+// must be zeros.
+func snmpMAC(user snmpUser, msg []byte) []byte {
+	mac := hmac.New(user.auth.hash, user.authKey)
+	mac.Write(msg)
+	return mac.Sum(nil)[:user.auth.macLen]
+}
+
+// authentic checks a received message's MAC.
+func (m snmpV3Message) authentic(user snmpUser, raw []byte) bool {
+	if len(m.params.auth) != user.auth.macLen {
+		return false
+	}
+	zeroed := append([]byte{}, raw...)
+	clear(zeroed[m.authOffset : m.authOffset+user.auth.macLen])
+	return hmac.Equal(snmpMAC(user, zeroed), m.params.auth)
+}
+
+// snmpAESCipher is AES-128 in CFB mode with the IV RFC 3826 builds from the
+// sender's engine boots and time and a 64-bit salt.
+func snmpAESCipher(key []byte, boots, engineTime int64, salt []byte, decrypt bool) (cipher.Stream, error) {
+	if len(salt) != 8 {
+		return nil, fmt.Errorf("invalid privacy parameters")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, 0, aes.BlockSize)
+	iv = binary.BigEndian.AppendUint32(iv, uint32(boots))
+	iv = binary.BigEndian.AppendUint32(iv, uint32(engineTime))
+	iv = append(iv, salt...)
+	// CFB is deprecated in crypto/cipher, but it's what RFC 3826 specifies
+	if decrypt {
+		return cipher.NewCFBDecrypter(block, iv), nil
+	}
+	return cipher.NewCFBEncrypter(block, iv), nil
+}
+
+// decryptScopedPDU returns the plaintext ScopedPDU of an encrypted message.
+func (m snmpV3Message) decryptScopedPDU(user snmpUser) ([]byte, error) {
+	encrypted, _, err := berExpect(m.data, berOctetString)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := snmpAESCipher(user.privKey, m.params.boots, m.params.time, m.params.priv, true)
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(encrypted))
+	stream.XORKeyStream(plain, encrypted)
+	// CFB has no padding, but anything after the ScopedPDU is ignored as
+	// RFC 3826 allows
+	if _, _, _, err := berNext(plain); err != nil {
+		return nil, fmt.Errorf("decrypting: %w", err)
+	}
+	return plain, nil
+}
+
+// encryptScopedPDU returns the msgData for an encrypted message.
+func encryptScopedPDU(user snmpUser, boots, engineTime int64, salt []byte, scopedPDU []byte) ([]byte, error) {
+	stream, err := snmpAESCipher(user.privKey, boots, engineTime, salt, false)
+	if err != nil {
+		return nil, err
+	}
+	encrypted := make([]byte, len(scopedPDU))
+	stream.XORKeyStream(encrypted, scopedPDU)
+	return berAppend(nil, berOctetString, encrypted), nil
+}
+
+// marshalScopedPDU encodes a ScopedPDU for the default context.
+func marshalScopedPDU(engineId []byte, pdu snmpPDU) []byte {
+	var b []byte
+	b = berAppend(b, berOctetString, engineId)
+	b = berAppend(b, berOctetString, nil)
+	b = append(b, pdu.marshal()...)
+	return berAppend(nil, berSequence, b)
+}
+
+// unmarshalScopedPDU decodes a ScopedPDU, returning its context name along
+// with the PDU.
+func unmarshalScopedPDU(b []byte) (contextName []byte, pdu snmpPDU, err error) {
+	content, _, err := berExpect(b, berSequence)
+	if err != nil {
+		return nil, pdu, err
+	}
+	if _, content, err = berExpect(content, berOctetString); err != nil {
+		return nil, pdu, err
+	}
+	if contextName, content, err = berExpect(content, berOctetString); err != nil {
+		return nil, pdu, err
+	}
+	pdu, err = unmarshalSNMPPDU(content)
+	return contextName, pdu, err
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// sparkplugNamespace is the first level of every Sparkplug B topic.
+const sparkplugNamespace = "spBv1.0"
+
+const sparkplugRebirthMetric = "Node Control/Rebirth"
+
+// sparkplugTimeout bounds the births and deaths published outside of a
+// reading: on connecting, on request, and when a device goes offline.
+const sparkplugTimeout = 30 * time.Second
+
+// sparkplugNode is the bridge as a Sparkplug B edge node, with a device for
+// each sensor. Its state is shared between readings, reconnects, rebirth
+// commands and the offline check, so it's kept behind a mutex.
+type sparkplugNode struct {
+	mu           sync.Mutex
+	group        string
+	node         string
+	bdSeq        atomic.Uint64 // birth/death sequence of the session, from 0; the same in NBIRTH and the NDEATH will
+	seq          uint64        // sequence of the next message
+	born         bool          // NBIRTH published on the current connection
+	nextAlias    uint64
+	devices      map[string]*sparkplugDevice
+	offlineAfter time.Duration
+	now          func() time.Time
+	stop         chan struct{} // closed to stop the offline check; nil if it isn't running
+}
+
+// sparkplugDevice is a sensor as a Sparkplug device.
+type sparkplugDevice struct {
+	metrics   []sparkplugMetric // as last published, with their aliases
+	signature string            // metric names and datatypes, to tell when to rebirth
+	lastSeen  time.Time
+	alive     bool // DBIRTH published, and no DDEATH since
+}
+
+func newSparkplugNode(cfg tomlConfigMQTT) *sparkplugNode {
+	n := &sparkplugNode{
+		group:        cfg.SparkplugGroupId,
+		node:         cfg.SparkplugEdgeNode,
+		nextAlias:    1,
+		devices:      map[string]*sparkplugDevice{},
+		offlineAfter: time.Duration(cfg.SparkplugOfflineAfter) * time.Second,
+		now:          time.Now,
+	}
+	return n
+}
+
+// sparkplugEdgeNode returns the edge node ID used when none is configured:
+// the client ID, or purpleair2mqtt without one.
+func sparkplugEdgeNode(clientId string) string {
+	if id := sanitizeTopicLevel(clientId); id != "" {
+		return id
+	}
+	return "purpleair2mqtt"
+}
+
+// topic returns the topic of a message type, for the node if device is
+// empty and for one of its devices otherwise.
+func (n *sparkplugNode) topic(messageType, device string) string {
+	topic := fmt.Sprintf("%s/%s/%s/%s", sparkplugNamespace, n.group, messageType, n.node)
+	if device != "" {
+		topic += "/" + device
+	}
+	return topic
+}
+
+func (n *sparkplugNode) nextSeq() uint64 {
+	seq := n.seq
+	n.seq = (n.seq + 1) % 256
+	return seq
+}
+
+func (n *sparkplugNode) timestamp() uint64 {
+	return uint64(n.now().UnixMilli())
+}
+
+// deathPayload is the NDEATH message: registered as the will, and published
+// on a clean shutdown.
+func (n *sparkplugNode) deathPayload() []byte {
+	return sparkplugPayload{
+		timestamp: n.timestamp(),
+		metrics:   []sparkplugMetric{{name: "bdSeq", datatype: sparkplugUInt64, value: n.bdSeq.Load()}},
+	}.marshal()
+}
+
+// nextSession moves on to the bdSeq of the next connection and returns the
+// will it needs, so the host can tell which NBIRTH an NDEATH ends. Only the
+// reconnect loop calls it, which is why bdSeq is atomic rather than behind
+// n.mu: a publish holding n.mu mustn't hold up reconnecting.
+func (n *sparkplugNode) nextSession() []byte {
+	n.bdSeq.Store((n.bdSeq.Load() + 1) % 256)
+	return n.deathPayload()
+}
+
+// sparkplugDeviceId returns the device a reading is published as: the
+// target's topic if set, otherwise the sensor's name or, failing that, its
+// MAC address, made safe for a topic.
+func (s *mqttSink) sparkplugDeviceId(status *purpleAirStatus) string {
+	for _, name := range []string{s.cfg.Topic, status.Geo, status.SensorId} {
+		if id := sanitizeTopicLevel(name); id != "" {
+			return id
+		}
+	}
+	return "purpleair"
+}
+
+// sparkplugMetrics turns the fields the target publishes into metrics,
+// named as {channel}/{field} like topics are, so channel fields are grouped
+// in a folder.
+func (s *mqttSink) sparkplugMetrics(fields []mqttField, timestamp uint64) []sparkplugMetric {
+	var metrics []sparkplugMetric
+	for _, f := range fields {
+		channel := f.channel
+		if channel != "" {
+			channel = styleFieldName(channel, s.cfg.FieldStyle)
+		}
+		name := styleFieldName(f.name, s.cfg.FieldStyle)
+		if !s.selected(sanitizeTopicLevel(channel), sanitizeTopicLevel(name)) {
+			continue
+		}
+		if channel != "" {
+			name = channel + "/" + name
+		}
+		m := sparkplugMetric{name: name, timestamp: timestamp}
+		v := reflect.ValueOf(f.value)
+		switch v.Kind() {
+		case reflect.Bool:
+			m.datatype, m.value = sparkplugBoolean, v.Bool()
+		case reflect.Int8, reflect.Int16, reflect.Int32:
+			m.datatype, m.value = sparkplugInt32, v.Int()
+		case reflect.Int, reflect.Int64:
+			m.datatype, m.value = sparkplugInt64, v.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			m.datatype, m.value = sparkplugUInt64, v.Uint()
+		case reflect.Float32:
+			m.datatype, m.value = sparkplugFloat, float32(v.Float())
+		case reflect.Float64:
+			m.datatype, m.value = sparkplugDouble, v.Float()
+		default:
+			m.datatype, m.value = sparkplugString, fmt.Sprintf("%v", f.value)
+		}
+		if f.channel == "" {
+			m.unit = fieldUnit(f.name)
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+// publishSparkplug publishes a reading as its sensor's device: DBIRTH, with
+// every metric's name, alias, datatype and unit, when the device is first
+// seen, comes back after a DDEATH or gains or loses metrics, and DDATA, by
+// alias, otherwise. The node is born first if it hasn't been on this
+// connection.
+func (s *mqttSink) publishSparkplug(ctx context.Context, status *purpleAirStatus, fields []mqttField) error {
+	n := s.sparkplug
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if !n.born {
+		if err := s.birthSparkplugNode(ctx); err != nil {
+			return err
+		}
+	}
+
+	id := s.sparkplugDeviceId(status)
+	metrics := s.sparkplugMetrics(fields, n.timestamp())
+	var signature strings.Builder
+	for _, m := range metrics {
+		fmt.Fprintf(&signature, "%s:%d\n", m.name, m.datatype)
+	}
+
+	d := n.devices[id]
+	if d == nil {
+		d = &sparkplugDevice{}
+		n.devices[id] = d
+	}
+	aliases := map[string]uint64{}
+	for _, m := range d.metrics {
+		aliases[m.name] = m.alias
+	}
+	for i := range metrics {
+		alias, ok := aliases[metrics[i].name]
+		if !ok {
+			alias = n.nextAlias
+			n.nextAlias++
+		}
+		metrics[i].alias, metrics[i].hasAlias = alias, true
+	}
+	d.lastSeen = n.now()
+
+	if !d.alive || d.signature != signature.String() {
+		d.metrics, d.signature = metrics, signature.String()
+		return s.birthSparkplugDevice(ctx, id, d)
+	}
+
+	d.metrics = metrics
+	data := make([]sparkplugMetric, len(metrics))
+	for i, m := range metrics {
+		// after a birth, metrics are identified by alias alone
+		m.name, m.unit = "", ""
+		data[i] = m
+	}
+	payload := sparkplugPayload{timestamp: n.timestamp(), metrics: data, seq: n.nextSeq(), hasSeq: true}
+	return s.send(ctx, n.topic("DDATA", id), 0, false, string(payload.marshal()))
+}
+
+// birthSparkplugNode publishes NBIRTH, restarting the sequence, then
+// DBIRTH for every device that's alive, so a host application that asked
+// for a rebirth, or missed the last one, learns every metric again. The
+// caller holds n.mu.
+func (s *mqttSink) birthSparkplugNode(ctx context.Context) error {
+	n := s.sparkplug
+	n.born = false
+	n.seq = 0
+	payload := sparkplugPayload{
+		timestamp: n.timestamp(),
+		metrics: []sparkplugMetric{
+			{name: "bdSeq", datatype: sparkplugUInt64, value: n.bdSeq.Load()},
+			{name: sparkplugRebirthMetric, datatype: sparkplugBoolean, value: false},
+		},
+		seq:    n.nextSeq(),
+		hasSeq: true,
+	}
+	logger.Infof("Publishing Sparkplug NBIRTH for %s/%s", n.group, n.node)
+	if err := s.send(ctx, n.topic("NBIRTH", ""), 0, false, string(payload.marshal())); err != nil {
+		return err
+	}
+	n.born = true
+
+	ids := make([]string, 0, len(n.devices))
+	for id, d := range n.devices {
+		if d.alive {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if err := s.birthSparkplugDevice(ctx, id, n.devices[id]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// birthSparkplugDevice publishes a device's DBIRTH with its last metrics.
+// The caller holds n.mu.
+func (s *mqttSink) birthSparkplugDevice(ctx context.Context, id string, d *sparkplugDevice) error {
+	n := s.sparkplug
+	d.alive = false
+	payload := sparkplugPayload{timestamp: n.timestamp(), metrics: d.metrics, seq: n.nextSeq(), hasSeq: true}
+	logger.Infof("Publishing Sparkplug DBIRTH for %s with %d metrics", id, len(d.metrics))
+	if err := s.send(ctx, n.topic("DBIRTH", id), 0, false, string(payload.marshal())); err != nil {
+		return err
+	}
+	d.alive = true
+	return nil
+}
+
+// expireSparkplugDevices publishes DDEATH for each device that hasn't had a
+// reading for the target's sparkplug_offline_after. The devices are marked
+// dead under n.mu, but their DDEATHs are published after, so a slow broker
+// doesn't hold up readings; a device whose DDEATH fails is expired again on
+// the next check, unless a reading has brought it back meanwhile.
+func (s *mqttSink) expireSparkplugDevices(ctx context.Context) error {
+	n := s.sparkplug
+	type death struct {
+		id       string
+		lastSeen time.Time
+		payload  sparkplugPayload
+	}
+	var deaths []death
+	n.mu.Lock()
+	if !n.born {
+		// the host already treats every device as offline
+		n.mu.Unlock()
+		return nil
+	}
+	for _, id := range sortedKeys(n.devices) {
+		d := n.devices[id]
+		if !d.alive || n.now().Sub(d.lastSeen) < n.offlineAfter {
+			continue
+		}
+		d.alive = false
+		payload := sparkplugPayload{timestamp: n.timestamp(), seq: n.nextSeq(), hasSeq: true}
+		deaths = append(deaths, death{id: id, lastSeen: d.lastSeen, payload: payload})
+	}
+	n.mu.Unlock()
+
+	var errs []error
+	for _, dd := range deaths {
+		logger.Warnf("No reading from %s for %s; publishing Sparkplug DDEATH", dd.id, n.offlineAfter)
+		if err := s.send(ctx, n.topic("DDEATH", dd.id), 0, false, string(dd.payload.marshal())); err != nil {
+			errs = append(errs, err)
+			n.mu.Lock()
+			if d := n.devices[dd.id]; d != nil && !d.alive && d.lastSeen.Equal(dd.lastSeen) {
+				d.alive = true
+			}
+			n.mu.Unlock()
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// watchSparkplugDevices checks for devices gone offline until Close.
+func (s *mqttSink) watchSparkplugDevices() {
+	interval := min(max(s.sparkplug.offlineAfter/4, time.Second), time.Minute)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.sparkplug.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), sparkplugTimeout)
+			if err := s.expireSparkplugDevices(ctx); err != nil {
+				logger.Errorf("Sparkplug: %v", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// sparkplugConnected is called on every connection: the node's births don't
+// survive a reconnect, so they're published again, and the node listens for
+// rebirth requests.
+func (s *mqttSink) sparkplugConnected(client mqtt.Client) {
+	n := s.sparkplug
+	client.Subscribe(n.topic("NCMD", ""), 1, func(_ mqtt.Client, msg mqtt.Message) {
+		go s.handleSparkplugCommand(msg.Payload())
+	})
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), sparkplugTimeout)
+		defer cancel()
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if err := s.birthSparkplugNode(ctx); err != nil {
+			logger.Errorf("Sparkplug: %v", err)
+		}
+	}()
+}
+
+// handleSparkplugCommand acts on an NCMD from a host application; the only
+// command the node accepts is a rebirth request.
+func (s *mqttSink) handleSparkplugCommand(b []byte) {
+	payload, err := unmarshalSparkplugPayload(b)
+	if err != nil {
+		logger.Warnf("Ignoring Sparkplug NCMD: %v", err)
+		return
+	}
+	for _, m := range payload.metrics {
+		if m.name != sparkplugRebirthMetric || m.value != true {
+			continue
+		}
+		logger.Infof("Sparkplug host requested a rebirth")
+		ctx, cancel := context.WithTimeout(context.Background(), sparkplugTimeout)
+		n := s.sparkplug
+		n.mu.Lock()
+		err := s.birthSparkplugNode(ctx)
+		n.mu.Unlock()
+		cancel()
+		if err != nil {
+			logger.Errorf("Sparkplug: %v", err)
+		}
+		return
+	}
+}
+
+// closeSparkplug publishes NDEATH, since a clean disconnect doesn't trigger
+// the will. Close has already stopped the offline check.
+func (s *mqttSink) closeSparkplug(ctx context.Context) error {
+	n := s.sparkplug
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.born = false
+	return s.send(ctx, n.topic("NDEATH", ""), 1, false, string(n.deathPayload()))
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSparkplugPayloadMarshal(t *testing.T) {
+	// Payload{timestamp: 1, metrics: [{name: "a", datatype: Boolean, boolean_value: true}], seq: 2}
+	expected := []byte{0x08, 0x01, 0x12, 0x07, 0x0a, 0x01, 'a', 0x20, 0x0b, 0x70, 0x01, 0x18, 0x02}
+	got := sparkplugPayload{
+		timestamp: 1,
+		metrics:   []sparkplugMetric{{name: "a", datatype: sparkplugBoolean, value: true}},
+		seq:       2,
+		hasSeq:    true,
+	}.marshal()
+	if !bytes.Equal(got, expected) {
+		t.Errorf("marshal() = % x, want % x", got, expected)
+	}
+}
+
+func TestSparkplugPayloadRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric sparkplugMetric
+	}{
+		{"Int32", sparkplugMetric{name: "i", datatype: sparkplugInt32, value: int64(-7)}},
+		{"Int64", sparkplugMetric{name: "l", datatype: sparkplugInt64, value: int64(-1 << 40)}},
+		{"UInt64", sparkplugMetric{name: "bdSeq", datatype: sparkplugUInt64, value: uint64(255)}},
+		{"Float", sparkplugMetric{name: "f", datatype: sparkplugFloat, value: float32(12.5)}},
+		{"Double", sparkplugMetric{name: "d", datatype: sparkplugDouble, value: 0.1}},
+		{"Boolean", sparkplugMetric{name: "b", datatype: sparkplugBoolean, value: false}},
+		{"String with unit", sparkplugMetric{name: "s", alias: 3, hasAlias: true, datatype: sparkplugString, unit: "°F", value: "Good"}},
+		{"Null", sparkplugMetric{alias: 9, hasAlias: true, datatype: sparkplugDouble, isNull: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := sparkplugPayload{timestamp: 1700000000000, metrics: []sparkplugMetric{tt.metric}, seq: 5, hasSeq: true}.marshal()
+			got, err := unmarshalSparkplugPayload(b)
+			if err != nil {
+				t.Fatalf("unmarshalSparkplugPayload() error = %v", err)
+			}
+			if got.timestamp != 1700000000000 || got.seq != 5 || !got.hasSeq || len(got.metrics) != 1 {
+				t.Fatalf("payload = %+v", got)
+			}
+			if got.metrics[0] != tt.metric {
+				t.Errorf("metric = %+v, want %+v", got.metrics[0], tt.metric)
+			}
+		})
+	}
+}
+
+func TestUnmarshalSparkplugPayloadTruncated(t *testing.T) {
+	b := sparkplugPayload{metrics: []sparkplugMetric{{name: "abc", datatype: sparkplugString, value: "x"}}}.marshal()
+	if _, err := unmarshalSparkplugPayload(b[:len(b)-2]); err == nil {
+		t.Errorf("unmarshalSparkplugPayload() error = nil, want error for a truncated payload")
+	}
+}
+
+// decodeSparkplug decodes the payload last published to topic.
+func decodeSparkplug(t *testing.T, client *fakeMQTTClient, topic string) sparkplugPayload {
+	t.Helper()
+	b, ok := client.published[topic]
+	if !ok {
+		t.Fatalf("nothing published to %s", topic)
+	}
+	p, err := unmarshalSparkplugPayload([]byte(b))
+	if err != nil {
+		t.Fatalf("decoding %s: %v", topic, err)
+	}
+	return p
+}
+
+func sparkplugMetricByName(metrics []sparkplugMetric, name string) (sparkplugMetric, bool) {
+	for _, m := range metrics {
+		if m.name == name {
+			return m, true
+		}
+	}
+	return sparkplugMetric{}, false
+}
+
+func TestMQTTSinkSparkplug(t *testing.T) {
+	client := &fakeMQTTClient{}
+	s := newTestMQTTSink(t, tomlConfigMQTT{Output: mqttOutputSparkplug, ClientId: "bridge", SparkplugOfflineAfter: 600}, client)
+	now := time.UnixMilli(1700000000000)
+	s.sparkplug.now = func() time.Time { return now }
+
+	status := &purpleAirStatus{Geo: "Back yard", EPAAQI: 42, PM25Atm: 12.5}
+	status.A.EPAAQI = 40
+	if err := s.Publish(context.Background(), status); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	nbirth := decodeSparkplug(t, client, "spBv1.0/PurpleAir/NBIRTH/bridge")
+	if nbirth.seq != 0 {
+		t.Errorf("NBIRTH seq = %d, want 0", nbirth.seq)
+	}
+	if m, ok := sparkplugMetricByName(nbirth.metrics, "bdSeq"); !ok || m.value != uint64(0) {
+		t.Errorf("NBIRTH bdSeq = %+v, want 0 for the first session", m)
+	}
+	if m, ok := sparkplugMetricByName(nbirth.metrics, sparkplugRebirthMetric); !ok || m.datatype != sparkplugBoolean {
+		t.Errorf("NBIRTH has no %s metric", sparkplugRebirthMetric)
+	}
+
+	dbirth := decodeSparkplug(t, client, "spBv1.0/PurpleAir/DBIRTH/bridge/Back_yard")
+	if dbirth.seq != 1 {
+		t.Errorf("DBIRTH seq = %d, want 1", dbirth.seq)
+	}
+	aqi, ok := sparkplugMetricByName(dbirth.metrics, "EPAAQI")
+	if !ok || aqi.datatype != sparkplugInt64 || aqi.value != int64(42) || !aqi.hasAlias {
+		t.Errorf("DBIRTH EPAAQI = %+v, want an aliased Int64 42", aqi)
+	}
+	if m, _ := sparkplugMetricByName(dbirth.metrics, "PM25Atm"); m.datatype != sparkplugFloat || m.value != float32(12.5) || m.unit != "µg/m³" {
+		t.Errorf("DBIRTH PM25Atm = %+v, want Float 12.5 in µg/m³", m)
+	}
+	if m, _ := sparkplugMetricByName(dbirth.metrics, "sensor_A/epa_aqi"); m.value != int64(40) {
+		t.Errorf("DBIRTH sensor_A/epa_aqi = %+v, want 40", m)
+	}
+	aliases := map[uint64]bool{}
+	for _, m := range dbirth.metrics {
+		if aliases[m.alias] {
+			t.Errorf("alias %d is used more than once", m.alias)
+		}
+		aliases[m.alias] = true
+	}
+
+	// the next reading is data, by alias
+	status.EPAAQI = 43
+	if err := s.Publish(context.Background(), status); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	ddata := decodeSparkplug(t, client, "spBv1.0/PurpleAir/DDATA/bridge/Back_yard")
+	if ddata.seq != 2 || len(ddata.metrics) != len(dbirth.metrics) {
+		t.Errorf("DDATA seq = %d with %d metrics, want 2 with %d", ddata.seq, len(ddata.metrics), len(dbirth.metrics))
+	}
+	found := false
+	for _, m := range ddata.metrics {
+		if m.name != "" {
+			t.Errorf("DDATA metric %s has a name as well as an alias", m.name)
+		}
+		if m.alias == aqi.alias {
+			found = true
+			if m.value != int64(43) {
+				t.Errorf("DDATA EPAAQI = %v, want 43", m.value)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("DDATA has no metric with EPAAQI's alias %d", aqi.alias)
+	}
+
+	// no reading for longer than sparkplug_offline_after
+	if err := s.expireSparkplugDevices(context.Background()); err != nil {
+		t.Fatalf("expireSparkplugDevices() error = %v", err)
+	}
+	if _, ok := client.published["spBv1.0/PurpleAir/DDEATH/bridge/Back_yard"]; ok {
+		t.Errorf("DDEATH published for a device with a recent reading")
+	}
+	now = now.Add(11 * time.Minute)
+	if err := s.expireSparkplugDevices(context.Background()); err != nil {
+		t.Fatalf("expireSparkplugDevices() error = %v", err)
+	}
+	if ddeath := decodeSparkplug(t, client, "spBv1.0/PurpleAir/DDEATH/bridge/Back_yard"); ddeath.seq != 3 {
+		t.Errorf("DDEATH seq = %d, want 3", ddeath.seq)
+	}
+
+	// the sensor coming back is born again, with the same aliases
+	if err := s.Publish(context.Background(), status); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	dbirth = decodeSparkplug(t, client, "spBv1.0/PurpleAir/DBIRTH/bridge/Back_yard")
+	if m, _ := sparkplugMetricByName(dbirth.metrics, "EPAAQI"); dbirth.seq != 4 || m.alias != aqi.alias {
+		t.Errorf("DBIRTH seq = %d, EPAAQI alias = %d; want 4 and %d", dbirth.seq, m.alias, aqi.alias)
+	}
+
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	ndeath := decodeSparkplug(t, client, "spBv1.0/PurpleAir/NDEATH/bridge")
+	if m, ok := sparkplugMetricByName(ndeath.metrics, "bdSeq"); !ok || m.value != s.sparkplug.bdSeq.Load() || ndeath.hasSeq {
+		t.Errorf("NDEATH = %+v, want bdSeq %d and no seq", ndeath, s.sparkplug.bdSeq.Load())
+	}
+	if _, ok := client.published["purpleair/bridge/availability"]; ok {
+		t.Errorf("availability was published by a Sparkplug node")
+	}
+}
+
+func TestSparkplugExpiryDoesNotHoldUpReadings(t *testing.T) {
+	client := &fakeMQTTClient{}
+	s := newTestMQTTSink(t, tomlConfigMQTT{Output: mqttOutputSparkplug, ClientId: "bridge", SparkplugOfflineAfter: 600}, client)
+	now := time.UnixMilli(1700000000000)
+	s.sparkplug.now = func() time.Time { return now }
+	if err := s.Publish(context.Background(), &purpleAirStatus{Geo: "Back yard", EPAAQI: 42}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	// the broker never acknowledges the DDEATH
+	now = now.Add(11 * time.Minute)
+	client.hung = true
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.expireSparkplugDevices(ctx) }()
+	n := s.sparkplug
+	waitFor(t, func() bool {
+		if !n.mu.TryLock() {
+			return false
+		}
+		defer n.mu.Unlock()
+		return !n.devices["Back_yard"].alive
+	})
+
+	// so it's expired again on the next check
+	cancel()
+	if err := <-done; err == nil {
+		t.Fatal("expireSparkplugDevices() error = nil, want the DDEATH to time out")
+	}
+	if !n.devices["Back_yard"].alive {
+		t.Errorf("device whose DDEATH failed was left dead")
+	}
+}
+
+func TestMQTTSinkSparkplugCloseConnected(t *testing.T) {
+	client := &fakeMQTTClient{}
+	s := newTestMQTTSink(t, tomlConfigMQTT{Output: mqttOutputSparkplug, ClientId: "bridge"}, client)
+	// as newMQTTSink starts it
+	s.sparkplug.stop = make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.watchSparkplugDevices()
+		close(done)
+	}()
+
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the offline check is still running after Close")
+	}
+	decodeSparkplug(t, client, "spBv1.0/PurpleAir/NDEATH/bridge")
+}
+
+func TestSparkplugNextSession(t *testing.T) {
+	client := &fakeMQTTClient{}
+	s := newTestMQTTSink(t, tomlConfigMQTT{Output: mqttOutputSparkplug, ClientId: "bridge"}, client)
+	s.sparkplug.bdSeq.Store(255)
+
+	// as a reconnect does, before NBIRTH is published on the new connection
+	will, err := unmarshalSparkplugPayload(s.sparkplug.nextSession())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := sparkplugMetricByName(will.metrics, "bdSeq"); m.value != uint64(0) {
+		t.Errorf("will bdSeq = %v, want 0 after 255", m.value)
+	}
+	if err := s.Publish(context.Background(), &purpleAirStatus{Geo: "backyard", EPAAQI: 42}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	nbirth := decodeSparkplug(t, client, "spBv1.0/PurpleAir/NBIRTH/bridge")
+	if m, _ := sparkplugMetricByName(nbirth.metrics, "bdSeq"); m.value != uint64(0) {
+		t.Errorf("NBIRTH bdSeq = %v, want the will's 0", m.value)
+	}
+
+	will, err = unmarshalSparkplugPayload(s.sparkplug.nextSession())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := sparkplugMetricByName(will.metrics, "bdSeq"); m.value != uint64(1) {
+		t.Errorf("next will bdSeq = %v, want 1", m.value)
+	}
+}
+
+func TestMQTTSinkSparkplugRebirth(t *testing.T) {
+	client := &fakeMQTTClient{}
+	s := newTestMQTTSink(t, tomlConfigMQTT{Output: mqttOutputSparkplug}, client)
+	if err := s.Publish(context.Background(), &purpleAirStatus{Geo: "backyard", EPAAQI: 42}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	client.published = nil
+
+	s.handleSparkplugCommand(sparkplugPayload{metrics: []sparkplugMetric{
+		{name: sparkplugRebirthMetric, datatype: sparkplugBoolean, value: true},
+	}}.marshal())
+
+	if nbirth := decodeSparkplug(t, client, "spBv1.0/PurpleAir/NBIRTH/purpleair2mqtt"); nbirth.seq != 0 {
+		t.Errorf("NBIRTH seq = %d, want 0", nbirth.seq)
+	}
+	dbirth := decodeSparkplug(t, client, "spBv1.0/PurpleAir/DBIRTH/purpleair2mqtt/backyard")
+	if m, ok := sparkplugMetricByName(dbirth.metrics, "EPAAQI"); dbirth.seq != 1 || !ok || m.value != int64(42) {
+		t.Errorf("DBIRTH after rebirth = %+v, want seq 1 with EPAAQI 42", dbirth)
+	}
+
+	// other commands are ignored
+	client.published = nil
+	s.handleSparkplugCommand(sparkplugPayload{metrics: []sparkplugMetric{
+		{name: "Node Control/Reboot", datatype: sparkplugBoolean, value: true},
+	}}.marshal())
+	if len(client.published) != 0 {
+		t.Errorf("published %v for an unsupported command", client.published)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Sparkplug B metric datatypes used by the bridge, from the Tahu payload
+// definition (sparkplug_b.proto).
+const (
+	sparkplugInt32   uint32 = 3
+	sparkplugInt64   uint32 = 4
+	sparkplugUInt64  uint32 = 8
+	sparkplugFloat   uint32 = 9
+	sparkplugDouble  uint32 = 10
+	sparkplugBoolean uint32 = 11
+	sparkplugString  uint32 = 12
+)
+
+// sparkplugPayload is an org.eclipse.tahu.protobuf.Payload, limited to what
+// the bridge sends and reads back: no datasets, templates or bodies.
+type sparkplugPayload struct {
+	timestamp uint64 // milliseconds since the epoch
+	metrics   []sparkplugMetric
+	seq       uint64
+	hasSeq    bool // NDEATH is the only message without one
+}
+
+// sparkplugMetric is a single Payload.Metric. value holds an int64 for the
+// signed integer types, a uint64 for the unsigned ones, and a float32,
+// float64, bool or string otherwise.
+type sparkplugMetric struct {
+	name      string
+	alias     uint64
+	hasAlias  bool
+	timestamp uint64
+	datatype  uint32
+	isNull    bool
+	unit      string // the engUnit property, sent in births
+	value     interface{}
+}
+
+// Payload, Metric, PropertySet and PropertyValue field numbers.
+const (
+	payloadTimestamp protowire.Number = 1
+	payloadMetrics   protowire.Number = 2
+	payloadSeq       protowire.Number = 3
+
+	metricName         protowire.Number = 1
+	metricAlias        protowire.Number = 2
+	metricTimestamp    protowire.Number = 3
+	metricDatatype     protowire.Number = 4
+	metricIsNull       protowire.Number = 7
+	metricProperties   protowire.Number = 9
+	metricIntValue     protowire.Number = 10
+	metricLongValue    protowire.Number = 11
+	metricFloatValue   protowire.Number = 12
+	metricDoubleValue  protowire.Number = 13
+	metricBooleanValue protowire.Number = 14
+	metricStringValue  protowire.Number = 15
+
+	propertySetKeys   protowire.Number = 1
+	propertySetValues protowire.Number = 2
+
+	propertyValueType   protowire.Number = 1
+	propertyValueString protowire.Number = 8
+)
+
+func (p sparkplugPayload) marshal() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, payloadTimestamp, protowire.VarintType)
+	b = protowire.AppendVarint(b, p.timestamp)
+	for _, m := range p.metrics {
+		b = protowire.AppendTag(b, payloadMetrics, protowire.BytesType)
+		b = protowire.AppendBytes(b, m.marshal())
+	}
+	if p.hasSeq {
+		b = protowire.AppendTag(b, payloadSeq, protowire.VarintType)
+		b = protowire.AppendVarint(b, p.seq)
+	}
+	return b
+}
+
+func (m sparkplugMetric) marshal() []byte {
+	var b []byte
+	if m.name != "" {
+		b = protowire.AppendTag(b, metricName, protowire.BytesType)
+		b = protowire.AppendString(b, m.name)
+	}
+	if m.hasAlias {
+		b = protowire.AppendTag(b, metricAlias, protowire.VarintType)
+		b = protowire.AppendVarint(b, m.alias)
+	}
+	if m.timestamp != 0 {
+		b = protowire.AppendTag(b, metricTimestamp, protowire.VarintType)
+		b = protowire.AppendVarint(b, m.timestamp)
+	}
+	b = protowire.AppendTag(b, metricDatatype, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(m.datatype))
+	if m.unit != "" {
+		b = protowire.AppendTag(b, metricProperties, protowire.BytesType)
+		b = protowire.AppendBytes(b, marshalSparkplugUnit(m.unit))
+	}
+	if m.isNull {
+		b = protowire.AppendTag(b, metricIsNull, protowire.VarintType)
+		return protowire.AppendVarint(b, protowire.EncodeBool(true))
+	}
+
+	switch v := m.value.(type) {
+	case int64:
+		if m.datatype == sparkplugInt64 {
+			b = protowire.AppendTag(b, metricLongValue, protowire.VarintType)
+			b = protowire.AppendVarint(b, uint64(v))
+		} else {
+			b = protowire.AppendTag(b, metricIntValue, protowire.VarintType)
+			b = protowire.AppendVarint(b, uint64(uint32(int32(v))))
+		}
+	case uint64:
+		b = protowire.AppendTag(b, metricLongValue, protowire.VarintType)
+		b = protowire.AppendVarint(b, v)
+	case float32:
+		b = protowire.AppendTag(b, metricFloatValue, protowire.Fixed32Type)
+		b = protowire.AppendFixed32(b, math.Float32bits(v))
+	case float64:
+		b = protowire.AppendTag(b, metricDoubleValue, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(v))
+	case bool:
+		b = protowire.AppendTag(b, metricBooleanValue, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(v))
+	case string:
+		b = protowire.AppendTag(b, metricStringValue, protowire.BytesType)
+		b = protowire.AppendString(b, v)
+	}
+	return b
+}
+
+// marshalSparkplugUnit encodes a PropertySet holding just engUnit, the
+// property Ignition and other hosts read a metric's unit from.
+func marshalSparkplugUnit(unit string) []byte {
+	var value []byte
+	value = protowire.AppendTag(value, propertyValueType, protowire.VarintType)
+	value = protowire.AppendVarint(value, uint64(sparkplugString))
+	value = protowire.AppendTag(value, propertyValueString, protowire.BytesType)
+	value = protowire.AppendString(value, unit)
+
+	var b []byte
+	b = protowire.AppendTag(b, propertySetKeys, protowire.BytesType)
+	b = protowire.AppendString(b, "engUnit")
+	b = protowire.AppendTag(b, propertySetValues, protowire.BytesType)
+	return protowire.AppendBytes(b, value)
+}
+
+// unmarshalSparkplugPayload decodes a payload, such as a command from a host
+// application. Fields the bridge doesn't use are skipped.
+func unmarshalSparkplugPayload(b []byte) (sparkplugPayload, error) {
+	var p sparkplugPayload
+	err := walkProto(b, func(num protowire.Number, typ protowire.Type, v uint64, data []byte) error {
+		switch {
+		case num == payloadTimestamp && typ == protowire.VarintType:
+			p.timestamp = v
+		case num == payloadSeq && typ == protowire.VarintType:
+			p.seq, p.hasSeq = v, true
+		case num == payloadMetrics && typ == protowire.BytesType:
+			m, err := unmarshalSparkplugMetric(data)
+			if err != nil {
+				return err
+			}
+			p.metrics = append(p.metrics, m)
+		}
+		return nil
+	})
+	return p, err
+}
+
+func unmarshalSparkplugMetric(b []byte) (sparkplugMetric, error) {
+	var m sparkplugMetric
+	var raw uint64
+	var hasInt, hasLong bool
+	err := walkProto(b, func(num protowire.Number, typ protowire.Type, v uint64, data []byte) error {
+		switch num {
+		case metricName:
+			m.name = string(data)
+		case metricAlias:
+			m.alias, m.hasAlias = v, true
+		case metricTimestamp:
+			m.timestamp = v
+		case metricDatatype:
+			m.datatype = uint32(v)
+		case metricIsNull:
+			m.isNull = protowire.DecodeBool(v)
+		case metricProperties:
+			unit, err := unmarshalSparkplugUnit(data)
+			if err != nil {
+				return err
+			}
+			m.unit = unit
+		case metricIntValue:
+			raw, hasInt = v, true
+		case metricLongValue:
+			raw, hasLong = v, true
+		case metricFloatValue:
+			m.value = math.Float32frombits(uint32(v))
+		case metricDoubleValue:
+			m.value = math.Float64frombits(v)
+		case metricBooleanValue:
+			m.value = protowire.DecodeBool(v)
+		case metricStringValue:
+			m.value = string(data)
+		}
+		return nil
+	})
+	switch {
+	case hasInt && m.datatype <= sparkplugInt64:
+		m.value = int64(int32(uint32(raw)))
+	case hasLong && m.datatype <= sparkplugInt64:
+		m.value = int64(raw)
+	case hasInt || hasLong:
+		m.value = raw
+	}
+	return m, err
+}
+
+// unmarshalSparkplugUnit returns the engUnit property from a PropertySet.
+func unmarshalSparkplugUnit(b []byte) (string, error) {
+	var keys, values []string
+	err := walkProto(b, func(num protowire.Number, typ protowire.Type, v uint64, data []byte) error {
+		switch num {
+		case propertySetKeys:
+			keys = append(keys, string(data))
+		case propertySetValues:
+			var value string
+			err := walkProto(data, func(num protowire.Number, typ protowire.Type, v uint64, data []byte) error {
+				if num == propertyValueString {
+					value = string(data)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			values = append(values, value)
+		}
+		return nil
+	})
+	for i, key := range keys {
+		if key == "engUnit" && i < len(values) {
+			return values[i], err
+		}
+	}
+	return "", err
+}
+
+// walkProto calls fn with each field of a protobuf message: v holds varint
+// and fixed-width values, and data the contents of length-delimited ones.
+func walkProto(b []byte, fn func(num protowire.Number, typ protowire.Type, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("decoding protobuf message: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		var v uint64
+		var data []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed32Type:
+			var v32 uint32
+			v32, n = protowire.ConsumeFixed32(b)
+			v = uint64(v32)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			data, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return fmt.Errorf("decoding protobuf message: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		if err := fn(num, typ, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	fieldStyleGo    = "go"
+	fieldStyleSnake = "snake_case"
+	fieldStyleCamel = "camelCase"
+)
+
+// Topic layouts. The legacy layout names fields as their Go struct fields,
+// and channel fields in snake_case; v2 uses the target's field style, which
+// defaults to snake_case throughout. both publishes every field in the v2
+// layout and again in the legacy one, so automations can move over before
+// the legacy topics are turned off.
+const (
+	topicLayoutLegacy = "legacy"
+	topicLayoutV2     = "v2"
+	topicLayoutBoth   = "both"
+)
+
+// defaultFieldStyle returns the field style a layout uses unless one is set.
+func defaultFieldStyle(layout string) string {
+	if layout == topicLayoutLegacy {
+		return fieldStyleGo
+	}
+	return fieldStyleSnake
+}
+
+// defaultTopicTemplate gives the topics published before templates could be
+// configured, e.g. purpleair/backyard/EPAAQI and
+// purpleair/backyard/sensor_A/epa_aqi; {channel} is empty, and its level
+// dropped, for fields that aren't per-channel.
+const defaultTopicTemplate = "{prefix}/{geo}/{channel}/{field}"
+
+var topicPlaceholders = []string{"{prefix}", "{geo}", "{sensor_id}", "{channel}", "{field}"}
+
+var topicPlaceholderPattern = regexp.MustCompile(`\{[^}]*\}`)
+
+// checkTopicTemplate returns an error describing the first problem with a
+// topic template, or nil if it's usable.
+func checkTopicTemplate(template string) error {
+	for _, p := range topicPlaceholderPattern.FindAllString(template, -1) {
+		if !isTopicPlaceholder(p) {
+			return fmt.Errorf("unknown placeholder %s; use %s", p, strings.Join(topicPlaceholders, ", "))
+		}
+	}
+	if !strings.Contains(template, "{field}") {
+		return fmt.Errorf("must contain {field}, or every field would be published to the same topic")
+	}
+	if strings.ContainsAny(topicPlaceholderPattern.ReplaceAllString(template, ""), "+#") {
+		return fmt.Errorf("must not contain the wildcards + or #")
+	}
+	return nil
+}
+
+func isTopicPlaceholder(p string) bool {
+	for _, known := range topicPlaceholders {
+		if p == known {
+			return true
+		}
+	}
+	return false
+}
+
+// topicValues are what a template's placeholders are replaced with. Each is
+// sanitized into a single topic level, except the configured prefix and
+// topic, which may span several.
+type topicValues struct {
+	prefix   string
+	topic    string // replaces {geo} if set
+	geo      string
+	sensorId string
+	channel  string
+	field    string
+}
+
+// renderTopic fills in template. Levels left empty by an empty placeholder,
+// such as {channel} for an overall field, are dropped.
+func renderTopic(template string, v topicValues) string {
+	geo := sanitizeTopicLevel(v.geo)
+	if v.topic != "" {
+		geo = sanitizeTopicLevels(v.topic)
+	}
+	topic := strings.NewReplacer(
+		"{prefix}", sanitizeTopicLevels(v.prefix),
+		"{geo}", geo,
+		"{sensor_id}", sanitizeTopicLevel(v.sensorId),
+		"{channel}", sanitizeTopicLevel(v.channel),
+		"{field}", sanitizeTopicLevel(v.field),
+	).Replace(template)
+
+	var levels []string
+	for _, level := range strings.Split(topic, "/") {
+		if level != "" {
+			levels = append(levels, level)
+		}
+	}
+	return strings.Join(levels, "/")
+}
+
+// sanitizeTopicLevels sanitizes each level of a configured topic.
+func sanitizeTopicLevels(topic string) string {
+	levels := strings.Split(topic, "/")
+	for i, level := range levels {
+		levels[i] = sanitizeTopicLevel(level)
+	}
+	return strings.Join(levels, "/")
+}
+
+// sanitizeTopicLevel makes s safe to use as a single topic level: the level
+// separator, wildcards, whitespace, control characters and invalid UTF-8 are
+// replaced with underscores, and a leading $ (reserved for broker topics such
+// as $SYS) is dropped.
+func sanitizeTopicLevel(s string) string {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "$")
+	if !utf8.ValidString(s) {
+		s = strings.ToValidUTF8(s, "_")
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '+' || r == '#' || unicode.IsSpace(r) || unicode.IsControl(r) {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// styleFieldName renames a field in the given style. Go struct field names
+// (EPAAQIColorRGB) and the snake_case names of channel fields and device keys
+// (epa_aqi_color_rgb) are both accepted; the go style leaves either as is.
+func styleFieldName(name, style string) string {
+	switch style {
+	case fieldStyleSnake:
+		return strings.ToLower(strings.Join(fieldWords(name), "_"))
+	case fieldStyleCamel:
+		words := fieldWords(name)
+		for i, w := range words {
+			w = strings.ToLower(w)
+			if i > 0 {
+				w = strings.ToUpper(w[:1]) + w[1:]
+			}
+			words[i] = w
+		}
+		return strings.Join(words, "")
+	default:
+		return name
+	}
+}
+
+// fieldWords splits a field name into words: at underscores and other
+// punctuation, where a lower-case letter or digit meets an upper-case one,
+// and before the last capital of an acronym followed by a word, so
+// PM25AqiColorB is PM25 Aqi Color B. Digits stay with the word they follow,
+// as in pm25. The EPA fields run two acronyms together, so EPA is always a
+// word of its own.
+func fieldWords(name string) []string {
+	if rest, ok := strings.CutPrefix(name, "EPA"); ok && rest != "" && unicode.IsUpper(rune(rest[0])) {
+		return append([]string{"EPA"}, fieldWords(rest)...)
+	}
+
+	var words []string
+	var word []rune
+	runes := []rune(name)
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, string(word))
+			word = nil
+		}
+	}
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush()
+			continue
+		}
+		if len(word) > 0 {
+			prev := word[len(word)-1]
+			switch {
+			case unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsDigit(prev)):
+				flush()
+			case unicode.IsUpper(r) && unicode.IsUpper(prev) && i+1 < len(runes) && unicode.IsLower(runes[i+1]):
+				flush()
+			}
+		}
+		word = append(word, r)
+	}
+	flush()
+	return words
+}
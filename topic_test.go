@@ -0,0 +1,82 @@
+package main
+
+import "testing"
+
+func TestRenderTopic(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		values   topicValues
+		expected string
+	}{
+		{"Default", defaultTopicTemplate, topicValues{prefix: "purpleair", geo: "PurpleAir-1234", field: "EPAAQI"}, "purpleair/PurpleAir-1234/EPAAQI"},
+		{"Default with channel", defaultTopicTemplate, topicValues{prefix: "purpleair", geo: "backyard", channel: "sensor_A", field: "epa_aqi"}, "purpleair/backyard/sensor_A/epa_aqi"},
+		{"Configured topic", defaultTopicTemplate, topicValues{prefix: "home/air", topic: "garden/north", geo: "ignored", field: "EPAAQI"}, "home/air/garden/north/EPAAQI"},
+		{"Unsafe geo", defaultTopicTemplate, topicValues{prefix: "purpleair", geo: "Back yard/#1+", field: "EPAAQI"}, "purpleair/Back_yard__1_/EPAAQI"},
+		{"Reserved geo", "{geo}/{field}", topicValues{geo: "$SYS", field: "EPAAQI"}, "SYS/EPAAQI"},
+		{"Sensor ID", "aq/{sensor_id}/{field}/{channel}", topicValues{sensorId: "84:f3:eb:00:00:01", channel: "a", field: "pm2_5"}, "aq/84:f3:eb:00:00:01/pm2_5/a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renderTopic(tt.template, tt.values); got != tt.expected {
+				t.Errorf("renderTopic() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCheckTopicTemplate(t *testing.T) {
+	tests := []struct {
+		template    string
+		expectedErr bool
+	}{
+		{defaultTopicTemplate, false},
+		{"sensors/{sensor_id}/{field}", false},
+		{"{prefix}/{geo}", true},
+		{"{prefix}/{location}/{field}", true},
+		{"{prefix}/+/{field}", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			if err := checkTopicTemplate(tt.template); (err != nil) != tt.expectedErr {
+				t.Errorf("checkTopicTemplate() error = %v, want error: %v", err, tt.expectedErr)
+			}
+		})
+	}
+}
+
+func TestStyleFieldName(t *testing.T) {
+	tests := []struct {
+		name          string
+		expectedSnake string
+		expectedCamel string
+	}{
+		{"EPAAQI", "epa_aqi", "epaAqi"},
+		{"EPAPM25AQI", "epa_pm25_aqi", "epaPm25Aqi"},
+		{"EPAAQIColorRGB", "epa_aqi_color_rgb", "epaAqiColorRgb"},
+		{"PM25AtmB", "pm25_atm_b", "pm25AtmB"},
+		{"TsSLatency", "ts_s_latency", "tsSLatency"},
+		{"HttpSuccess", "http_success", "httpSuccess"},
+		{"SSID", "ssid", "ssid"},
+		{"Temperature680", "temperature680", "temperature680"},
+		{"epa_pm25_aqi", "epa_pm25_aqi", "epaPm25Aqi"},
+		{"sensor_A", "sensor_a", "sensorA"},
+		{"pm2.5_aqi", "pm2_5_aqi", "pm25Aqi"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := styleFieldName(tt.name, fieldStyleGo); got != tt.name {
+				t.Errorf("go style = %q, want %q", got, tt.name)
+			}
+			if got := styleFieldName(tt.name, fieldStyleSnake); got != tt.expectedSnake {
+				t.Errorf("snake_case = %q, want %q", got, tt.expectedSnake)
+			}
+			if got := styleFieldName(tt.name, fieldStyleCamel); got != tt.expectedCamel {
+				t.Errorf("camelCase = %q, want %q", got, tt.expectedCamel)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+)
+
+// webhook settings for POSTing readings to arbitrary HTTP endpoints
+type tomlConfigWebhook struct {
+	Urls        []string          // URLs that each reading is POSTed to
+	Headers     map[string]string // extra headers sent with every request
+	Template    string            // Go text/template for the request body; the reading is sent as JSON if empty
+	ContentType string            // Content-Type header (default: application/json)
+	Timeout     int               // Timeout in seconds for HTTP requests
+	Attempts    int               // Number of attempts per URL before giving up (default: 3)
+}
+
+// webhookSink POSTs each reading to one or more HTTP endpoints.
+type webhookSink struct {
+	cfg      tomlConfigWebhook
+	template *template.Template
+	client   *http.Client
+}
+
+func newWebhookSink(cfg tomlConfigWebhook) (*webhookSink, error) {
+	s := &webhookSink{cfg: cfg}
+	if cfg.Template != "" {
+		t, err := newPayloadTemplate("webhook", cfg.Template)
+		if err != nil {
+			return nil, fmt.Errorf("parsing webhook template: %w", err)
+		}
+		s.template = t
+	}
+	if s.cfg.ContentType == "" {
+		s.cfg.ContentType = "application/json"
+	}
+	if s.cfg.Attempts <= 0 {
+		s.cfg.Attempts = 3
+	}
+	timeout := 15
+	if cfg.Timeout > 0 {
+		timeout = cfg.Timeout
+	}
+	s.client = &http.Client{Timeout: time.Duration(timeout) * time.Second}
+	return s, nil
+}
+
+func (s *webhookSink) Name() string {
+	return "webhook"
+}
+
+// renderBody renders the request body for a reading, either through the
+// configured template or as the JSON encoding of the reading.
+func (s *webhookSink) renderBody(status *purpleAirStatus) ([]byte, error) {
+	if s.template == nil {
+		return json.Marshal(status)
+	}
+	body, err := executePayload(s.template, status)
+	return []byte(body), err
+}
+
+// Publish POSTs the reading to every URL at once, so a slow endpoint doesn't
+// use up the others' share of the publish timeout.
+func (s *webhookSink) Publish(ctx context.Context, status *purpleAirStatus) error {
+	body, err := s.renderBody(status)
+	if err != nil {
+		return fmt.Errorf("error rendering webhook body: %w", err)
+	}
+
+	errs := make([]error, len(s.cfg.Urls))
+	var wg sync.WaitGroup
+	for i, url := range s.cfg.Urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := retry.Do(
+				func() error {
+					return s.post(ctx, url, body)
+				},
+				retry.Context(ctx),
+				retry.Attempts(uint(s.cfg.Attempts)),
+				retry.Delay(1*time.Second),
+				retry.DelayType(retry.BackOffDelay),
+				retry.OnRetry(func(n uint, err error) {
+					logger.Warnf("Webhook %s attempt %d failed: %v", url, n+1, err)
+				}),
+			)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", url, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (s *webhookSink) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return retry.Unrecoverable(err)
+	}
+	req.Header.Set("Content-Type", s.cfg.ContentType)
+	for k, v := range s.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	r, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Body.Close() }()
+	_, _ = io.Copy(io.Discard, r.Body)
+
+	if r.StatusCode < 200 || r.StatusCode > 299 {
+		return fmt.Errorf("unexpected HTTP status %s", r.Status)
+	}
+	return nil
+}
+
+func (s *webhookSink) Close(ctx context.Context) error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookSinkPublish(t *testing.T) {
+	tests := []struct {
+		name         string
+		template     string
+		expectedBody string
+	}{
+		{"Template", `{"aqi": {{.EPAAQI}}, "geo": "{{.Geo}}"}`, `{"aqi": 42, "geo": "backyard"}`},
+		{"Helpers", `{"aqi": {{.EPAAQI}}, "color": "{{categoryColor .EPAAQI}}", "geo": {{toJSON .Geo}}}`, `{"aqi": 42, "color": "#00e400", "geo": "backyard"}`},
+		{"Default JSON", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotBody, gotAuth, gotContentType string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				gotBody = string(b)
+				gotAuth = r.Header.Get("Authorization")
+				gotContentType = r.Header.Get("Content-Type")
+			}))
+			defer srv.Close()
+
+			s, err := newWebhookSink(tomlConfigWebhook{
+				Urls:     []string{srv.URL},
+				Headers:  map[string]string{"Authorization": "Bearer secret"},
+				Template: tt.template,
+			})
+			if err != nil {
+				t.Fatalf("newWebhookSink() error = %v", err)
+			}
+
+			if err := s.Publish(context.Background(), &purpleAirStatus{Geo: "backyard", EPAAQI: 42}); err != nil {
+				t.Fatalf("Publish() error = %v", err)
+			}
+
+			if tt.expectedBody != "" && gotBody != tt.expectedBody {
+				t.Errorf("body = %s, want %s", gotBody, tt.expectedBody)
+			}
+			if tt.expectedBody == "" {
+				var decoded purpleAirStatus
+				if err := json.Unmarshal([]byte(gotBody), &decoded); err != nil {
+					t.Fatalf("body %q isn't a JSON reading: %v", gotBody, err)
+				}
+				if decoded.Geo != "backyard" || decoded.EPAAQI != 42 {
+					t.Errorf("body decodes to Geo %q, EPAAQI %d, want backyard and 42", decoded.Geo, decoded.EPAAQI)
+				}
+			}
+			if gotAuth != "Bearer secret" {
+				t.Errorf("Authorization = %s, want Bearer secret", gotAuth)
+			}
+			if gotContentType != "application/json" {
+				t.Errorf("Content-Type = %s, want application/json", gotContentType)
+			}
+		})
+	}
+}
+
+func TestWebhookSinkPublishError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	s, err := newWebhookSink(tomlConfigWebhook{Urls: []string{srv.URL}, Attempts: 1})
+	if err != nil {
+		t.Fatalf("newWebhookSink() error = %v", err)
+	}
+	if err := s.Publish(context.Background(), &purpleAirStatus{}); err == nil {
+		t.Errorf("Publish() error = nil, want error for HTTP 500")
+	}
+}
+
+func TestWebhookSinkSlowURL(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	defer close(release)
+	var received atomic.Bool
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Store(true)
+	}))
+	defer fast.Close()
+
+	s, err := newWebhookSink(tomlConfigWebhook{Urls: []string{slow.URL, fast.URL}, Attempts: 1})
+	if err != nil {
+		t.Fatalf("newWebhookSink() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := s.Publish(ctx, &purpleAirStatus{}); err == nil {
+		t.Errorf("Publish() error = nil, want the slow URL's timeout")
+	}
+	if !received.Load() {
+		t.Errorf("the slow URL held up the other")
+	}
+}